@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"reflect"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/data"
@@ -22,145 +24,54 @@ type Converter struct {
 var matchRegexes = map[string]*regexp.Regexp{
 	// for complex Arrays e.g. Array(Tuple)
 	"Array()":                   regexp.MustCompile(`^Array\(.*\)`),
-	"Date":                      regexp.MustCompile(`^Date\(?`),
-	"Decimal":                   regexp.MustCompile(`^Decimal`),
 	"Map()":                     regexp.MustCompile(`^Map\(.*\)`),
-	"Nullable(Date)":            regexp.MustCompile(`^Nullable\(Date\(?`),
-	"Nullable(Decimal)":         regexp.MustCompile(`^Nullable\(Decimal`),
-	"Nullable(String)":          regexp.MustCompile(`Nullable\(String`),
 	"SimpleAggregateFunction()": regexp.MustCompile(`^SimpleAggregateFunction\(.*\)`),
 	"Tuple()":                   regexp.MustCompile(`^Tuple\(.*\)`),
 }
 
-var Converters = map[string]Converter{
-	"Boolean": {
-		fieldType: data.FieldTypeBool,
-		scanType:  reflect.PtrTo(reflect.TypeOf(true)),
-	},
-	"Nullable(Boolean)": {
-		fieldType: data.FieldTypeNullableBool,
-		scanType:  reflect.PtrTo(reflect.PtrTo(reflect.TypeOf(true))),
-	},
-	"Float64": {
-		fieldType: data.FieldTypeFloat64,
-		scanType:  reflect.PtrTo(reflect.TypeOf(float64(0))),
-	},
-	"Float32": {
-		fieldType: data.FieldTypeFloat32,
-		scanType:  reflect.PtrTo(reflect.TypeOf(float32(0))),
-	},
-	"Nullable(Float32)": {
-		fieldType: data.FieldTypeNullableFloat32,
-		scanType:  reflect.PtrTo(reflect.PtrTo(reflect.TypeOf(float32(0)))),
-	},
-	"Nullable(Float64)": {
-		fieldType: data.FieldTypeNullableFloat64,
-		scanType:  reflect.PtrTo(reflect.PtrTo(reflect.TypeOf(float64(0)))),
-	},
-	"Int64": {
-		fieldType: data.FieldTypeInt64,
-		scanType:  reflect.PtrTo(reflect.TypeOf(int64(0))),
-	},
-	"Int32": {
-		fieldType: data.FieldTypeInt32,
-		scanType:  reflect.PtrTo(reflect.TypeOf(int32(0))),
-	},
-	"Int16": {
-		fieldType: data.FieldTypeInt16,
-		scanType:  reflect.PtrTo(reflect.TypeOf(int16(0))),
-	},
-	"Int8": {
-		fieldType: data.FieldTypeInt8,
-		scanType:  reflect.PtrTo(reflect.TypeOf(int8(0))),
-	},
-	"UInt64": {
-		fieldType: data.FieldTypeUint64,
-		scanType:  reflect.PtrTo(reflect.TypeOf(uint64(0))),
-	},
-	"UInt32": {
-		fieldType: data.FieldTypeUint32,
-		scanType:  reflect.PtrTo(reflect.TypeOf(uint32(0))),
-	},
-	"UInt16": {
-		fieldType: data.FieldTypeUint16,
-		scanType:  reflect.PtrTo(reflect.TypeOf(uint16(0))),
-	},
-	"UInt8": {
-		fieldType: data.FieldTypeUint8,
-		scanType:  reflect.PtrTo(reflect.TypeOf(uint8(0))),
-	},
-	"Nullable(UInt64)": {
-		fieldType: data.FieldTypeNullableUint64,
-		scanType:  reflect.PtrTo(reflect.PtrTo(reflect.TypeOf(uint64(0)))),
-	},
-	"Nullable(UInt32)": {
-		fieldType: data.FieldTypeNullableUint32,
-		scanType:  reflect.PtrTo(reflect.PtrTo(reflect.TypeOf(uint32(0)))),
-	},
-	"Nullable(UInt16)": {
-		fieldType: data.FieldTypeNullableUint16,
-		scanType:  reflect.PtrTo(reflect.PtrTo(reflect.TypeOf(uint16(0)))),
-	},
-	"Nullable(UInt8)": {
-		fieldType: data.FieldTypeNullableUint8,
-		scanType:  reflect.PtrTo(reflect.PtrTo(reflect.TypeOf(uint8(0)))),
-	},
-	"Nullable(Int64)": {
-		fieldType: data.FieldTypeNullableInt64,
-		scanType:  reflect.PtrTo(reflect.PtrTo(reflect.TypeOf(int64(0)))),
-	},
-	"Nullable(Int32)": {
-		fieldType: data.FieldTypeNullableInt32,
-		scanType:  reflect.PtrTo(reflect.PtrTo(reflect.TypeOf(int32(0)))),
-	},
-	"Nullable(Int16)": {
-		fieldType: data.FieldTypeNullableInt16,
-		scanType:  reflect.PtrTo(reflect.PtrTo(reflect.TypeOf(int16(0)))),
-	},
-	"Nullable(Int8)": {
-		fieldType: data.FieldTypeNullableInt8,
-		scanType:  reflect.PtrTo(reflect.PtrTo(reflect.TypeOf(int8(0)))),
-	},
-	// covers DateTime with tz, DateTime64 - see regexes, Date32
-	"Date": {
-		fieldType:  data.FieldTypeTime,
-		matchRegex: matchRegexes["Date"],
-		scanType:   reflect.PtrTo(reflect.TypeOf(time.Time{})),
-	},
-	"DateTime": {
-		fieldType: data.FieldTypeTime,
-		scanType:  reflect.PtrTo(reflect.TypeOf(time.Time{})),
-	},
-	"DateTime64": {
-		fieldType: data.FieldTypeTime,
-		scanType:  reflect.PtrTo(reflect.TypeOf(time.Time{})),
-	},
-	"Timestamp": {
-		fieldType: data.FieldTypeTime,
-		scanType:  reflect.PtrTo(reflect.TypeOf(time.Time{})),
-	},
-	"Nullable(Date)": {
-		fieldType:  data.FieldTypeNullableTime,
-		matchRegex: matchRegexes["Nullable(Date)"],
-		scanType:   reflect.PtrTo(reflect.PtrTo(reflect.TypeOf(time.Time{}))),
-	},
-	"Nullable(String)": {
-		fieldType:  data.FieldTypeNullableString,
-		matchRegex: matchRegexes["Nullable(String)"],
-		scanType:   reflect.PtrTo(reflect.PtrTo(reflect.TypeOf(""))),
-	},
-	"Decimal": {
-		convert:    decimalConvert,
-		fieldType:  data.FieldTypeFloat64,
-		matchRegex: matchRegexes["Decimal"],
-		scanType:   reflect.PtrTo(reflect.TypeOf(decimal.Decimal{})),
-	},
-	"Nullable(Decimal)": {
-		convert:    decimalNullConvert,
-		fieldType:  data.FieldTypeNullableFloat64,
-		matchRegex: matchRegexes["Nullable(Decimal)"],
-		scanType:   reflect.PtrTo(reflect.PtrTo(reflect.TypeOf(decimal.Decimal{}))),
-	},
+// scalarType describes a scalar Databend type once. Its Nullable(T) converter is generated by nullable.
+type scalarType struct {
+	name string
+	// pattern, if set, matches parameterised variants e.g. Decimal(15,2). It must start with ^
+	pattern   string
+	convert   func(in interface{}) (interface{}, error)
+	fieldType data.FieldType
+	// valueType is the type the driver value is scanned into
+	valueType reflect.Type
+}
+
+var scalarTypes = []scalarType{
+	{name: "Boolean", fieldType: data.FieldTypeBool, valueType: reflect.TypeOf(true)},
+	{name: "Float32", fieldType: data.FieldTypeFloat32, valueType: reflect.TypeOf(float32(0))},
+	{name: "Float64", fieldType: data.FieldTypeFloat64, valueType: reflect.TypeOf(float64(0))},
+	{name: "Int8", fieldType: data.FieldTypeInt8, valueType: reflect.TypeOf(int8(0))},
+	{name: "Int16", fieldType: data.FieldTypeInt16, valueType: reflect.TypeOf(int16(0))},
+	{name: "Int32", fieldType: data.FieldTypeInt32, valueType: reflect.TypeOf(int32(0))},
+	{name: "Int64", fieldType: data.FieldTypeInt64, valueType: reflect.TypeOf(int64(0))},
+	{name: "UInt8", fieldType: data.FieldTypeUint8, valueType: reflect.TypeOf(uint8(0))},
+	{name: "UInt16", fieldType: data.FieldTypeUint16, valueType: reflect.TypeOf(uint16(0))},
+	{name: "UInt32", fieldType: data.FieldTypeUint32, valueType: reflect.TypeOf(uint32(0))},
+	{name: "UInt64", fieldType: data.FieldTypeUint64, valueType: reflect.TypeOf(uint64(0))},
+	{name: "String", fieldType: data.FieldTypeString, valueType: reflect.TypeOf("")},
+	// covers Date32 - see pattern. Dates become midnight UTC of their day
+	{name: "Date", pattern: `^Date\(?`, convert: dateConvert, fieldType: data.FieldTypeTime, valueType: reflect.TypeOf(time.Time{})},
+	// covers DateTime with tz, DateTime64 - see pattern. Timestamps are returned in UTC
+	{name: "DateTime", pattern: `^DateTime`, convert: timestampConvert, fieldType: data.FieldTypeTime, valueType: reflect.TypeOf(time.Time{})},
+	{name: "DateTime64", convert: timestampConvert, fieldType: data.FieldTypeTime, valueType: reflect.TypeOf(time.Time{})},
+	{name: "Timestamp", convert: timestampConvert, fieldType: data.FieldTypeTime, valueType: reflect.TypeOf(time.Time{})},
+	{name: "Decimal", pattern: `^Decimal`, convert: decimalConvert, fieldType: data.FieldTypeFloat64, valueType: reflect.TypeOf(decimal.Decimal{})},
+	// Geometry and Geography values are returned as GeoJSON
+	{name: "Geometry", pattern: `^Geometry`, convert: geometryConvert, fieldType: data.FieldTypeNullableJSON, valueType: reflect.TypeOf("")},
+	{name: "Geography", pattern: `^Geography`, convert: geometryConvert, fieldType: data.FieldTypeNullableJSON, valueType: reflect.TypeOf("")},
+	// Binary values are returned hex encoded
+	{name: "Binary", fieldType: data.FieldTypeString, valueType: reflect.TypeOf("")},
+	// Bitmap values are returned as the comma separated members and become a JSON array
+	{name: "Bitmap", convert: bitmapConvert, fieldType: data.FieldTypeNullableJSON, valueType: reflect.TypeOf("")},
+	// Interval values become seconds
+	{name: "Interval", convert: intervalConvert, fieldType: data.FieldTypeFloat64, valueType: reflect.TypeOf("")},
+}
+
+var complexConverters = map[string]Converter{
 	"Tuple()": {
 		convert:    jsonConverter,
 		fieldType:  data.FieldTypeNullableJSON,
@@ -179,10 +90,6 @@ var Converters = map[string]Converter{
 		matchRegex: matchRegexes["Map()"],
 		scanType:   reflect.TypeOf((*interface{})(nil)).Elem(),
 	},
-	"String": {
-		fieldType: data.FieldTypeString,
-		scanType:  reflect.PtrTo(reflect.TypeOf("")),
-	},
 	"SimpleAggregateFunction()": {
 		convert:    jsonConverter,
 		fieldType:  data.FieldTypeNullableJSON,
@@ -191,31 +98,111 @@ var Converters = map[string]Converter{
 	},
 }
 
-var ComplexTypes = []string{"Map"}
-var DatabendConverters = GetConverters()
+// literalConverters cover the types of bare literals, e.g. SELECT [], SELECT {} or SELECT NULL. Their values
+// are the same in every row, so they are produced without looking at what the driver read.
+var literalConverters = map[string]Converter{
+	"EmptyArray": {
+		convert:   literalJSONConverter("[]"),
+		fieldType: data.FieldTypeNullableJSON,
+		scanType:  reflect.PtrTo(reflect.TypeOf("")),
+	},
+	"EmptyMap": {
+		convert:   literalJSONConverter("{}"),
+		fieldType: data.FieldTypeNullableJSON,
+		scanType:  reflect.PtrTo(reflect.TypeOf("")),
+	},
+	"NULL": {
+		convert:   nullConvert,
+		fieldType: data.FieldTypeNullableString,
+		scanType:  reflect.PtrTo(reflect.TypeOf("")),
+	},
+}
 
-func GetConverters() []sqlutil.Converter {
-	var list []sqlutil.Converter
-	for name, converter := range Converters {
-		list = append(list, createConverter(name, converter))
+// DefaultRegistry holds the converters for every type Databend returns.
+var DefaultRegistry = newDefaultRegistry()
+
+func newDefaultRegistry() *Registry {
+	r := NewRegistry()
+	for _, t := range scalarTypes {
+		converter := Converter{
+			convert:   t.convert,
+			fieldType: t.fieldType,
+			scanType:  reflect.PtrTo(t.valueType),
+		}
+		if t.pattern != "" {
+			converter.matchRegex = regexp.MustCompile(t.pattern)
+		}
+		r.mustRegister(t.name, converter)
+		r.mustRegister(nullableName(t.name), nullable(t, converter))
+	}
+	for name, converter := range complexConverters {
+		r.mustRegister(name, converter)
+	}
+	for name, converter := range literalConverters {
+		r.mustRegister(name, converter)
 	}
-	return list
+	return r
 }
 
-func GetConverter(columnType string) sqlutil.Converter {
-	converter, ok := Converters[columnType]
-	if ok {
-		return createConverter(columnType, converter)
+// ScalarTypeNames returns the names of the scalar types that have a generated Nullable(T) counterpart.
+func ScalarTypeNames() []string {
+	names := make([]string, len(scalarTypes))
+	for i, t := range scalarTypes {
+		names[i] = t.name
 	}
-	for name, converter := range Converters {
-		if name == columnType {
-			return createConverter(name, converter)
+	return names
+}
+
+func nullableName(name string) string {
+	return fmt.Sprintf("Nullable(%s)", name)
+}
+
+// nullable wraps the converter of a scalar type so it scans into **T and produces a nullable field.
+func nullable(t scalarType, base Converter) Converter {
+	converter := Converter{
+		fieldType: base.fieldType.NullableType(),
+		scanType:  reflect.PtrTo(base.scanType),
+	}
+	if t.pattern != "" {
+		converter.matchRegex = regexp.MustCompile(`^Nullable\(` + t.pattern[1:])
+	}
+	if base.convert == nil {
+		// defaultConvert already returns *T for a **T scan type
+		return converter
+	}
+	nullValue := reflect.Zero(reflect.TypeOf(data.NewFieldFromFieldType(converter.fieldType, 1).At(0))).Interface()
+	converter.convert = func(in interface{}) (interface{}, error) {
+		if in == nil {
+			return nullValue, nil
+		}
+		v := reflect.ValueOf(in)
+		if v.Kind() != reflect.Ptr || v.Type().Elem() != base.scanType {
+			return nil, fmt.Errorf("invalid %s - %v", nullableName(t.name), in)
+		}
+		if v.Elem().IsNil() {
+			return nullValue, nil
 		}
-		if converter.matchRegex != nil && converter.matchRegex.MatchString(columnType) {
-			return createConverter(name, converter)
+		out, err := base.convert(v.Elem().Interface())
+		if err != nil || base.fieldType.Nullable() {
+			return out, err
 		}
+		p := reflect.New(reflect.TypeOf(out))
+		p.Elem().Set(reflect.ValueOf(out))
+		return p.Interface(), nil
 	}
-	return sqlutil.Converter{}
+	return converter
+}
+
+var ComplexTypes = []string{"Map"}
+var DatabendConverters = GetConverters()
+
+func GetConverters() []sqlutil.Converter {
+	return DefaultRegistry.Converters()
+}
+
+func GetConverter(columnType string) sqlutil.Converter {
+	converter, _ := DefaultRegistry.Resolve(columnType)
+	return converter
 }
 
 func createConverter(name string, converter Converter) sqlutil.Converter {
@@ -270,17 +257,40 @@ func decimalConvert(in interface{}) (interface{}, error) {
 	return f, nil
 }
 
-func decimalNullConvert(in interface{}) (interface{}, error) {
+func bitmapConvert(in interface{}) (interface{}, error) {
 	if in == nil {
-		return float64(0), nil
+		return (*json.RawMessage)(nil), nil
 	}
-	v, ok := in.(**decimal.Decimal)
+	v, ok := in.(*string)
 	if !ok {
-		return nil, fmt.Errorf("invalid decimal - %v", in)
+		return nil, fmt.Errorf("invalid bitmap - %v", in)
 	}
-	if *v == nil {
-		return (*float64)(nil), nil
+	members := []uint64{}
+	for _, m := range strings.Split(*v, ",") {
+		if m = strings.TrimSpace(m); m == "" {
+			continue
+		}
+		u, err := strconv.ParseUint(m, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bitmap - %s", *v)
+		}
+		members = append(members, u)
 	}
-	f, _ := (*v).Float64()
-	return &f, nil
+	jBytes, err := json.Marshal(members)
+	if err != nil {
+		return nil, err
+	}
+	rawJSON := json.RawMessage(jBytes)
+	return &rawJSON, nil
+}
+
+func literalJSONConverter(value string) func(in interface{}) (interface{}, error) {
+	return func(in interface{}) (interface{}, error) {
+		rawJSON := json.RawMessage(value)
+		return &rawJSON, nil
+	}
+}
+
+func nullConvert(in interface{}) (interface{}, error) {
+	return (*string)(nil), nil
 }
@@ -3,6 +3,8 @@ package converters_test
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"reflect"
 	"testing"
 	"time"
 
@@ -12,9 +14,7 @@ import (
 )
 
 func TestDate(t *testing.T) {
-	layout := "2006-01-02T15:04:05.000Z"
-	str := "2014-11-12T11:45:26.371Z"
-	d, _ := time.Parse(layout, str)
+	d := time.Date(2014, 11, 12, 0, 0, 0, 0, time.UTC)
 	sut := converters.GetConverter("Date")
 	v, err := sut.FrameConverter.ConverterFunc(&d)
 	assert.Nil(t, err)
@@ -34,9 +34,7 @@ func TestDateTime(t *testing.T) {
 }
 
 func TestNullableDate(t *testing.T) {
-	layout := "2006-01-02T15:04:05.000Z"
-	str := "2014-11-12T11:45:26.371Z"
-	d, _ := time.Parse(layout, str)
+	d := time.Date(2014, 11, 12, 0, 0, 0, 0, time.UTC)
 	val := &d
 	sut := converters.GetConverter("Nullable(Date)")
 	v, err := sut.FrameConverter.ConverterFunc(&val)
@@ -54,6 +52,45 @@ func TestNullableDateShouldBeNil(t *testing.T) {
 	assert.Equal(t, (*time.Time)(nil), actual)
 }
 
+func TestDateInLocation(t *testing.T) {
+	for _, offset := range []int{8, -5} {
+		loc := time.FixedZone(fmt.Sprintf("UTC%+d", offset), offset*60*60)
+		t.Run(loc.String(), func(t *testing.T) {
+			// the driver parses 2014-11-12 as midnight in the location of the connection
+			d := time.Date(2014, 11, 12, 0, 0, 0, 0, loc)
+			for _, name := range []string{"Date", "Date32"} {
+				v, err := converters.GetConverter(name).FrameConverter.ConverterFunc(&d)
+				assert.Nil(t, err)
+				assert.Equal(t, time.Date(2014, 11, 12, 0, 0, 0, 0, time.UTC), v.(time.Time))
+			}
+			val := &d
+			v, err := converters.GetConverter("Nullable(Date)").FrameConverter.ConverterFunc(&val)
+			assert.Nil(t, err)
+			assert.Equal(t, time.Date(2014, 11, 12, 0, 0, 0, 0, time.UTC), *v.(*time.Time))
+		})
+	}
+}
+
+func TestTimestampInLocation(t *testing.T) {
+	for _, offset := range []int{8, -5} {
+		loc := time.FixedZone(fmt.Sprintf("UTC%+d", offset), offset*60*60)
+		t.Run(loc.String(), func(t *testing.T) {
+			d := time.Date(2014, 11, 12, 1, 45, 26, 0, loc)
+			for _, name := range []string{"Timestamp", "DateTime", "DateTime64(3)"} {
+				v, err := converters.GetConverter(name).FrameConverter.ConverterFunc(&d)
+				assert.Nil(t, err)
+				actual := v.(time.Time)
+				assert.Equal(t, time.UTC, actual.Location())
+				assert.True(t, d.Equal(actual))
+			}
+			val := &d
+			v, err := converters.GetConverter("Nullable(Timestamp)").FrameConverter.ConverterFunc(&val)
+			assert.Nil(t, err)
+			assert.Equal(t, d.UTC(), *v.(*time.Time))
+		})
+	}
+}
+
 func TestNullableDecimal(t *testing.T) {
 	val := decimal.New(25, 4)
 	value := &val
@@ -342,6 +379,33 @@ func toJson(obj interface{}) (json.RawMessage, error) {
 	return rawJSON, nil
 }
 
+func TestNullableTimestamp(t *testing.T) {
+	d := time.Date(2014, 11, 12, 11, 45, 26, 0, time.UTC)
+	val := &d
+	sut := converters.GetConverter("Nullable(Timestamp)")
+	v, err := sut.FrameConverter.ConverterFunc(&val)
+	assert.Nil(t, err)
+	assert.Equal(t, val, v.(*time.Time))
+}
+
+func TestEveryScalarTypeHasNullableCounterpart(t *testing.T) {
+	for _, name := range converters.ScalarTypeNames() {
+		t.Run(name, func(t *testing.T) {
+			base := converters.GetConverter(name)
+			sut := converters.GetConverter(fmt.Sprintf("Nullable(%s)", name))
+			assert.Equal(t, fmt.Sprintf("Nullable(%s)", name), sut.Name)
+			assert.Equal(t, reflect.PtrTo(base.InputScanType), sut.InputScanType)
+			assert.Equal(t, base.FrameConverter.FieldType.NullableType(), sut.FrameConverter.FieldType)
+
+			// a NULL value must convert into a nil pointer of the field type
+			null := reflect.New(sut.InputScanType.Elem())
+			v, err := sut.FrameConverter.ConverterFunc(null.Interface())
+			assert.Nil(t, err)
+			assert.True(t, reflect.ValueOf(v).IsNil())
+		})
+	}
+}
+
 func TestTuple(t *testing.T) {
 	value := map[string]interface{}{
 		"1": uint16(1),
@@ -391,3 +455,86 @@ func TestSimpleAggregateFunction(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, msg, *v.(*json.RawMessage))
 }
+
+func TestBinary(t *testing.T) {
+	value := "48656C6C6F"
+	sut := converters.GetConverter("Binary")
+	v, err := sut.FrameConverter.ConverterFunc(&value)
+	assert.Nil(t, err)
+	assert.Equal(t, value, v.(string))
+}
+
+func TestBitmap(t *testing.T) {
+	value := "1,3,5"
+	sut := converters.GetConverter("Bitmap")
+	v, err := sut.FrameConverter.ConverterFunc(&value)
+	assert.Nil(t, err)
+	assert.JSONEq(t, "[1,3,5]", string(*v.(*json.RawMessage)))
+
+	empty := ""
+	v, err = sut.FrameConverter.ConverterFunc(&empty)
+	assert.Nil(t, err)
+	assert.JSONEq(t, "[]", string(*v.(*json.RawMessage)))
+}
+
+func TestNullableBitmapShouldBeNil(t *testing.T) {
+	var value *string
+	sut := converters.GetConverter("Nullable(Bitmap)")
+	v, err := sut.FrameConverter.ConverterFunc(&value)
+	assert.Nil(t, err)
+	assert.Equal(t, (*json.RawMessage)(nil), v.(*json.RawMessage))
+}
+
+func TestInterval(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+	}{
+		{in: "01:02:03", want: 3723},
+		{in: "1 day 00:00:01.5", want: 86401.5},
+		{in: "2 days", want: 172800},
+		{in: "1 month", want: 30 * 86400},
+		{in: "-01:00:00", want: -3600},
+		{in: "1 day ago", want: -86400},
+		{in: "3 hours 30 mins", want: 12600},
+	}
+	sut := converters.GetConverter("Interval")
+	for _, tt := range tests {
+		value := tt.in
+		v, err := sut.FrameConverter.ConverterFunc(&value)
+		assert.Nil(t, err, tt.in)
+		assert.InDelta(t, tt.want, v.(float64), 1e-9, tt.in)
+	}
+	invalid := "1 fortnight"
+	_, err := sut.FrameConverter.ConverterFunc(&invalid)
+	assert.NotNil(t, err)
+}
+
+func TestNullableInterval(t *testing.T) {
+	value := "00:01:00"
+	val := &value
+	sut := converters.GetConverter("Nullable(Interval)")
+	v, err := sut.FrameConverter.ConverterFunc(&val)
+	assert.Nil(t, err)
+	assert.Equal(t, 60.0, *v.(*float64))
+}
+
+func TestEmptyLiterals(t *testing.T) {
+	for columnType, want := range map[string]string{"EmptyArray": "[]", "EmptyMap": "{}"} {
+		t.Run(columnType, func(t *testing.T) {
+			text := want
+			sut := converters.GetConverter(columnType)
+			v, err := sut.FrameConverter.ConverterFunc(&text)
+			assert.Nil(t, err)
+			assert.Equal(t, want, string(*v.(*json.RawMessage)))
+		})
+	}
+}
+
+func TestNullLiteral(t *testing.T) {
+	text := "NULL"
+	sut := converters.GetConverter("NULL")
+	v, err := sut.FrameConverter.ConverterFunc(&text)
+	assert.Nil(t, err)
+	assert.Equal(t, (*string)(nil), v)
+}
@@ -0,0 +1,131 @@
+package converters
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/ewkb"
+	"github.com/paulmach/orb/encoding/wkt"
+)
+
+// geoJSONGeometry is the GeoJSON representation of an orb geometry.
+// orb's own geojson package is avoided so the plugin does not pull in its bson dependency.
+type geoJSONGeometry struct {
+	Type        string             `json:"type"`
+	Coordinates orb.Geometry       `json:"coordinates,omitempty"`
+	Geometries  []*geoJSONGeometry `json:"geometries,omitempty"`
+}
+
+func newGeoJSONGeometry(g orb.Geometry) *geoJSONGeometry {
+	switch g := g.(type) {
+	case orb.Collection:
+		jg := &geoJSONGeometry{Type: g.GeoJSONType()}
+		for _, c := range g {
+			jg.Geometries = append(jg.Geometries, newGeoJSONGeometry(c))
+		}
+		return jg
+	case orb.Ring:
+		return &geoJSONGeometry{Type: orb.Polygon{g}.GeoJSONType(), Coordinates: orb.Polygon{g}}
+	case orb.Bound:
+		return &geoJSONGeometry{Type: g.ToPolygon().GeoJSONType(), Coordinates: g.ToPolygon()}
+	default:
+		return &geoJSONGeometry{Type: g.GeoJSONType(), Coordinates: g}
+	}
+}
+
+// ParseGeometry decodes the hex encoded WKB/EWKB, WKT or EWKT output of a Databend Geometry or Geography column.
+// GeoJSON output (the geometry_output_format default) is passed through by the converters untouched.
+func ParseGeometry(s string) (orb.Geometry, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "":
+		return nil, fmt.Errorf("empty geometry")
+	case strings.HasPrefix(strings.ToUpper(s), "SRID="):
+		// EWKT - SRID=4326;POINT(1 2)
+		i := strings.Index(s, ";")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid EWKT geometry - %s", s)
+		}
+		return wkt.Unmarshal(s[i+1:])
+	}
+	if b, err := hex.DecodeString(s); err == nil {
+		// ewkb handles plain WKB as well, the SRID is simply absent
+		g, _, err := ewkb.Unmarshal(b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WKB geometry - %w", err)
+		}
+		return g, nil
+	}
+	return wkt.Unmarshal(s)
+}
+
+func geometryToJSON(s string) (*json.RawMessage, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	var rawJSON json.RawMessage
+	if strings.HasPrefix(s, "{") {
+		rawJSON = json.RawMessage(s)
+		return &rawJSON, nil
+	}
+	g, err := ParseGeometry(s)
+	if err != nil {
+		return nil, err
+	}
+	jBytes, err := json.Marshal(newGeoJSONGeometry(g))
+	if err != nil {
+		return nil, err
+	}
+	rawJSON = jBytes
+	return &rawJSON, nil
+}
+
+func geometryConvert(in interface{}) (interface{}, error) {
+	if in == nil {
+		return (*json.RawMessage)(nil), nil
+	}
+	v, ok := in.(*string)
+	if !ok {
+		return nil, fmt.Errorf("invalid geometry - %v", in)
+	}
+	return geometryToJSON(*v)
+}
+
+// PointCoordinateFields returns latitude and longitude fields for a JSON field holding GeoJSON points,
+// so Geomap panels can plot it in coords mode. Nothing is returned if any value is not a point.
+func PointCoordinateFields(field *data.Field) []*data.Field {
+	if field.Type() != data.FieldTypeNullableJSON || field.Len() == 0 {
+		return nil
+	}
+	lat := make([]*float64, field.Len())
+	lon := make([]*float64, field.Len())
+	points := 0
+	for i := 0; i < field.Len(); i++ {
+		v := field.At(i).(*json.RawMessage)
+		if v == nil {
+			continue
+		}
+		var point struct {
+			Type        string    `json:"type"`
+			Coordinates []float64 `json:"coordinates"`
+		}
+		if err := json.Unmarshal(*v, &point); err != nil || point.Type != "Point" || len(point.Coordinates) < 2 {
+			return nil
+		}
+		// GeoJSON positions are ordered longitude, latitude
+		lon[i], lat[i] = &point.Coordinates[0], &point.Coordinates[1]
+		points++
+	}
+	if points == 0 {
+		return nil
+	}
+	return []*data.Field{
+		data.NewField(field.Name+".lat", field.Labels, lat),
+		data.NewField(field.Name+".lon", field.Labels, lon),
+	}
+}
@@ -0,0 +1,104 @@
+package converters_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/cadl/grafana-databend-datasource/pkg/converters"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/paulmach/orb"
+	"github.com/stretchr/testify/assert"
+)
+
+// WKB fixtures, hex encoded the way Databend returns them with geometry_output_format = 'WKB'
+const (
+	wkbPointLE       = "0101000000000000000000F03F0000000000000040"
+	wkbPointBE       = "00000000013FF00000000000004000000000000000"
+	wkbLineString    = "0102000000030000000000000000003E40000000000000244000000000000024400000000000003E4000000000000044400000000000004440"
+	ewkbPointSRID    = "0101000020E6100000CB49287D21C451C0F0BF95ECD8244540"
+	geoJSONPoint     = `{"type":"Point","coordinates":[1,2]}`
+	geoJSONLine      = `{"type":"LineString","coordinates":[[30,10],[10,30],[40,40]]}`
+	geoJSONPointSRID = `{"type":"Point","coordinates":[-71.064544,42.28787]}`
+)
+
+func TestParseGeometry(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want orb.Geometry
+	}{
+		{name: "WKB little endian point", in: wkbPointLE, want: orb.Point{1, 2}},
+		{name: "WKB big endian point", in: wkbPointBE, want: orb.Point{1, 2}},
+		{name: "WKB line string", in: wkbLineString, want: orb.LineString{{30, 10}, {10, 30}, {40, 40}}},
+		{name: "EWKB point with SRID", in: ewkbPointSRID, want: orb.Point{-71.064544, 42.28787}},
+		{name: "WKT point", in: "POINT(1 2)", want: orb.Point{1, 2}},
+		{name: "EWKT point", in: "SRID=4326;POINT(1 2)", want: orb.Point{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := converters.ParseGeometry(tt.in)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, g)
+		})
+	}
+}
+
+func TestParseGeometryInvalid(t *testing.T) {
+	_, err := converters.ParseGeometry("0101")
+	assert.NotNil(t, err)
+	_, err = converters.ParseGeometry("")
+	assert.NotNil(t, err)
+}
+
+func TestGeometry(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: wkbPointLE, want: geoJSONPoint},
+		{in: wkbLineString, want: geoJSONLine},
+		{in: ewkbPointSRID, want: geoJSONPointSRID},
+		{in: "POINT(1 2)", want: geoJSONPoint},
+		{in: geoJSONPoint, want: geoJSONPoint},
+	}
+	sut := converters.GetConverter("Geometry")
+	for _, tt := range tests {
+		value := tt.in
+		v, err := sut.FrameConverter.ConverterFunc(&value)
+		assert.Nil(t, err)
+		assert.JSONEq(t, tt.want, string(*v.(*json.RawMessage)))
+	}
+}
+
+func TestNullableGeography(t *testing.T) {
+	value := wkbPointLE
+	val := &value
+	sut := converters.GetConverter("Nullable(Geography)")
+	v, err := sut.FrameConverter.ConverterFunc(&val)
+	assert.Nil(t, err)
+	assert.JSONEq(t, geoJSONPoint, string(*v.(*json.RawMessage)))
+}
+
+func TestNullableGeometryShouldBeNil(t *testing.T) {
+	var value *string
+	sut := converters.GetConverter("Nullable(Geometry)")
+	v, err := sut.FrameConverter.ConverterFunc(&value)
+	assert.Nil(t, err)
+	assert.Equal(t, (*json.RawMessage)(nil), v.(*json.RawMessage))
+}
+
+func TestPointCoordinateFields(t *testing.T) {
+	point := json.RawMessage(geoJSONPoint)
+	field := data.NewField("location", nil, []*json.RawMessage{&point, nil})
+	fields := converters.PointCoordinateFields(field)
+	assert.Len(t, fields, 2)
+	assert.Equal(t, "location.lat", fields[0].Name)
+	assert.Equal(t, 2.0, *fields[0].At(0).(*float64))
+	assert.Nil(t, fields[0].At(1))
+	assert.Equal(t, "location.lon", fields[1].Name)
+	assert.Equal(t, 1.0, *fields[1].At(0).(*float64))
+
+	line := json.RawMessage(geoJSONLine)
+	field = data.NewField("route", nil, []*json.RawMessage{&point, &line})
+	assert.Nil(t, converters.PointCoordinateFields(field))
+}
@@ -0,0 +1,86 @@
+package converters
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// seconds per unit of an Interval, months and years count as 30 and 365 days
+var intervalUnits = map[string]float64{
+	"microsecond": 1e-6,
+	"millisecond": 1e-3,
+	"second":      1,
+	"sec":         1,
+	"minute":      60,
+	"min":         60,
+	"hour":        3600,
+	"day":         86400,
+	"week":        7 * 86400,
+	"month":       30 * 86400,
+	"mon":         30 * 86400,
+	"year":        365 * 86400,
+}
+
+// ParseInterval returns the seconds of a Databend Interval, e.g. 1 day 02:03:04.5 or 1 month 2 days.
+func ParseInterval(s string) (float64, error) {
+	tokens := strings.Fields(strings.TrimSpace(s))
+	if len(tokens) == 0 {
+		return 0, fmt.Errorf("empty interval")
+	}
+	sign := 1.0
+	if tokens[len(tokens)-1] == "ago" {
+		sign, tokens = -1, tokens[:len(tokens)-1]
+	}
+	seconds := 0.0
+	for i := 0; i < len(tokens); i++ {
+		if strings.Contains(tokens[i], ":") {
+			v, err := parseIntervalTime(tokens[i])
+			if err != nil {
+				return 0, fmt.Errorf("invalid interval %q: %w", s, err)
+			}
+			seconds += v
+			continue
+		}
+		n, err := strconv.ParseFloat(tokens[i], 64)
+		if err != nil || i+1 >= len(tokens) {
+			return 0, fmt.Errorf("invalid interval %q", s)
+		}
+		i++
+		unit, ok := intervalUnits[strings.TrimSuffix(strings.ToLower(tokens[i]), "s")]
+		if !ok {
+			return 0, fmt.Errorf("invalid interval %q: unknown unit %s", s, tokens[i])
+		}
+		seconds += n * unit
+	}
+	return sign * seconds, nil
+}
+
+// parseIntervalTime reads the [-]HH:MM:SS[.ffffff] part of an interval.
+func parseIntervalTime(s string) (float64, error) {
+	sign := 1.0
+	if strings.HasPrefix(s, "-") {
+		sign, s = -1, s[1:]
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid time %s", s)
+	}
+	d, err := time.ParseDuration(fmt.Sprintf("%sh%sm%ss", parts[0], parts[1], parts[2]))
+	if err != nil {
+		return 0, err
+	}
+	return sign * d.Seconds(), nil
+}
+
+func intervalConvert(in interface{}) (interface{}, error) {
+	if in == nil {
+		return float64(0), nil
+	}
+	v, ok := in.(*string)
+	if !ok {
+		return nil, fmt.Errorf("invalid interval - %v", in)
+	}
+	return ParseInterval(*v)
+}
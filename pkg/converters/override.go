@@ -0,0 +1,204 @@
+package converters
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// overrideFieldTypes are the field types a column type can be overridden to, by the name used in the settings.
+var overrideFieldTypes = map[string]data.FieldType{
+	"string":  data.FieldTypeNullableString,
+	"bool":    data.FieldTypeNullableBool,
+	"int64":   data.FieldTypeNullableInt64,
+	"uint64":  data.FieldTypeNullableUint64,
+	"float64": data.FieldTypeNullableFloat64,
+	"time":    data.FieldTypeNullableTime,
+	"json":    data.FieldTypeNullableJSON,
+}
+
+// OverrideFieldType returns the field type for its name in the type override settings, e.g. string or bool.
+func OverrideFieldType(name string) (data.FieldType, error) {
+	fieldType, ok := overrideFieldTypes[name]
+	if !ok {
+		return data.FieldTypeUnknown, fmt.Errorf("unknown field type %q", name)
+	}
+	return fieldType, nil
+}
+
+// Override makes the column types matching the pattern, and their Nullable(T) variants, fields of the given type.
+// The pattern is a regular expression for the whole type name, e.g. UInt8 or Decimal\(.*\). Overrides win over
+// every built-in converter and are tried in the order they were added.
+func (r *Registry) Override(pattern string, fieldType data.FieldType) error {
+	if _, err := regexp.Compile(pattern); err != nil {
+		return fmt.Errorf("invalid type pattern %q: %w", pattern, err)
+	}
+	fieldType = fieldType.NullableType()
+	if !isOverrideFieldType(fieldType) {
+		return fmt.Errorf("type %s cannot be overridden to %s", pattern, fieldType)
+	}
+	converter := Converter{
+		convert: func(in interface{}) (interface{}, error) {
+			return convertOverride(in, fieldType)
+		},
+		fieldType:  fieldType,
+		matchRegex: regexp.MustCompile(fmt.Sprintf(`^(?:Nullable\((?:%s)\)|(?:%s))$`, pattern, pattern)),
+		scanType:   reflect.TypeOf((*interface{})(nil)).Elem(),
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.overrides = append(r.overrides, namedConverter{name: pattern, converter: converter})
+	return nil
+}
+
+func isOverrideFieldType(fieldType data.FieldType) bool {
+	for _, t := range overrideFieldTypes {
+		if t == fieldType {
+			return true
+		}
+	}
+	return false
+}
+
+// convertOverride converts a scanned driver value to a pointer of the nullable field type.
+func convertOverride(in interface{}, fieldType data.FieldType) (interface{}, error) {
+	nullValue := reflect.Zero(reflect.TypeOf(data.NewFieldFromFieldType(fieldType, 1).At(0))).Interface()
+	v := reflect.ValueOf(in)
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return nullValue, nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nullValue, nil
+	}
+	value := v.Interface()
+
+	switch fieldType {
+	case data.FieldTypeNullableString:
+		s := overrideString(value)
+		return &s, nil
+	case data.FieldTypeNullableBool:
+		var b bool
+		switch v.Kind() {
+		case reflect.Bool:
+			b = v.Bool()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			b = v.Int() != 0
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			b = v.Uint() != 0
+		case reflect.Float32, reflect.Float64:
+			b = v.Float() != 0
+		default:
+			parsed, err := strconv.ParseBool(overrideString(value))
+			if err != nil {
+				return nil, fmt.Errorf("invalid bool - %v", value)
+			}
+			b = parsed
+		}
+		return &b, nil
+	case data.FieldTypeNullableInt64:
+		var i int64
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			i = v.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			i = int64(v.Uint())
+		case reflect.Float32, reflect.Float64:
+			i = int64(v.Float())
+		case reflect.Bool:
+			if v.Bool() {
+				i = 1
+			}
+		default:
+			if t, ok := value.(time.Time); ok {
+				i = t.UnixMilli()
+				break
+			}
+			parsed, err := strconv.ParseInt(overrideString(value), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid int64 - %v", value)
+			}
+			i = parsed
+		}
+		return &i, nil
+	case data.FieldTypeNullableUint64:
+		var u uint64
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			u = uint64(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			u = v.Uint()
+		case reflect.Float32, reflect.Float64:
+			u = uint64(v.Float())
+		default:
+			parsed, err := strconv.ParseUint(overrideString(value), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid uint64 - %v", value)
+			}
+			u = parsed
+		}
+		return &u, nil
+	case data.FieldTypeNullableFloat64:
+		var f float64
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			f = float64(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			f = float64(v.Uint())
+		case reflect.Float32, reflect.Float64:
+			f = v.Float()
+		default:
+			parsed, err := strconv.ParseFloat(overrideString(value), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid float64 - %v", value)
+			}
+			f = parsed
+		}
+		return &f, nil
+	case data.FieldTypeNullableTime:
+		if t, ok := value.(time.Time); ok {
+			return &t, nil
+		}
+		s := overrideString(value)
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02"} {
+			if t, err := time.Parse(layout, s); err == nil {
+				return &t, nil
+			}
+		}
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			// epoch seconds
+			t := time.Unix(i, 0).UTC()
+			return &t, nil
+		}
+		return nil, fmt.Errorf("invalid time - %v", value)
+	default:
+		return jsonConverter(value)
+	}
+}
+
+// overrideString formats a driver value as text. Times at midnight are taken to be dates.
+func overrideString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case time.Time:
+		if h, m, sec := v.Clock(); h == 0 && m == 0 && sec == 0 && v.Nanosecond() == 0 {
+			return v.Format("2006-01-02")
+		}
+		return v.Format(time.RFC3339Nano)
+	}
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Struct:
+		if b, err := json.Marshal(value); err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprint(value)
+}
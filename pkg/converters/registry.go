@@ -0,0 +1,159 @@
+package converters
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+)
+
+// Registry resolves Databend column types to converters. Resolution never depends on registration
+// order or map iteration: overrides are tried first, then an exact type name wins, then the anchored
+// patterns are tried from the most to the least specific one, i.e. by the length of their literal prefix.
+type Registry struct {
+	mu        sync.RWMutex
+	overrides []namedConverter
+	exact     map[string]Converter
+	patterns  []namedConverter
+}
+
+type namedConverter struct {
+	name      string
+	converter Converter
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		exact: map[string]Converter{},
+	}
+}
+
+// Clone returns a copy of the registry, to be extended without changing the original.
+func (r *Registry) Clone() *Registry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	clone := &Registry{
+		overrides: append([]namedConverter(nil), r.overrides...),
+		exact:     make(map[string]Converter, len(r.exact)),
+		patterns:  append([]namedConverter(nil), r.patterns...),
+	}
+	for name, converter := range r.exact {
+		clone.exact[name] = converter
+	}
+	return clone
+}
+
+// Register adds or replaces the converter for the type name. A converter with a match regex also resolves
+// every type the regex matches. The regex has to be anchored with ^ to keep resolution unambiguous.
+func (r *Registry) Register(name string, converter Converter) error {
+	if converter.matchRegex != nil && converter.matchRegex.String()[0] != '^' {
+		return fmt.Errorf("converter %s: match regex %s must be anchored with ^", name, converter.matchRegex)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.exact[name] = converter
+
+	patterns := make([]namedConverter, 0, len(r.patterns)+1)
+	for _, p := range r.patterns {
+		if p.name != name {
+			patterns = append(patterns, p)
+		}
+	}
+	if converter.matchRegex != nil {
+		patterns = append(patterns, namedConverter{name: name, converter: converter})
+	}
+	sort.SliceStable(patterns, func(i, j int) bool {
+		pi, pj := specificity(patterns[i]), specificity(patterns[j])
+		if pi != pj {
+			return pi > pj
+		}
+		return patterns[i].name < patterns[j].name
+	})
+	r.patterns = patterns
+	return nil
+}
+
+func (r *Registry) mustRegister(name string, converter Converter) {
+	if err := r.Register(name, converter); err != nil {
+		panic(err)
+	}
+}
+
+func specificity(c namedConverter) int {
+	return len(literalPrefix(c.converter.matchRegex.String()))
+}
+
+// literalPrefix returns the literal text an anchored pattern starts with, e.g. Nullable(Date for ^Nullable\(Date\(?
+// regexp's own LiteralPrefix gives up on the leading ^ for most of our patterns.
+func literalPrefix(pattern string) string {
+	var prefix []rune
+	runes := []rune(strings.TrimPrefix(pattern, "^"))
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; {
+		case r == '\\' && i+1 < len(runes):
+			i++
+			prefix = append(prefix, runes[i])
+		case r == '?' || r == '*' || r == '{':
+			// the previous literal is optional
+			if len(prefix) > 0 {
+				prefix = prefix[:len(prefix)-1]
+			}
+			return string(prefix)
+		case strings.ContainsRune(".[]()+|$", r):
+			return string(prefix)
+		default:
+			prefix = append(prefix, r)
+		}
+	}
+	return string(prefix)
+}
+
+// Resolve returns the converter for a Databend column type.
+func (r *Registry) Resolve(columnType string) (sqlutil.Converter, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, o := range r.overrides {
+		if o.converter.matchRegex.MatchString(columnType) {
+			return createConverter(o.name, o.converter), true
+		}
+	}
+	if converter, ok := r.exact[columnType]; ok {
+		return createConverter(columnType, converter), true
+	}
+	for _, p := range r.patterns {
+		if p.converter.matchRegex.MatchString(columnType) {
+			return createConverter(p.name, p.converter), true
+		}
+	}
+	return sqlutil.Converter{}, false
+}
+
+// Converters lists the converters in resolution order. sqlutil picks the first converter that matches a column,
+// so the overrides come first, then the exact names without their regex, followed by the patterns from the most
+// specific one.
+func (r *Registry) Converters() []sqlutil.Converter {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.exact))
+	for name := range r.exact {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]sqlutil.Converter, 0, len(r.overrides)+len(names)+len(r.patterns))
+	for _, o := range r.overrides {
+		list = append(list, createConverter(o.name, o.converter))
+	}
+	for _, name := range names {
+		converter := createConverter(name, r.exact[name])
+		converter.InputTypeRegex = nil
+		list = append(list, converter)
+	}
+	for _, p := range r.patterns {
+		list = append(list, createConverter(p.name, p.converter))
+	}
+	return list
+}
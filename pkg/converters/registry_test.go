@@ -0,0 +1,126 @@
+package converters_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cadl/grafana-databend-datasource/pkg/converters"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"github.com/stretchr/testify/assert"
+)
+
+var supportedTypes = map[string]string{
+	"Boolean":                    "Boolean",
+	"Nullable(Boolean)":          "Nullable(Boolean)",
+	"Int8":                       "Int8",
+	"Nullable(UInt64)":           "Nullable(UInt64)",
+	"Float64":                    "Float64",
+	"String":                     "String",
+	"Nullable(String)":           "Nullable(String)",
+	"Date":                       "Date",
+	"Date32":                     "Date",
+	"DateTime":                   "DateTime",
+	"DateTime64(3)":              "DateTime",
+	"Timestamp":                  "Timestamp",
+	"Nullable(Date)":             "Nullable(Date)",
+	"Nullable(DateTime64(3))":    "Nullable(DateTime)",
+	"Nullable(Timestamp)":        "Nullable(Timestamp)",
+	"Decimal(15,2)":              "Decimal",
+	"Nullable(Decimal(15,2))":    "Nullable(Decimal)",
+	"Geometry":                   "Geometry",
+	"Nullable(Geography)":        "Nullable(Geography)",
+	"Binary":                     "Binary",
+	"Nullable(Bitmap)":           "Nullable(Bitmap)",
+	"Interval":                   "Interval",
+	"Array(String)":              "Array()",
+	"Array(Nullable(String))":    "Array()",
+	"Array(Tuple(String, Int8))": "Array()",
+	"Tuple(String, Int8)":        "Tuple()",
+	"Map(String, String)":        "Map()",
+	"SimpleAggregateFunction()":  "SimpleAggregateFunction()",
+	"EmptyArray":                 "EmptyArray",
+	"EmptyMap":                   "EmptyMap",
+	"NULL":                       "NULL",
+}
+
+// firstMatch resolves a column type the way sqlutil.MakeScanRow does - the first matching converter wins.
+func firstMatch(list []sqlutil.Converter, columnType string) string {
+	for _, c := range list {
+		if c.InputTypeName == columnType || (c.InputTypeRegex != nil && c.InputTypeRegex.MatchString(columnType)) {
+			return c.Name
+		}
+	}
+	return ""
+}
+
+func TestResolveIsDeterministic(t *testing.T) {
+	for columnType, want := range supportedTypes {
+		t.Run(columnType, func(t *testing.T) {
+			for i := 0; i < 1000; i++ {
+				assert.Equal(t, want, converters.GetConverter(columnType).Name)
+				assert.Equal(t, want, firstMatch(converters.GetConverters(), columnType))
+			}
+		})
+	}
+}
+
+func TestResolveUnknownType(t *testing.T) {
+	_, ok := converters.DefaultRegistry.Resolve("NoSuchType")
+	assert.False(t, ok)
+}
+
+func TestOverride(t *testing.T) {
+	r := converters.DefaultRegistry.Clone()
+	assert.Nil(t, r.Override("UInt8", data.FieldTypeBool))
+	assert.Nil(t, r.Override(`Date|DateTime64\(.*\)`, data.FieldTypeString))
+
+	for _, columnType := range []string{"UInt8", "Nullable(UInt8)", "Date", "DateTime64(3)"} {
+		c, ok := r.Resolve(columnType)
+		assert.True(t, ok)
+		assert.Equal(t, c.Name, firstMatch(r.Converters(), columnType), columnType)
+		assert.True(t, c.FrameConverter.FieldType == data.FieldTypeNullableBool || c.FrameConverter.FieldType == data.FieldTypeNullableString)
+	}
+	// the default registry is left alone
+	assert.Equal(t, "UInt8", converters.GetConverter("UInt8").Name)
+	assert.Equal(t, data.FieldTypeUint8, converters.GetConverter("UInt8").FrameConverter.FieldType)
+	// overrides match the whole type name
+	c, _ := r.Resolve("UInt16")
+	assert.Equal(t, data.FieldTypeUint16, c.FrameConverter.FieldType)
+
+	assert.NotNil(t, r.Override("UInt8(", data.FieldTypeBool))
+	assert.NotNil(t, r.Override("UInt8", data.FieldTypeInt8))
+}
+
+func TestOverrideConvert(t *testing.T) {
+	r := converters.NewRegistry()
+	assert.Nil(t, r.Override("UInt8", data.FieldTypeBool))
+	assert.Nil(t, r.Override("Date", data.FieldTypeString))
+	assert.Nil(t, r.Override("String", data.FieldTypeFloat64))
+
+	tests := []struct {
+		columnType string
+		in         interface{}
+		want       interface{}
+	}{
+		{columnType: "UInt8", in: uint8(1), want: true},
+		{columnType: "UInt8", in: uint8(0), want: false},
+		{columnType: "Nullable(UInt8)", in: "1", want: true},
+		{columnType: "Date", in: time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC), want: "2023-09-01"},
+		{columnType: "String", in: "1.5", want: 1.5},
+	}
+	for _, tt := range tests {
+		c, _ := r.Resolve(tt.columnType)
+		in := tt.in
+		v, err := c.FrameConverter.ConverterFunc(&in)
+		assert.Nil(t, err)
+		assert.Equal(t, tt.want, reflect.ValueOf(v).Elem().Interface(), tt.columnType)
+	}
+
+	c, _ := r.Resolve("Nullable(UInt8)")
+	var null interface{}
+	v, err := c.FrameConverter.ConverterFunc(&null)
+	assert.Nil(t, err)
+	assert.Equal(t, (*bool)(nil), v)
+}
@@ -0,0 +1,37 @@
+package converters
+
+import (
+	"fmt"
+	"time"
+)
+
+// dateConvert returns a Date as midnight UTC of its calendar day. The driver parses dates at midnight in
+// the location of the connection, which Grafana would otherwise show on the previous or the next day.
+func dateConvert(in interface{}) (interface{}, error) {
+	if in == nil {
+		return time.Time{}, nil
+	}
+	v, ok := in.(*time.Time)
+	if !ok {
+		return nil, fmt.Errorf("invalid date - %v", in)
+	}
+	return DateInUTC(*v), nil
+}
+
+// timestampConvert returns a timestamp in UTC. The instant is kept, only the location changes.
+func timestampConvert(in interface{}) (interface{}, error) {
+	if in == nil {
+		return time.Time{}, nil
+	}
+	v, ok := in.(*time.Time)
+	if !ok {
+		return nil, fmt.Errorf("invalid timestamp - %v", in)
+	}
+	return v.UTC(), nil
+}
+
+// DateInUTC returns midnight UTC of the calendar day of t in its own location.
+func DateInUTC(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,118 @@
+package converters
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ColumnType is a parsed Databend column type, e.g. Array(Nullable(Float64)) or Tuple(a String, b Int32).
+type ColumnType struct {
+	Name string
+	Args []ColumnType
+	// Field is the element name when the type is an element of a named tuple
+	Field string
+}
+
+// ParseColumnType parses the type name Databend reports for a column.
+func ParseColumnType(s string) (ColumnType, error) {
+	s = strings.TrimSpace(s)
+	open := strings.IndexByte(s, '(')
+	if open < 0 {
+		// leaf arguments may be anything, e.g. the 'a' = 1 of an Enum8
+		if s == "" || strings.ContainsAny(s, ")") {
+			return ColumnType{}, fmt.Errorf("invalid column type - %q", s)
+		}
+		return ColumnType{Name: s}, nil
+	}
+	if !strings.HasSuffix(s, ")") {
+		return ColumnType{}, fmt.Errorf("invalid column type - %q", s)
+	}
+	t := ColumnType{Name: strings.TrimSpace(s[:open])}
+	args, err := splitTypeArgs(s[open+1 : len(s)-1])
+	if err != nil {
+		return ColumnType{}, fmt.Errorf("invalid column type - %q: %w", s, err)
+	}
+	for _, arg := range args {
+		field := ""
+		if t.Name == "Tuple" {
+			field, arg = splitFieldName(arg)
+		}
+		argType, err := ParseColumnType(arg)
+		if err != nil {
+			return ColumnType{}, err
+		}
+		argType.Field = field
+		t.Args = append(t.Args, argType)
+	}
+	return t, nil
+}
+
+// splitTypeArgs splits the arguments of a type on the commas that are not nested in parentheses.
+func splitTypeArgs(s string) ([]string, error) {
+	var args []string
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced parentheses")
+			}
+		case ',':
+			if depth == 0 {
+				args = append(args, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses")
+	}
+	if rest := strings.TrimSpace(s[start:]); rest != "" || len(args) > 0 {
+		args = append(args, rest)
+	}
+	return args, nil
+}
+
+// splitFieldName splits a named tuple element such as `a Nullable(String)` into its name and type.
+func splitFieldName(arg string) (string, string) {
+	i := strings.IndexAny(arg, " (")
+	if i < 0 || arg[i] == '(' {
+		return "", arg
+	}
+	return strings.Trim(arg[:i], "`\""), strings.TrimSpace(arg[i+1:])
+}
+
+// String formats the type the way Databend does, keeping the element names of named tuples.
+func (t ColumnType) String() string {
+	return t.format(true)
+}
+
+// Unnamed formats the type without tuple element names, the only form databend-go can parse.
+func (t ColumnType) Unnamed() string {
+	return t.format(false)
+}
+
+func (t ColumnType) format(names bool) string {
+	if len(t.Args) == 0 {
+		return t.Name
+	}
+	args := make([]string, len(t.Args))
+	for i, arg := range t.Args {
+		args[i] = arg.format(names)
+		if names && arg.Field != "" {
+			args[i] = arg.Field + " " + args[i]
+		}
+	}
+	return fmt.Sprintf("%s(%s)", t.Name, strings.Join(args, ", "))
+}
+
+// NotNull returns the type wrapped by Nullable, or the type itself.
+func (t ColumnType) NotNull() ColumnType {
+	if t.Name == "Nullable" && len(t.Args) == 1 {
+		return t.Args[0]
+	}
+	return t
+}
@@ -0,0 +1,51 @@
+package converters_test
+
+import (
+	"testing"
+
+	"github.com/cadl/grafana-databend-datasource/pkg/converters"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseColumnType(t *testing.T) {
+	tests := []struct {
+		in      string
+		name    string
+		args    int
+		unnamed string
+	}{
+		{in: "Int64", name: "Int64", unnamed: "Int64"},
+		{in: "Decimal(15, 2)", name: "Decimal", args: 2, unnamed: "Decimal(15, 2)"},
+		{in: "Array(Nullable(Float64))", name: "Array", args: 1, unnamed: "Array(Nullable(Float64))"},
+		{in: "Tuple(String, Int8)", name: "Tuple", args: 2, unnamed: "Tuple(String, Int8)"},
+		{in: "Tuple(a String, b Decimal(15, 2))", name: "Tuple", args: 2, unnamed: "Tuple(String, Decimal(15, 2))"},
+		{in: "Array(Tuple(x Float64, y Float64))", name: "Array", args: 1, unnamed: "Array(Tuple(Float64, Float64))"},
+		{in: "Map(String, Array(Int32))", name: "Map", args: 2, unnamed: "Map(String, Array(Int32))"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			typ, err := converters.ParseColumnType(tt.in)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.name, typ.Name)
+			assert.Len(t, typ.Args, tt.args)
+			assert.Equal(t, tt.in, typ.String())
+			assert.Equal(t, tt.unnamed, typ.Unnamed())
+		})
+	}
+}
+
+func TestParseColumnTypeFieldNames(t *testing.T) {
+	typ, err := converters.ParseColumnType("Tuple(a String, b Nullable(Int32))")
+	assert.Nil(t, err)
+	assert.Equal(t, "a", typ.Args[0].Field)
+	assert.Equal(t, "String", typ.Args[0].Name)
+	assert.Equal(t, "b", typ.Args[1].Field)
+	assert.Equal(t, "Int32", typ.Args[1].NotNull().Name)
+}
+
+func TestParseColumnTypeInvalid(t *testing.T) {
+	for _, in := range []string{"", "Array(Int32", "Tuple(a))", "Map(String, Int32))"} {
+		_, err := converters.ParseColumnType(in)
+		assert.NotNil(t, err, in)
+	}
+}
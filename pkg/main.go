@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"os"
 
 	"github.com/cadl/grafana-databend-datasource/pkg/plugin"
@@ -8,7 +9,6 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
-	"github.com/grafana/sqlds/v2"
 )
 
 func main() {
@@ -18,7 +18,6 @@ func main() {
 	}
 }
 
-func newDatasource(settings backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
-	ds := sqlds.NewDatasource(&plugin.Databend{})
-	return ds.NewDatasource(settings)
+func newDatasource(_ context.Context, settings backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
+	return plugin.NewDatasource(settings)
 }
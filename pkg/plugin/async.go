@@ -0,0 +1,396 @@
+package plugin
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+)
+
+// asyncResultTTL is how long the results of an async query are kept once it finished, panels submitting the
+// same queries meanwhile pick them up.
+const asyncResultTTL = time.Minute
+
+// asyncSweepInterval is how often the async queries whose results expired are forgotten.
+const asyncSweepInterval = 10 * time.Second
+
+const (
+	asyncStateRunning   = "running"
+	asyncStateDone      = "done"
+	asyncStateCancelled = "cancelled"
+)
+
+// AsyncQueryRequest submits queries to run in the background, in the shape of the requests of /api/ds/query:
+// the time range in epoch milliseconds and the queries as the frontend sends them.
+type AsyncQueryRequest struct {
+	From    string            `json:"from"`
+	To      string            `json:"to"`
+	Queries []json.RawMessage `json:"queries"`
+}
+
+// dataQueries returns the queries of the request as QueryData gets them.
+func (r AsyncQueryRequest) dataQueries() ([]backend.DataQuery, error) {
+	from, err := strconv.ParseInt(r.From, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start of the time range %q", r.From)
+	}
+	to, err := strconv.ParseInt(r.To, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid end of the time range %q", r.To)
+	}
+	if len(r.Queries) == 0 {
+		return nil, fmt.Errorf("async query needs queries")
+	}
+	timeRange := backend.TimeRange{From: time.UnixMilli(from), To: time.UnixMilli(to)}
+	queries := make([]backend.DataQuery, 0, len(r.Queries))
+	for _, raw := range r.Queries {
+		var q struct {
+			RefID         string  `json:"refId"`
+			QueryType     string  `json:"queryType"`
+			IntervalMs    float64 `json:"intervalMs"`
+			MaxDataPoints int64   `json:"maxDataPoints"`
+		}
+		if err := json.Unmarshal(raw, &q); err != nil {
+			return nil, fmt.Errorf("invalid query: %w", err)
+		}
+		queries = append(queries, backend.DataQuery{
+			RefID:         q.RefID,
+			QueryType:     q.QueryType,
+			Interval:      time.Duration(q.IntervalMs * float64(time.Millisecond)),
+			MaxDataPoints: q.MaxDataPoints,
+			TimeRange:     timeRange,
+			JSON:          raw,
+		})
+	}
+	return queries, nil
+}
+
+// AsyncQueryProgress is what Databend reported so far for a running query.
+type AsyncQueryProgress struct {
+	// QueryID is the id of the query on the server
+	QueryID   string  `json:"queryId,omitempty"`
+	RowsRead  uint64  `json:"rowsRead"`
+	BytesRead uint64  `json:"bytesRead"`
+	ElapsedMs float64 `json:"elapsedMs"`
+}
+
+// AsyncQueryStatus answers the polls of an async query. Results holds the responses of the queries that
+// finished, in the format of /api/ds/query, and Progress those of the queries still running.
+type AsyncQueryStatus struct {
+	ID       string                        `json:"id"`
+	State    string                        `json:"state"`
+	Progress map[string]AsyncQueryProgress `json:"progress,omitempty"`
+	Results  json.RawMessage               `json:"results,omitempty"`
+}
+
+// asyncQuery runs the queries of a request in the background, each on its own so the results of those
+// that finish first can be polled.
+type asyncQuery struct {
+	id     string
+	key    string
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	running   int
+	states    map[string]*queryState
+	responses backend.Responses
+	cancelled bool
+	// finished is when the last query finished, refs the panels that submitted the queries and did not
+	// cancel them
+	finished time.Time
+	refs     int
+}
+
+func (q *asyncQuery) setState(refID string, state *queryState) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.states[refID] = state
+}
+
+func (q *asyncQuery) setResponse(refID string, res backend.DataResponse) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.responses[refID] = res
+	delete(q.states, refID)
+	q.running--
+	if q.running == 0 {
+		q.finished = time.Now()
+	}
+}
+
+// expired tells whether the results of the query are no longer kept.
+func (q *asyncQuery) expired(now time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.running == 0 && now.Sub(q.finished) > asyncResultTTL
+}
+
+func (q *asyncQuery) status() (AsyncQueryStatus, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	status := AsyncQueryStatus{ID: q.id, State: asyncStateRunning, Progress: map[string]AsyncQueryProgress{}}
+	switch {
+	case q.cancelled:
+		status.State = asyncStateCancelled
+	case q.running == 0:
+		status.State = asyncStateDone
+	}
+	for refID, state := range q.states {
+		queryID, stats := state.queryStats()
+		progress := AsyncQueryProgress{QueryID: queryID}
+		if stats != nil {
+			progress.RowsRead, progress.BytesRead, progress.ElapsedMs = stats.ScanProgress.Rows, stats.ScanProgress.Bytes, stats.RunningTimeMS
+		}
+		status.Progress[refID] = progress
+	}
+	results, err := json.Marshal(backend.QueryDataResponse{Responses: q.responses})
+	if err != nil {
+		return status, err
+	}
+	status.Results = results
+	return status, nil
+}
+
+// asyncQueries are the async queries of a datasource, by id and by the key of their request. Once a query
+// is submitted, those whose results expired are swept on a timer until the datasource is disposed.
+type asyncQueries struct {
+	mu    sync.Mutex
+	byID  map[string]*asyncQuery
+	byKey map[string]*asyncQuery
+	// stop stops the sweeper, it is nil until the sweeper starts
+	stop     chan struct{}
+	disposed bool
+}
+
+// startSweeper sweeps the expired queries every interval until the queries are disposed. It must be called
+// with the lock held.
+func (a *asyncQueries) startSweeper(interval time.Duration) {
+	if a.stop != nil || a.disposed {
+		return
+	}
+	a.stop = make(chan struct{})
+	go func(stop <-chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				a.mu.Lock()
+				a.sweep(now)
+				a.mu.Unlock()
+			}
+		}
+	}(a.stop)
+}
+
+// dispose stops the sweeper and cancels the queries still running.
+func (a *asyncQueries) dispose() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stop != nil {
+		close(a.stop)
+		a.stop = nil
+	}
+	a.disposed = true
+	for _, q := range a.byID {
+		q.cancel()
+	}
+	a.byID, a.byKey = nil, nil
+}
+
+// sweep forgets the queries whose results expired.
+func (a *asyncQueries) sweep(now time.Time) {
+	for id, q := range a.byID {
+		if q.expired(now) {
+			q.cancel()
+			delete(a.byID, id)
+			if a.byKey[q.key] == q {
+				delete(a.byKey, q.key)
+			}
+		}
+	}
+}
+
+func (a *asyncQueries) get(id string) (*asyncQuery, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sweep(time.Now())
+	q, ok := a.byID[id]
+	return q, ok
+}
+
+// submitAsync starts the queries of the request in the background and returns their async query. A request
+// submitted again while its queries run, or shortly after, gets the async query of the first.
+func (ds *Datasource) submitAsync(pluginContext backend.PluginContext, req AsyncQueryRequest) (*asyncQuery, error) {
+	queries, err := req.dataQueries()
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	key := hex.EncodeToString(sum[:])
+
+	ds.async.mu.Lock()
+	defer ds.async.mu.Unlock()
+	if ds.async.disposed {
+		return nil, fmt.Errorf("the datasource was disposed")
+	}
+	ds.async.sweep(time.Now())
+	ds.async.startSweeper(asyncSweepInterval)
+	if q, ok := ds.async.byKey[key]; ok {
+		q.mu.Lock()
+		cancelled := q.cancelled
+		if !cancelled {
+			q.refs++
+		}
+		q.mu.Unlock()
+		if !cancelled {
+			return q, nil
+		}
+	}
+	id, err := newAsyncQueryID()
+	if err != nil {
+		return nil, err
+	}
+	// the queries outlive the request submitting them
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &asyncQuery{id: id, key: key, cancel: cancel, running: len(queries), states: map[string]*queryState{},
+		responses: backend.Responses{}, refs: 1}
+	if ds.async.byID == nil {
+		ds.async.byID, ds.async.byKey = map[string]*asyncQuery{}, map[string]*asyncQuery{}
+	}
+	ds.async.byID[id], ds.async.byKey[key] = q, q
+
+	for _, query := range queries {
+		go func(query backend.DataQuery) {
+			dataReq := &backend.QueryDataRequest{PluginContext: pluginContext, Queries: []backend.DataQuery{query}}
+			res, err := ds.queryData(ctx, dataReq, func(ctx context.Context) {
+				if state := queryStateFor(ctx, query.RefID); state != nil {
+					q.setState(query.RefID, state)
+				}
+			})
+			if err != nil {
+				q.setResponse(query.RefID, backend.DataResponse{Error: err})
+				return
+			}
+			q.setResponse(query.RefID, res.Responses[query.RefID])
+		}(query)
+	}
+	return q, nil
+}
+
+// cancelAsync drops a panel from the async query, the queries are cancelled once no panel waits for them.
+func (ds *Datasource) cancelAsync(id string) bool {
+	q, ok := ds.async.get(id)
+	if !ok {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.refs--
+	if q.refs <= 0 && q.running > 0 {
+		q.cancelled = true
+		q.cancel()
+	}
+	return true
+}
+
+func newAsyncQueryID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// handleAsyncSubmit starts an async query and returns its id.
+func (ds *Datasource) handleAsyncSubmit(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		asyncError(rw, http.StatusMethodNotAllowed, fmt.Errorf("async queries are submitted with POST"))
+		return
+	}
+	var asyncReq AsyncQueryRequest
+	if err := json.NewDecoder(req.Body).Decode(&asyncReq); err != nil {
+		asyncError(rw, http.StatusBadRequest, err)
+		return
+	}
+	pluginContext := httpadapter.PluginConfigFromContext(req.Context())
+	if pluginContext.DataSourceInstanceSettings == nil {
+		asyncError(rw, http.StatusBadRequest, fmt.Errorf("async queries need a datasource"))
+		return
+	}
+	q, err := ds.submitAsync(pluginContext, asyncReq)
+	if err != nil {
+		asyncError(rw, http.StatusBadRequest, err)
+		return
+	}
+	writeAsyncJSON(rw, map[string]string{"id": q.id})
+}
+
+// handleAsyncPoll returns the progress of an async query and the results of its queries that finished.
+func (ds *Datasource) handleAsyncPoll(rw http.ResponseWriter, req *http.Request) {
+	q, ok := ds.async.get(req.URL.Query().Get("id"))
+	if !ok {
+		asyncError(rw, http.StatusNotFound, fmt.Errorf("unknown async query, it may have expired"))
+		return
+	}
+	status, err := q.status()
+	if err != nil {
+		asyncError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	writeAsyncJSON(rw, status)
+}
+
+// handleAsyncCancel cancels an async query for the panel that submitted it.
+func (ds *Datasource) handleAsyncCancel(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		asyncError(rw, http.StatusMethodNotAllowed, fmt.Errorf("async queries are cancelled with POST"))
+		return
+	}
+	var body struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		asyncError(rw, http.StatusBadRequest, err)
+		return
+	}
+	if !ds.cancelAsync(body.ID) {
+		asyncError(rw, http.StatusNotFound, fmt.Errorf("unknown async query, it may have expired"))
+		return
+	}
+	writeAsyncJSON(rw, map[string]string{"id": body.ID})
+}
+
+func writeAsyncJSON(rw http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		asyncError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	rw.Header().Add("Content-Type", "application/json")
+	if _, err := rw.Write(b); err != nil {
+		backend.Logger.Error(err.Error())
+	}
+}
+
+func asyncError(rw http.ResponseWriter, status int, err error) {
+	rw.WriteHeader(status)
+	if _, err := rw.Write([]byte(err.Error())); err != nil {
+		backend.Logger.Error(err.Error())
+	}
+}
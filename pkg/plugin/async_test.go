@@ -0,0 +1,222 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	godatabend "github.com/databendcloud/databend-go"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAsyncQueryRequestDataQueries(t *testing.T) {
+	req := AsyncQueryRequest{From: "1693562400000", To: "1693566000000", Queries: []json.RawMessage{
+		json.RawMessage(`{"refId":"A","rawSql":"SELECT 1","intervalMs":30000,"maxDataPoints":500}`),
+	}}
+	queries, err := req.dataQueries()
+	assert.Nil(t, err)
+	assert.Equal(t, "A", queries[0].RefID)
+	assert.Equal(t, 30*time.Second, queries[0].Interval)
+	assert.Equal(t, int64(500), queries[0].MaxDataPoints)
+	assert.Equal(t, time.Date(2023, 9, 1, 10, 0, 0, 0, time.UTC), queries[0].TimeRange.From.UTC())
+
+	for _, req := range []AsyncQueryRequest{
+		{From: "now-1h", To: "1693566000000", Queries: req.Queries},
+		{From: "1693562400000", To: "1693566000000"},
+	} {
+		_, err := req.dataQueries()
+		assert.NotNil(t, err)
+	}
+}
+
+// newAsyncTestServer answers the fast query at once, while the slow one waits until it is released. The
+// queries killed are sent to kills.
+func newAsyncTestServer(t *testing.T) (*httptest.Server, <-chan string, func()) {
+	release := make(chan struct{})
+	kills := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			SQL string `json:"sql"`
+		}
+		if r.Method == http.MethodPost {
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+		}
+		resp := godatabend.QueryResponse{ID: "q-fast", Schema: []godatabend.DataField{{Name: "n", Type: "UInt64"}}, Data: [][]string{{"1"}}}
+		switch {
+		case strings.HasPrefix(req.SQL, "KILL QUERY"):
+			kills <- req.SQL
+		case strings.Contains(req.SQL, "slow"):
+			resp = godatabend.QueryResponse{ID: "q-slow", Schema: resp.Schema, NextURI: "/page/1",
+				Stats: godatabend.QueryStats{RunningTimeMS: 20, ScanProgress: godatabend.QueryProgress{Rows: 5, Bytes: 40}}}
+		case r.URL.Path == "/page/1":
+			<-release
+		}
+		assert.Nil(t, json.NewEncoder(w).Encode(resp))
+	}))
+	var once sync.Once
+	return server, kills, func() {
+		once.Do(func() { close(release) })
+	}
+}
+
+type testResourceSender func(res *backend.CallResourceResponse) error
+
+func (s testResourceSender) Send(res *backend.CallResourceResponse) error {
+	return s(res)
+}
+
+// callResource calls a resource of the datasource as Grafana does and returns the status and body.
+func callResource(t *testing.T, ds *Datasource, method, path, body string) (int, []byte) {
+	settings := &backend.DataSourceInstanceSettings{UID: ds.uid}
+	var status int
+	var resBody []byte
+	err := ds.CallResource(context.Background(), &backend.CallResourceRequest{
+		PluginContext: backend.PluginContext{DataSourceInstanceSettings: settings},
+		Path:          strings.SplitN(path, "?", 2)[0],
+		URL:           path,
+		Method:        method,
+		Body:          []byte(body),
+	}, testResourceSender(func(res *backend.CallResourceResponse) error {
+		if res.Status != 0 {
+			status = res.Status
+		}
+		resBody = append(resBody, res.Body...)
+		return nil
+	}))
+	assert.Nil(t, err)
+	return status, resBody
+}
+
+func TestAsyncQuery(t *testing.T) {
+	server, kills, release := newAsyncTestServer(t)
+	defer server.Close()
+	defer release()
+	ds := newTestDatasource(t, server, `"timezone":"UTC"`)
+
+	submit := `{"from":"1693562400000","to":"1693566000000","queries":[` +
+		`{"refId":"A","rawSql":"SELECT n FROM fast","format":1},{"refId":"B","rawSql":"SELECT n FROM slow","format":1}]}`
+	asyncID := func() string {
+		status, body := callResource(t, ds, http.MethodPost, "async/submit", submit)
+		assert.Equal(t, http.StatusOK, status, string(body))
+		var res struct {
+			ID string `json:"id"`
+		}
+		assert.Nil(t, json.Unmarshal(body, &res))
+		return res.ID
+	}
+	poll := func(id string) AsyncQueryStatus {
+		status, body := callResource(t, ds, http.MethodGet, "async/poll?id="+id, "")
+		assert.Equal(t, http.StatusOK, status, string(body))
+		var res AsyncQueryStatus
+		assert.Nil(t, json.Unmarshal(body, &res))
+		return res
+	}
+	cancel := func(id string) {
+		status, body := callResource(t, ds, http.MethodPost, "async/cancel", fmt.Sprintf(`{"id":%q}`, id))
+		assert.Equal(t, http.StatusOK, status, string(body))
+	}
+
+	id := asyncID()
+	// another panel submitting the queries picks up the running ones
+	assert.Equal(t, id, asyncID())
+
+	// the slow query reports its progress once its first page is read, before it completes: it cannot
+	// complete until it is released
+	var status AsyncQueryStatus
+	assert.Eventually(t, func() bool {
+		status = poll(id)
+		return status.Progress["B"].QueryID != ""
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, asyncStateRunning, status.State)
+	assert.NotContains(t, string(status.Results), `"B"`)
+	assert.Equal(t, AsyncQueryProgress{QueryID: "q-slow", RowsRead: 5, BytesRead: 40, ElapsedMs: 20}, status.Progress["B"])
+
+	// the results of the fast query come while the slow one runs
+	assert.Eventually(t, func() bool {
+		status = poll(id)
+		return strings.Contains(string(status.Results), `"A"`)
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, asyncStateRunning, status.State)
+	assert.Equal(t, AsyncQueryProgress{QueryID: "q-slow", RowsRead: 5, BytesRead: 40, ElapsedMs: 20}, status.Progress["B"])
+
+	var results backend.QueryDataResponse
+	assert.Nil(t, json.Unmarshal(status.Results, &results))
+	assert.Equal(t, 1, results.Responses["A"].Frames[0].Rows())
+
+	// the queries are cancelled, and killed on the server, once no panel waits for them
+	cancel(id)
+	assert.Equal(t, asyncStateRunning, poll(id).State)
+	cancel(id)
+	select {
+	case sql := <-kills:
+		assert.Equal(t, "KILL QUERY 'q-slow'", sql)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the query was not killed")
+	}
+	assert.Equal(t, asyncStateCancelled, poll(id).State)
+	assert.NotEqual(t, id, asyncID())
+
+	status2, _ := callResource(t, ds, http.MethodGet, "async/poll?id=unknown", "")
+	assert.Equal(t, http.StatusNotFound, status2)
+}
+
+func TestAsyncQueriesSweeper(t *testing.T) {
+	var a asyncQueries
+	cancelled := make(chan struct{})
+	expired := &asyncQuery{id: "expired", key: "k1", cancel: func() { close(cancelled) },
+		finished: time.Now().Add(-2 * asyncResultTTL)}
+	running := &asyncQuery{id: "running", key: "k2", cancel: func() {}, running: 1}
+	a.byID = map[string]*asyncQuery{expired.id: expired, running.id: running}
+	a.byKey = map[string]*asyncQuery{expired.key: expired, running.key: running}
+
+	// the expired query is forgotten without any poll or submit
+	a.mu.Lock()
+	a.startSweeper(10 * time.Millisecond)
+	a.mu.Unlock()
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the expired query was not swept")
+	}
+	a.mu.Lock()
+	assert.Equal(t, map[string]*asyncQuery{running.id: running}, a.byID)
+	assert.Equal(t, map[string]*asyncQuery{running.key: running}, a.byKey)
+	a.mu.Unlock()
+
+	// disposing stops the sweeper for good
+	a.dispose()
+	a.mu.Lock()
+	a.startSweeper(10 * time.Millisecond)
+	assert.Nil(t, a.stop)
+	assert.Empty(t, a.byID)
+	a.mu.Unlock()
+}
+
+func TestAsyncQueryDispose(t *testing.T) {
+	server, _, release := newAsyncTestServer(t)
+	defer server.Close()
+	defer release()
+	ds := newTestDatasource(t, server, `"timezone":"UTC"`)
+
+	submit := `{"from":"1693562400000","to":"1693566000000","queries":[{"refId":"B","rawSql":"SELECT n FROM slow","format":1}]}`
+	status, body := callResource(t, ds, http.MethodPost, "async/submit", submit)
+	assert.Equal(t, http.StatusOK, status, string(body))
+	var res struct {
+		ID string `json:"id"`
+	}
+	assert.Nil(t, json.Unmarshal(body, &res))
+
+	// the running queries are cancelled with the datasource, which takes no more of them
+	ds.Dispose()
+	status, _ = callResource(t, ds, http.MethodGet, "async/poll?id="+res.ID, "")
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = callResource(t, ds, http.MethodPost, "async/submit", submit)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
@@ -0,0 +1,249 @@
+package plugin
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/sqlds/v2"
+)
+
+const (
+	// defaultResultCacheTTL and defaultResultCacheMaxBytes apply when the settings leave them out
+	defaultResultCacheTTL      = time.Minute
+	defaultResultCacheMaxBytes = 64 << 20
+	// headerFromAlert is set by Grafana on the requests of alert rule evaluations
+	headerFromAlert = "FromAlert"
+)
+
+// resultCache keeps the responses of queries for a while, so dashboards viewed by many people at once run
+// their queries once. The least recently used responses are evicted to stay within the memory budget.
+type resultCache struct {
+	ttl      time.Duration
+	maxBytes int64
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	size    int64
+}
+
+type cacheEntry struct {
+	key string
+	res backend.DataResponse
+	// timeRange is the time range the response covers, for incremental queries
+	timeRange backend.TimeRange
+	size      int64
+	expires   time.Time
+}
+
+// newResultCache returns the cache of the settings, nil when the datasource does not cache results.
+func newResultCache(settings Settings) *resultCache {
+	if !settings.EnableResultCache {
+		return nil
+	}
+	ttl := time.Duration(settings.ResultCacheTTL) * time.Second
+	if ttl <= 0 {
+		ttl = defaultResultCacheTTL
+	}
+	return newCache(ttl, resultCacheMaxBytes(settings))
+}
+
+func newCache(ttl time.Duration, maxBytes int64) *resultCache {
+	return &resultCache{ttl: ttl, maxBytes: maxBytes, now: time.Now, entries: map[string]*list.Element{}, lru: list.New()}
+}
+
+func resultCacheMaxBytes(settings Settings) int64 {
+	if settings.ResultCacheMaxBytes <= 0 {
+		return defaultResultCacheMaxBytes
+	}
+	return settings.ResultCacheMaxBytes
+}
+
+func (c *resultCache) get(key string) (backend.DataResponse, bool) {
+	res, _, ok := c.getRange(key)
+	return res, ok
+}
+
+// getRange returns a response with the time range it covers.
+func (c *resultCache) getRange(key string) (backend.DataResponse, backend.TimeRange, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return backend.DataResponse{}, backend.TimeRange{}, false
+	}
+	entry := e.Value.(*cacheEntry)
+	if c.now().After(entry.expires) {
+		c.remove(e)
+		return backend.DataResponse{}, backend.TimeRange{}, false
+	}
+	c.lru.MoveToFront(e)
+	return entry.res, entry.timeRange, true
+}
+
+// set keeps a response for the TTL of the cache, unless it failed or is larger than the whole budget.
+func (c *resultCache) set(key string, res backend.DataResponse) {
+	c.setRange(key, res, backend.TimeRange{}, c.ttl)
+}
+
+// setRange keeps a response covering the time range for the TTL, within the budget of the cache.
+func (c *resultCache) setRange(key string, res backend.DataResponse, timeRange backend.TimeRange, ttl time.Duration) {
+	if res.Error != nil {
+		return
+	}
+	size := responseSize(res)
+	if size > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, res: res, timeRange: timeRange, size: size,
+		expires: c.now().Add(ttl)})
+	c.size += size
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *resultCache) remove(e *list.Element) {
+	entry := c.lru.Remove(e).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
+
+// responseSize estimates the memory a response holds from the types and lengths of the fields of its frames,
+// without copying their values.
+func responseSize(res backend.DataResponse) int64 {
+	var size int64
+	for _, frame := range res.Frames {
+		for _, field := range frame.Fields {
+			size += fieldSize(field)
+		}
+	}
+	return size
+}
+
+func fieldSize(field *data.Field) int64 {
+	rows := int64(field.Len())
+	size := rows * 8
+	switch field.Type().NonNullableType() {
+	case data.FieldTypeString:
+		// the header of every string and its bytes
+		size = rows * 16
+		for row := 0; row < field.Len(); row++ {
+			if v, ok := field.ConcreteAt(row); ok {
+				size += int64(len(v.(string)))
+			}
+		}
+	case data.FieldTypeJSON:
+		size = rows * 24
+		for row := 0; row < field.Len(); row++ {
+			if v, ok := field.ConcreteAt(row); ok {
+				size += int64(len(v.(json.RawMessage)))
+			}
+		}
+	case data.FieldTypeTime:
+		size = rows * 24
+	}
+	if field.Nullable() {
+		// the pointers of the values
+		size += rows * 8
+	}
+	return size
+}
+
+// cacheable tells whether the query of a request can be answered from the cache. Alert rules always read
+// fresh results.
+func (ds *Datasource) cacheable(req *backend.QueryDataRequest, q backend.DataQuery) bool {
+	if ds.cache == nil || req.Headers[headerFromAlert] == "true" {
+		return false
+	}
+	var options QueryOptions
+	if err := json.Unmarshal(q.JSON, &options); err != nil {
+		return false
+	}
+	return !options.NoCache
+}
+
+// resultCacheKey rounds the time range of the query to the TTL of the cache, so queries of the same
+// dashboard viewed a few seconds apart share their results, and returns the query with its key. The key
+// is made of the query as it runs, its SQL with the macros applied, and the settings of the datasource.
+func (ds *Datasource) resultCacheKey(q backend.DataQuery) (backend.DataQuery, string, error) {
+	q.TimeRange = backend.TimeRange{From: q.TimeRange.From.Truncate(ds.cache.ttl), To: q.TimeRange.To.Truncate(ds.cache.ttl)}
+	query, err := sqlds.GetQuery(q)
+	if err != nil {
+		return q, "", err
+	}
+	rawSQL, err := sqlds.Interpolate(ds.driver, query)
+	if err != nil {
+		return q, "", fmt.Errorf("%s: %w", "Could not apply macros", err)
+	}
+	var options QueryOptions
+	if err := json.Unmarshal(q.JSON, &options); err != nil {
+		return q, "", err
+	}
+	b, err := json.Marshal([]interface{}{ds.settingsKey, rawSQL, q.TimeRange.From.UnixMilli(), q.TimeRange.To.UnixMilli(),
+		query.Format, query.FillMissing, options})
+	if err != nil {
+		return q, "", err
+	}
+	sum := sha256.Sum256(b)
+	return q, hex.EncodeToString(sum[:]), nil
+}
+
+// cachedResponse returns the response for the query with the RefID, with the cache stat added. A hit drops the
+// stats and the id of the query that filled the cache, they would be read as those of the current run.
+func cachedResponse(res backend.DataResponse, refID string, hit bool) backend.DataResponse {
+	if !hit {
+		return responseWithStat(res, refID, queryStat("Cache hit", "bool", 0))
+	}
+	res = responseWithStat(res, refID, queryStat("Cache hit", "bool", 1))
+	for _, frame := range res.Frames {
+		frame.Meta.Stats = frame.Meta.Stats[len(frame.Meta.Stats)-1:]
+		if custom, ok := frame.Meta.Custom.(map[string]interface{}); ok {
+			kept := make(map[string]interface{}, len(custom))
+			for key, value := range custom {
+				if key != "queryId" {
+					kept[key] = value
+				}
+			}
+			frame.Meta.Custom = kept
+		}
+	}
+	return res
+}
+
+// responseWithStat returns the response for the query with the RefID, its frames copied with the stat added,
+// cached responses being shared by the queries reading them.
+func responseWithStat(res backend.DataResponse, refID string, stat data.QueryStat) backend.DataResponse {
+	frames := make(data.Frames, len(res.Frames))
+	for i, frame := range res.Frames {
+		f := *frame
+		f.Name = refID
+		meta := data.FrameMeta{}
+		if frame.Meta != nil {
+			meta = *frame.Meta
+		}
+		meta.Stats = append(append([]data.QueryStat{}, meta.Stats...), stat)
+		f.Meta = &meta
+		frames[i] = &f
+	}
+	return backend.DataResponse{Frames: frames, Status: res.Status}
+}
+
+// settingsKey identifies the settings of a datasource instance in the keys of its cache.
+func settingsKey(settings backend.DataSourceInstanceSettings) string {
+	sum := sha256.Sum256(append([]byte(fmt.Sprintf("%s/%d/", settings.UID, settings.Updated.UnixNano())), settings.JSONData...))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,124 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	godatabend "github.com/databendcloud/databend-go"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResultCache(t *testing.T) {
+	assert.Nil(t, newResultCache(Settings{}))
+	cache := newResultCache(Settings{EnableResultCache: true})
+	assert.Equal(t, defaultResultCacheTTL, cache.ttl)
+	assert.Equal(t, int64(defaultResultCacheMaxBytes), cache.maxBytes)
+
+	response := func(name string) backend.DataResponse {
+		return backend.DataResponse{Frames: data.Frames{data.NewFrame(name, data.NewField("n", nil, []int64{1, 2, 3}))}}
+	}
+	size := responseSize(response("A"))
+	assert.Equal(t, int64(3*8), size)
+	long := strings.Repeat("x", 1000)
+	assert.Equal(t, int64(2*16+1000+2*8), responseSize(backend.DataResponse{Frames: data.Frames{
+		data.NewFrame("A", data.NewField("s", nil, []*string{&long, nil})),
+	}}))
+	now := time.Date(2023, 9, 1, 10, 0, 0, 0, time.UTC)
+	cache = newResultCache(Settings{EnableResultCache: true, ResultCacheTTL: 10, ResultCacheMaxBytes: 2 * size})
+	cache.now = func() time.Time { return now }
+
+	cache.set("a", response("A"))
+	cache.set("b", response("B"))
+	_, ok := cache.get("a")
+	assert.True(t, ok)
+	// b is the least recently used once a was read
+	cache.set("c", response("C"))
+	_, ok = cache.get("b")
+	assert.False(t, ok)
+	res, ok := cache.get("c")
+	assert.True(t, ok)
+	assert.Equal(t, "C", res.Frames[0].Name)
+	assert.Equal(t, 2*size, cache.size)
+
+	// failed responses and those beyond the budget are not kept
+	cache.set("d", backend.DataResponse{Error: ErrorDatasourceBusy})
+	cache.set("e", backend.DataResponse{Frames: data.Frames{response("A").Frames[0], response("B").Frames[0], response("C").Frames[0]}})
+	_, ok = cache.get("d")
+	assert.False(t, ok)
+	_, ok = cache.get("e")
+	assert.False(t, ok)
+
+	now = now.Add(11 * time.Second)
+	_, ok = cache.get("a")
+	assert.False(t, ok)
+	assert.Equal(t, size, cache.size)
+}
+
+func TestQueryDataCached(t *testing.T) {
+	var queries atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries.Add(1)
+		assert.Nil(t, json.NewEncoder(w).Encode(godatabend.QueryResponse{
+			ID:     fmt.Sprintf("q%d", queries.Load()),
+			Stats:  godatabend.QueryStats{RunningTimeMS: 12},
+			Schema: []godatabend.DataField{{Name: "n", Type: "UInt64"}},
+			Data:   [][]string{{"1"}},
+		}))
+	}))
+	defer server.Close()
+	ds := newTestDatasource(t, server, `"timezone":"UTC","enableResultCache":true,"resultCacheTTL":60`)
+
+	from := time.Date(2023, 9, 1, 10, 0, 5, 0, time.UTC)
+	query := func(refID string, offset time.Duration, headers map[string]string, jsonData string) backend.DataResponse {
+		res, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+			PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: ds.uid}},
+			Headers:       headers,
+			Queries: []backend.DataQuery{{
+				RefID:     refID,
+				TimeRange: backend.TimeRange{From: from.Add(offset), To: from.Add(time.Hour + offset)},
+				JSON:      json.RawMessage(jsonData),
+			}},
+		})
+		assert.Nil(t, err)
+		return res.Responses[refID]
+	}
+	cacheHit := func(res backend.DataResponse) float64 {
+		stats := res.Frames[0].Meta.Stats
+		assert.Equal(t, "Cache hit", stats[len(stats)-1].DisplayName)
+		return stats[len(stats)-1].Value
+	}
+	rawSQL := `{"rawSql":"SELECT n FROM t","format":1}`
+
+	res := query("A", 0, nil, rawSQL)
+	assert.Equal(t, 0.0, cacheHit(res))
+	assert.Equal(t, "q1", res.Frames[0].Meta.Custom.(map[string]interface{})["queryId"])
+	// a panel refreshed within the rounded time range reads the cached result under its own RefID
+	res = query("B", 10*time.Second, nil, rawSQL)
+	assert.Equal(t, 1.0, cacheHit(res))
+	assert.Equal(t, "B", res.Frames[0].Name)
+	assert.Equal(t, int32(1), queries.Load())
+	// without the stats and id of the run that filled the cache
+	assert.Len(t, res.Frames[0].Meta.Stats, 1)
+	assert.NotContains(t, res.Frames[0].Meta.Custom, "queryId")
+
+	// alert rules and queries opting out always run
+	res = query("A", 0, map[string]string{headerFromAlert: "true"}, rawSQL)
+	assert.Nil(t, res.Error)
+	assert.Equal(t, int32(2), queries.Load())
+	query("A", 0, nil, `{"rawSql":"SELECT n FROM t","format":1,"noCache":true}`)
+	assert.Equal(t, int32(3), queries.Load())
+
+	// another SQL or time range misses
+	assert.Equal(t, 0.0, cacheHit(query("A", 0, nil, `{"rawSql":"SELECT n FROM u","format":1}`)))
+	assert.Equal(t, 0.0, cacheHit(query("A", time.Minute, nil, rawSQL)))
+	assert.Equal(t, int32(5), queries.Load())
+}
@@ -0,0 +1,484 @@
+package plugin
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	godatabend "github.com/databendcloud/databend-go"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/pkg/errors"
+
+	"github.com/cadl/grafana-databend-datasource/pkg/converters"
+)
+
+const (
+	// warehouseStartAttempts and warehouseStartDelay are how often and how far apart a query is started while
+	// it fails before the server ran it, as databend-go's sql driver does
+	warehouseStartAttempts = 5
+	warehouseStartDelay    = 2 * time.Second
+)
+
+// connector runs queries on the Databend HTTP API itself rather than through databend-go's sql driver.
+// The driver fails every query returning a named tuple, as it cannot parse the type, and keeps the types
+// of the columns to itself; the connector parses the types with the plugin's parser and gives the driver's
+// parsers the types without the tuple element names.
+//
+// It keeps what the driver does for the plugin: queries start again while their warehouse is starting, and
+// statements run with Exec are read to the end. It does not prepare statements nor begin transactions, which
+// sqlds never does.
+type connector struct {
+	cfg *godatabend.Config
+	// startDelay is the wait before a query that did not start is started again
+	startDelay time.Duration
+	// limiter caps the queries running at once on all connections, nil when they are not capped
+	limiter *queryLimiter
+	// retry runs read queries again on transient errors
+	retry retryPolicy
+}
+
+func newConnector(dsn string) (*connector, error) {
+	cfg, err := godatabend.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &connector{cfg: cfg, startDelay: warehouseStartDelay}, nil
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &conn{client: c.newClient(), location: c.cfg.Location, kill: c.killQuery, limiter: c.limiter,
+		startDelay: c.startDelay, retry: c.retry}, nil
+}
+
+func (c *connector) newClient() *godatabend.APIClient {
+	cfg := *c.cfg
+	// the client writes the session settings returned by the server into its params
+	cfg.Params = make(map[string]string, len(c.cfg.Params))
+	for k, v := range c.cfg.Params {
+		cfg.Params[k] = v
+	}
+	return godatabend.NewAPIClientFromConfig(&cfg)
+}
+
+// killQuery kills a query on the server. It runs on a client of its own, the client of the query may be
+// waiting for its next page.
+func (c *connector) killQuery(queryID string) {
+	if _, err := c.newClient().QuerySingle("KILL QUERY "+quoteString(queryID), nil); err != nil {
+		backend.Logger.Warn("Failed to kill the query", "queryId", queryID, "error", err)
+	}
+}
+
+func (c *connector) Driver() driver.Driver {
+	return godatabend.DatabendDriver{}
+}
+
+type conn struct {
+	client *godatabend.APIClient
+	// location is the timezone of the datasource, dates and timestamps without one are read in it
+	location   *time.Location
+	startDelay time.Duration
+	// kill kills a query on the server, when the context of the query is done before the query is
+	kill func(queryID string)
+	// limiter gives the queries their slot, held until their rows are closed
+	limiter *queryLimiter
+	retry   retryPolicy
+	// abandoned is set when a query returned while a request of its client still runs, the connection is
+	// not reused then
+	abandoned atomic.Bool
+}
+
+func (c *conn) IsValid() bool {
+	return !c.abandoned.Load()
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	r, err := c.QueryContext(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	dest := make([]driver.Value, len(r.Columns()))
+	for {
+		if err := r.Next(dest); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return driver.RowsAffected(r.(*rows).resp.Stats.WriteProgress.Rows), nil
+}
+
+// startQuery starts the query and returns its first page, starting it again while it fails before the server
+// ran it, as when its warehouse did not start in time or the proxy in front of Databend failed.
+func (c *conn) startQuery(ctx context.Context, query string, values []driver.Value) (*godatabend.QueryResponse, error) {
+	resp, err := c.client.DoQuery(query, values)
+	for attempt := 1; err != nil && attempt < warehouseStartAttempts && notStarted(err); attempt++ {
+		select {
+		case <-time.After(c.startDelay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		resp, err = c.client.DoQuery(query, values)
+	}
+	return resp, err
+}
+
+// notStarted tells whether the query failed before the server ran it, running it again cannot run it twice.
+func notStarted(err error) bool {
+	return godatabend.IsProxyErr(err) || strings.Contains(err.Error(), godatabend.ProvisionWarehouseTimeout)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	depth, wait, err := c.limiter.acquire(ctx)
+	if state := queryStateFromContext(ctx); state != nil && c.limiter != nil {
+		state.setQueue(depth, wait)
+	}
+	if err != nil {
+		return nil, recordError(ctx, err)
+	}
+	resp, retries, err := c.retry.do(ctx, query, func() (*godatabend.QueryResponse, error) {
+		return c.doQuery(ctx, query, values)
+	})
+	if state := queryStateFromContext(ctx); state != nil {
+		state.setRetries(retries)
+	}
+	if err != nil {
+		c.limiter.release()
+		return nil, recordError(ctx, err)
+	}
+	// the rows give back the slot of the query, also when they fail
+	r, err := newRows(ctx, c, resp)
+	if err != nil {
+		return nil, recordError(ctx, err)
+	}
+	if r.state != nil {
+		r.state.setColumns(r.columns, r.types)
+		r.state.setLocation(c.location)
+	}
+	return r, nil
+}
+
+// doQuery starts the query and returns its first page. When the context is done first it returns at once,
+// and kills the query once the server started it.
+func (c *conn) doQuery(ctx context.Context, query string, values []driver.Value) (*godatabend.QueryResponse, error) {
+	page := awaitPage(ctx, func() (*godatabend.QueryResponse, error) {
+		return c.startQuery(ctx, query, values)
+	})
+	select {
+	case res := <-page:
+		return res.resp, res.err
+	case <-ctx.Done():
+		c.abandoned.Store(true)
+		go func() {
+			if res := <-page; res.err == nil && res.resp.NextURI != "" {
+				c.kill(res.resp.ID)
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+type pageResult struct {
+	resp *godatabend.QueryResponse
+	err  error
+}
+
+// awaitPage requests a page in the background when the context can be done, the client cannot be cancelled.
+func awaitPage(ctx context.Context, request func() (*godatabend.QueryResponse, error)) <-chan pageResult {
+	page := make(chan pageResult, 1)
+	if ctx.Done() == nil {
+		resp, err := request()
+		page <- pageResult{resp: resp, err: err}
+		return page
+	}
+	go func() {
+		resp, err := request()
+		page <- pageResult{resp: resp, err: err}
+	}()
+	return page
+}
+
+type rows struct {
+	ctx     context.Context
+	conn    *conn
+	resp    *godatabend.QueryResponse
+	columns []string
+	// typeNames are the types as reported by Databend, types the parsed ones
+	typeNames []string
+	types     []converters.ColumnType
+	parsers   []godatabend.DataParser
+	// state receives the stats of every page read, when the query runs for sqlds
+	state *queryState
+	// limits cap the rows returned, rowCount and byteCount count those returned so far
+	limits    ResultLimits
+	rowCount  int64
+	byteCount int64
+	truncated bool
+	// queryID is the id of the query on the server, finished is set once its last page is read and closed
+	// when the rows are
+	queryID   string
+	finished  atomic.Bool
+	closed    chan struct{}
+	closeOnce sync.Once
+	// released is set once the slot of the query is given back
+	released atomic.Bool
+}
+
+func newRows(ctx context.Context, c *conn, resp *godatabend.QueryResponse) (*rows, error) {
+	r := &rows{ctx: ctx, conn: c, resp: resp, queryID: resp.ID, closed: make(chan struct{})}
+	r.finished.Store(resp.NextURI == "")
+	if !r.finished.Load() && ctx.Done() != nil {
+		go r.killOnDone()
+	}
+	if state := queryStateFromContext(ctx); state != nil {
+		r.state = state
+		r.limits = state.resultLimits()
+		r.recordStats()
+	}
+	if err := r.waitForData(); err != nil {
+		r.close()
+		r.release()
+		return nil, err
+	}
+	for _, field := range r.resp.Schema {
+		t, err := converters.ParseColumnType(field.Type)
+		if err != nil {
+			_ = r.Close()
+			return nil, err
+		}
+		desc, err := godatabend.ParseTypeDesc(t.Unnamed())
+		if err != nil {
+			_ = r.Close()
+			return nil, fmt.Errorf("failed to parse the type '%s' of column %s: %w", field.Type, field.Name, err)
+		}
+		parser := newParser(desc, &godatabend.DataParserOptions{Location: c.location})
+		r.columns = append(r.columns, field.Name)
+		r.typeNames = append(r.typeNames, field.Type)
+		r.types = append(r.types, t)
+		r.parsers = append(r.parsers, parser)
+	}
+	return r, nil
+}
+
+// waitForData follows the next pages until one holds data or the query is finished. The schema only comes
+// with the first page.
+func (r *rows) waitForData() error {
+	schema := r.resp.Schema
+	for r.resp.NextURI != "" && len(r.resp.Data) == 0 {
+		nextURI := r.resp.NextURI
+		var res pageResult
+		select {
+		case res = <-awaitPage(r.ctx, func() (*godatabend.QueryResponse, error) { return r.conn.client.QueryPage(nextURI) }):
+		case <-r.ctx.Done():
+			r.conn.abandoned.Store(true)
+			return r.ctx.Err()
+		}
+		if res.err != nil {
+			return res.err
+		}
+		if res.resp.Error != nil {
+			return res.resp.Error
+		}
+		r.resp = res.resp
+		r.finished.Store(r.resp.NextURI == "")
+	}
+	if len(r.resp.Schema) == 0 {
+		r.resp.Schema = schema
+	}
+	r.recordStats()
+	return nil
+}
+
+// recordStats keeps the progress of the query, the stats of a page cover the pages before it.
+func (r *rows) recordStats() {
+	if r.state != nil {
+		r.state.setStats(r.resp.ID, r.resp.Stats)
+	}
+}
+
+func (r *rows) Columns() []string {
+	return r.columns
+}
+
+// killOnDone kills the query when the context is done before the rows are closed or the query finished.
+func (r *rows) killOnDone() {
+	select {
+	case <-r.ctx.Done():
+		select {
+		case <-r.closed:
+		default:
+			if !r.finished.Load() {
+				r.conn.kill(r.queryID)
+			}
+		}
+	case <-r.closed:
+	}
+}
+
+// close stops watching the context, unless it is done already and the query is being killed.
+func (r *rows) close() {
+	if r.ctx.Err() == nil {
+		r.closeOnce.Do(func() { close(r.closed) })
+	}
+}
+
+// release gives back the slot of the query.
+func (r *rows) release() {
+	if r.released.CompareAndSwap(false, true) {
+		r.conn.limiter.release()
+	}
+}
+
+func (r *rows) Close() error {
+	r.close()
+	r.release()
+	if r.resp.NextURI == "" || r.resp.FinalURI == "" {
+		return nil
+	}
+	// the rows were not read to the end, release the query on the server
+	_, err := r.conn.client.QueryPage(r.resp.FinalURI)
+	return err
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.truncated {
+		return io.EOF
+	}
+	if len(r.resp.Data) == 0 {
+		if err := r.waitForData(); err != nil {
+			return recordError(r.ctx, err)
+		}
+	}
+	if len(r.resp.Data) == 0 {
+		return io.EOF
+	}
+	line := r.resp.Data[0]
+	if limit, max := r.exceedsLimits(line); limit != "" {
+		r.truncate(limit, max)
+		return io.EOF
+	}
+	r.resp.Data = r.resp.Data[1:]
+	for i := range line {
+		v, err := r.parsers[i].Parse(strings.NewReader(line[i]))
+		if err != nil {
+			return fmt.Errorf("failed to parse the value of column %s: %w", r.columns[i], err)
+		}
+		dest[i] = v
+	}
+	return nil
+}
+
+// exceedsLimits counts the line as returned unless it would exceed a limit of the query, which it returns
+// with its value.
+func (r *rows) exceedsLimits(line []string) (string, int64) {
+	size := 0
+	for _, v := range line {
+		size += len(v)
+	}
+	if r.limits.MaxRows > 0 && r.rowCount >= r.limits.MaxRows {
+		return "rows", r.limits.MaxRows
+	}
+	if r.limits.MaxBytes > 0 && r.byteCount+int64(size) > r.limits.MaxBytes {
+		return "bytes", r.limits.MaxBytes
+	}
+	r.rowCount++
+	r.byteCount += int64(size)
+	return "", 0
+}
+
+// truncate ends the rows early. Pages left unread are not fetched, closing the rows releases the query on
+// the server, which stops it.
+func (r *rows) truncate(limit string, max int64) {
+	r.truncated = true
+	if r.state == nil {
+		return
+	}
+	dropped := int64(len(r.resp.Data))
+	// the server counts the rows it produced so far, which can be more than the page holds
+	if produced := int64(r.resp.Stats.ResultProgress.Rows) - r.rowCount; produced > dropped {
+		dropped = produced
+	}
+	r.state.setTruncation(truncation{limit: limit, max: max, rows: r.rowCount, dropped: dropped, more: r.resp.NextURI != ""})
+}
+
+// newParser returns the parser for values of the type. databend-go does not know every type, e.g. Binary,
+// Bitmap or Interval. Their text is handed to the converters as it is.
+func newParser(desc *godatabend.TypeDesc, opts *godatabend.DataParserOptions) godatabend.DataParser {
+	if desc.Name == "Nullable" && len(desc.Args) == 1 {
+		return nullableParser{parser: newParser(desc.Args[0], opts)}
+	}
+	parser, err := godatabend.NewDataParser(desc, opts)
+	if err != nil {
+		return textParser{}
+	}
+	return parser
+}
+
+// nullableParser parses the values of a Nullable(T) column with the parser of T, databend-go reads them as
+// strings, which cannot be scanned into dates or timestamps.
+type nullableParser struct {
+	parser godatabend.DataParser
+}
+
+func (p nullableParser) Parse(s io.RuneScanner) (driver.Value, error) {
+	text, err := textParser{}.Parse(s)
+	if err != nil || text == "NULL" {
+		return nil, err
+	}
+	return p.parser.Parse(strings.NewReader(text.(string)))
+}
+
+func (p nullableParser) Type() reflect.Type {
+	return p.parser.Type()
+}
+
+// textParser reads a value as the text Databend sent.
+type textParser struct{}
+
+func (textParser) Parse(s io.RuneScanner) (driver.Value, error) {
+	var b strings.Builder
+	for {
+		r, _, err := s.ReadRune()
+		if err == io.EOF {
+			return b.String(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		b.WriteRune(r)
+	}
+}
+
+func (textParser) Type() reflect.Type {
+	return reflect.TypeOf("")
+}
+
+func (r *rows) ColumnTypeScanType(index int) reflect.Type {
+	return r.parsers[index].Type()
+}
+
+func (r *rows) ColumnTypeDatabaseTypeName(index int) string {
+	return r.typeNames[index]
+}
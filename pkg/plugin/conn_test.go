@@ -0,0 +1,362 @@
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	godatabend "github.com/databendcloud/databend-go"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cadl/grafana-databend-datasource/pkg/converters"
+)
+
+// newTestServer stands in for the Databend HTTP API, answering every query with the first page and
+// serving the pages that follow on /page/<n>.
+func newTestServer(t *testing.T, pages ...godatabend.QueryResponse) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := 0
+		if strings.HasPrefix(r.URL.Path, "/page/") {
+			page = int(r.URL.Path[len("/page/")] - '0')
+		}
+		assert.Nil(t, json.NewEncoder(w).Encode(pages[page]))
+	}))
+}
+
+func openTestDB(t *testing.T, server *httptest.Server) *sql.DB {
+	return openTestDBIn(t, server, time.UTC)
+}
+
+// openTestDBIn opens a connection reading dates and timestamps in the location, as the datasource timezone does.
+func openTestDBIn(t *testing.T, server *httptest.Server, location *time.Location) *sql.DB {
+	cfg := godatabend.NewConfig()
+	cfg.Location = location
+	cfg.Host = strings.TrimPrefix(server.URL, "http://")
+	cfg.SSLMode = godatabend.SSL_MODE_DISABLE
+	cfg.User, cfg.Password = "databend", "databend"
+	c, err := newConnector(cfg.FormatDSN())
+	assert.Nil(t, err)
+	return sql.OpenDB(c)
+}
+
+// newTestDatasource creates a datasource instance connecting to the server, with the settings of jsonData
+// added to those of the connection.
+func newTestDatasource(t *testing.T, server *httptest.Server, jsonData string) *Datasource {
+	u, err := url.Parse(server.URL)
+	assert.Nil(t, err)
+	settings := backend.DataSourceInstanceSettings{
+		UID:                     "databend",
+		DecryptedSecureJSONData: map[string]string{"password": "databend"},
+		JSONData:                []byte(fmt.Sprintf(`{"server":%q,"port":%s,"username":"databend",%s}`, u.Hostname(), u.Port(), jsonData)),
+	}
+	instance, err := NewDatasource(settings)
+	assert.Nil(t, err)
+	return instance.(*Datasource)
+}
+
+func TestConnQueryNamedTuple(t *testing.T) {
+	server := newTestServer(t,
+		godatabend.QueryResponse{
+			Schema: []godatabend.DataField{
+				{Name: "id", Type: "Int64"},
+				{Name: "point", Type: "Tuple(name String, value Float64)"},
+			},
+			NextURI: "/page/1",
+		},
+		godatabend.QueryResponse{
+			Data: [][]string{{"1", "('home',1.5)"}, {"2", "('work',2.5)"}},
+		},
+	)
+	defer server.Close()
+	db := openTestDB(t, server)
+
+	ctx := withQueryStates(context.Background(), []backend.DataQuery{{RefID: "A"}})
+	ctx = withQueryState(ctx, "A")
+	rows, err := db.QueryContext(ctx, "SELECT id, point FROM t")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer rows.Close()
+
+	frame, err := sqlutil.FrameFromRows(rows, -1, converters.GetConverters()...)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, frame.Rows())
+	assert.JSONEq(t, `{"Field0":"work","Field1":2.5}`, string(*frame.Fields[1].At(1).(*json.RawMessage)))
+
+	state := queryStateFor(ctx, "A")
+	typ, ok := state.columnType("point")
+	assert.True(t, ok)
+	assert.Equal(t, "name", typ.Args[0].Field)
+
+	got, err := applyFormatOptions(frame, FormatOptions{TupleMode: TupleModeFields}, state.columnType)
+	assert.Nil(t, err)
+	assert.Equal(t, "point.value", got.Fields[2].Name)
+	assert.Equal(t, 2.5, *got.Fields[2].At(1).(*float64))
+}
+
+func TestConnQueryError(t *testing.T) {
+	server := newTestServer(t, godatabend.QueryResponse{
+		Error: &godatabend.QueryError{Code: 1025, Message: "Unknown table t"},
+	})
+	defer server.Close()
+	db := openTestDB(t, server)
+
+	_, err := db.QueryContext(context.Background(), "SELECT * FROM t")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Unknown table t")
+}
+
+func TestMutateResponseAppliesFormatOptions(t *testing.T) {
+	ctx := withQueryStates(context.Background(), []backend.DataQuery{
+		{RefID: "A", JSON: []byte(`{"rawSql":"SELECT v","formatOptions":{"arrayMode":"vector"}}`)},
+	})
+	typ, _ := converters.ParseColumnType("Array(Float64)")
+	queryStateFor(ctx, "A").setColumns([]string{"v"}, []converters.ColumnType{typ})
+
+	raw := json.RawMessage(`[1,2]`)
+	frame := data.NewFrame("A", data.NewField("v", nil, []*json.RawMessage{&raw}))
+	frame.SetMeta(&data.FrameMeta{})
+	d := &Databend{}
+	res, err := d.MutateResponse(ctx, data.Frames{frame})
+	assert.Nil(t, err)
+	assert.Len(t, res[0].Fields, 2)
+	assert.Equal(t, "v[2]", res[0].Fields[1].Name)
+}
+
+func TestConnExecRetriedWhileWarehouseStarts(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"message":"ProvisionWarehouseTimeout"}`))
+			return
+		}
+		assert.Nil(t, json.NewEncoder(w).Encode(godatabend.QueryResponse{
+			Stats: godatabend.QueryStats{WriteProgress: godatabend.QueryProgress{Rows: 3}},
+		}))
+	}))
+	defer server.Close()
+	cfg := godatabend.NewConfig()
+	cfg.Host = strings.TrimPrefix(server.URL, "http://")
+	cfg.SSLMode = godatabend.SSL_MODE_DISABLE
+	cfg.User, cfg.Password = "databend", "databend"
+	c, err := newConnector(cfg.FormatDSN())
+	assert.Nil(t, err)
+	c.startDelay = time.Millisecond
+	db := sql.OpenDB(c)
+
+	// the warehouse did not start in time, the query did not run and starts again
+	res, err := db.Exec("INSERT INTO t VALUES (1), (2), (3)")
+	if assert.Nil(t, err) {
+		affected, err := res.RowsAffected()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(3), affected)
+	}
+	assert.Equal(t, int32(2), requests.Load())
+}
+
+func TestQueryDataPointCoordinates(t *testing.T) {
+	server := newTestServer(t, godatabend.QueryResponse{
+		Schema: []godatabend.DataField{
+			{Name: "location", Type: "Nullable(Geometry)"},
+			{Name: "payload", Type: "Variant"},
+		},
+		Data: [][]string{{"POINT(2.35 48.85)", `{"type":"Point","coordinates":[1,2]}`}, {"NULL", "NULL"}},
+	})
+	defer server.Close()
+	ds := newTestDatasource(t, server, `"timezone":"UTC"`)
+
+	res, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: ds.uid}},
+		Queries:       []backend.DataQuery{{RefID: "A", JSON: json.RawMessage(`{"rawSql":"SELECT location, payload FROM t","format":1}`)}},
+	})
+	assert.Nil(t, err)
+	a := res.Responses["A"]
+	if !assert.Nil(t, a.Error) || !assert.Len(t, a.Frames, 1) {
+		return
+	}
+	// only the Geometry column gets coordinates, the Variant holding GeoJSON is left as it is
+	var names []string
+	for _, f := range a.Frames[0].Fields {
+		names = append(names, f.Name)
+	}
+	assert.Equal(t, []string{"location", "location.lat", "location.lon", "payload"}, names)
+	assert.Equal(t, 48.85, *a.Frames[0].Fields[1].At(0).(*float64))
+	assert.Nil(t, a.Frames[0].Fields[2].At(1))
+}
+
+func TestConnQueryDatesInTimezone(t *testing.T) {
+	server := newTestServer(t, godatabend.QueryResponse{
+		Schema: []godatabend.DataField{
+			{Name: "d", Type: "Date"},
+			{Name: "ts", Type: "Nullable(Timestamp)"},
+		},
+		Data: [][]string{{"2014-11-12", "2014-11-12 01:45:26.000000"}},
+	})
+	defer server.Close()
+
+	for _, timezone := range []string{"Asia/Shanghai", "America/New_York"} {
+		t.Run(timezone, func(t *testing.T) {
+			location, err := time.LoadLocation(timezone)
+			assert.Nil(t, err)
+			db := openTestDBIn(t, server, location)
+
+			ctx := withQueryStates(context.Background(), []backend.DataQuery{
+				{RefID: "A", JSON: []byte(`{"formatOptions":{"dateMode":"string"}}`)},
+				{RefID: "B"},
+			})
+			frames := data.Frames{}
+			for _, refID := range []string{"A", "B"} {
+				rows, err := db.QueryContext(withQueryState(ctx, refID), "SELECT d, ts FROM t")
+				if !assert.Nil(t, err) {
+					return
+				}
+				frame, err := sqlutil.FrameFromRows(rows, -1, converters.GetConverters()...)
+				assert.Nil(t, rows.Close())
+				assert.Nil(t, err)
+				frame.Name = refID
+				frames = append(frames, frame)
+			}
+
+			res, err := (&Databend{}).MutateResponse(ctx, frames)
+			assert.Nil(t, err)
+			assert.Equal(t, "2014-11-12", *res[0].Fields[0].At(0).(*string))
+			assert.Equal(t, time.Date(2014, 11, 12, 0, 0, 0, 0, time.UTC), res[1].Fields[0].At(0).(time.Time))
+
+			ts := *res[1].Fields[1].At(0).(*time.Time)
+			assert.Equal(t, time.UTC, ts.Location())
+			assert.True(t, time.Date(2014, 11, 12, 1, 45, 26, 0, location).Equal(ts))
+			assert.Equal(t, timezone, res[1].Fields[1].Config.Custom["timezone"])
+		})
+	}
+}
+
+func TestConnQueryLiterals(t *testing.T) {
+	server := newTestServer(t, godatabend.QueryResponse{
+		Schema: []godatabend.DataField{
+			{Name: "[]", Type: "EmptyArray"},
+			{Name: "{}", Type: "EmptyMap"},
+			{Name: "x", Type: "NULL"},
+		},
+		Data: [][]string{{"[]", "{}", "NULL"}},
+	})
+	defer server.Close()
+	db := openTestDB(t, server)
+
+	rows, err := db.QueryContext(context.Background(), "SELECT [], {}, NULL AS x")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer rows.Close()
+
+	frame, err := sqlutil.FrameFromRows(rows, -1, converters.GetConverters()...)
+	assert.Nil(t, err)
+	assert.Equal(t, "[]", string(*frame.Fields[0].At(0).(*json.RawMessage)))
+	assert.Equal(t, "{}", string(*frame.Fields[1].At(0).(*json.RawMessage)))
+	assert.Equal(t, data.FieldTypeNullableString, frame.Fields[2].Type())
+	assert.Nil(t, frame.Fields[2].At(0))
+}
+
+// newKillTestServer stands in for a Databend server running a long query: its pages, and its start when
+// startBlocks is set, wait until they are released. The queries killed are sent to kills.
+func newKillTestServer(t *testing.T, startBlocks bool) (*httptest.Server, <-chan killRequest, func()) {
+	release := make(chan struct{})
+	kills := make(chan killRequest, 1)
+	var blocked atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			SQL string `json:"sql"`
+		}
+		if r.Method == http.MethodPost {
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+		}
+		resp := godatabend.QueryResponse{ID: "q'1", Schema: []godatabend.DataField{{Name: "n", Type: "UInt64"}}, NextURI: "/page/1"}
+		switch {
+		case strings.HasPrefix(req.SQL, "KILL QUERY"):
+			kills <- killRequest{sql: req.SQL, blocked: blocked.Load() > 0}
+			resp = godatabend.QueryResponse{ID: "q-2"}
+		case r.URL.Path == "/page/1", req.SQL != "" && startBlocks:
+			blocked.Add(1)
+			<-release
+			blocked.Add(-1)
+		}
+		assert.Nil(t, json.NewEncoder(w).Encode(resp))
+	}))
+	var once sync.Once
+	return server, kills, func() {
+		once.Do(func() { close(release) })
+	}
+}
+
+// killRequest is a query killed on the test server, blocked tells whether a request of the query was still
+// waiting for its answer then.
+type killRequest struct {
+	sql     string
+	blocked bool
+}
+
+func TestConnQueryKilledOnCancel(t *testing.T) {
+	for _, startBlocks := range []bool{false, true} {
+		t.Run(fmt.Sprintf("start blocks %v", startBlocks), func(t *testing.T) {
+			server, kills, release := newKillTestServer(t, startBlocks)
+			defer server.Close()
+			defer release()
+			db := openTestDB(t, server)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+			_, err := db.QueryContext(ctx, "SELECT n FROM t")
+			assert.ErrorIs(t, err, context.Canceled)
+			if startBlocks {
+				// the query is killed once the server returns its id
+				select {
+				case <-kills:
+					t.Fatal("the query was killed before it started")
+				case <-time.After(50 * time.Millisecond):
+				}
+				release()
+			}
+			select {
+			case kill := <-kills:
+				assert.Equal(t, "KILL QUERY 'q''1'", kill.sql)
+				assert.NotNil(t, ctx.Err())
+				// the client of the query still waits for its page, the kill runs on a client of its own
+				assert.Equal(t, !startBlocks, kill.blocked)
+			case <-time.After(5 * time.Second):
+				t.Fatal("the query was not killed")
+			}
+		})
+	}
+}
+
+func TestConnQueryNotKilledWhenDone(t *testing.T) {
+	server, kills, release := newKillTestServer(t, false)
+	defer server.Close()
+	defer release()
+	db := openTestDB(t, server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rows, err := db.QueryContext(ctx, "KILL QUERY 'q-0'")
+	assert.Nil(t, err)
+	<-kills
+	assert.Nil(t, rows.Close())
+	cancel()
+	select {
+	case <-kills:
+		t.Fatal("a finished query was killed")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
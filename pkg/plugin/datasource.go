@@ -0,0 +1,122 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
+	"github.com/grafana/sqlds/v2"
+)
+
+// Datasource is a Databend datasource instance. sqlds does the work, the wrapper gives every query a state
+// in the context so the driver hooks can read the query options and record what Databend returned.
+type Datasource struct {
+	*sqlds.SQLDatasource
+	driver *Databend
+	// uid is the key of the connection of the datasource in sqlds
+	uid string
+	// tails counts the live tails the datasource runs
+	tails streamLimiter
+	// async are the async queries of the datasource, running or finished recently
+	async asyncQueries
+	// cache keeps the results of the queries when the datasource caches them, settingsKey tells apart
+	// those of other settings
+	cache       *resultCache
+	settingsKey string
+	// incrementalOverlap is how far before the last bucket read a refresh of an incremental query reads again
+	incrementalOverlap time.Duration
+}
+
+// NewDatasource creates a Databend datasource instance.
+func NewDatasource(settings backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
+	s, err := LoadSettings(settings)
+	if err != nil {
+		return nil, err
+	}
+	d, err := NewDatabend(s)
+	if err != nil {
+		return nil, err
+	}
+	ds := &Datasource{SQLDatasource: sqlds.NewDatasource(d), driver: d, uid: datasourceUID(&settings),
+		cache: newResultCache(s), settingsKey: settingsKey(settings),
+		incrementalOverlap: time.Duration(s.IncrementalCacheOverlap) * time.Second}
+	ds.CustomRoutes = map[string]func(http.ResponseWriter, *http.Request){
+		"/logs/context": ds.handleLogsContext,
+		"/async/submit": ds.handleAsyncSubmit,
+		"/async/poll":   ds.handleAsyncPoll,
+		"/async/cancel": ds.handleAsyncCancel,
+	}
+	if _, err := ds.SQLDatasource.NewDatasource(settings); err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// Dispose stops the background work of the datasource when Grafana drops the instance.
+func (ds *Datasource) Dispose() {
+	ds.async.dispose()
+	ds.SQLDatasource.Dispose()
+}
+
+func (ds *Datasource) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	return ds.queryData(ctx, req, nil)
+}
+
+// queryData runs the queries, calling started, when set, with the context holding their states before sqlds
+// runs them.
+func (ds *Datasource) queryData(ctx context.Context, req *backend.QueryDataRequest, started func(ctx context.Context)) (*backend.QueryDataResponse, error) {
+	// logs volume queries are rewritten before sqlds runs them, those that cannot be fail on their own, and
+	// those whose results are cached are answered at once, incremental queries only run over their new buckets
+	queries := make([]backend.DataQuery, 0, len(req.Queries))
+	answered := map[string]backend.DataResponse{}
+	cacheKeys := map[string]string{}
+	plans := map[string]*incrementalPlan{}
+	for _, q := range req.Queries {
+		q, err := ds.logsVolumeQuery(ctx, req.PluginContext, q)
+		if err != nil {
+			answered[q.RefID] = withErrorSource(backend.DataResponse{Error: err})
+			continue
+		}
+		if plan, ok := ds.planIncremental(req, q); ok {
+			plans[q.RefID] = plan
+			q = plan.runQuery()
+		} else if ds.cacheable(req, q) {
+			cq, key, err := ds.resultCacheKey(q)
+			if err == nil {
+				if res, ok := ds.cache.get(key); ok {
+					answered[q.RefID] = cachedResponse(res, q.RefID, true)
+					continue
+				}
+				q = cq
+				cacheKeys[q.RefID] = key
+			}
+		}
+		queries = append(queries, q)
+	}
+	r := *req
+	r.Queries = queries
+	ctx = withQueryStates(ctx, queries)
+	if started != nil {
+		started(ctx)
+	}
+	res, err := ds.SQLDatasource.QueryData(ctx, &r)
+	if err != nil {
+		return nil, err
+	}
+	withErrorSources(ctx, res)
+	if err := ds.mergeIncremental(ctx, &r, res, plans); err != nil {
+		return nil, err
+	}
+	for refID, key := range cacheKeys {
+		if queryRes, ok := res.Responses[refID]; ok && queryRes.Error == nil {
+			ds.cache.set(key, queryRes)
+			res.Responses[refID] = cachedResponse(queryRes, refID, false)
+		}
+	}
+	for refID, answer := range answered {
+		res.Responses[refID] = answer
+	}
+	return res, nil
+}
@@ -22,14 +22,77 @@ import (
 
 type Databend struct {
 	EnableLogsMapFieldFlatten bool
+	// LogsMapFieldFlattenSampleRows is the number of rows the keys of flattened map fields are taken from, all when 0
+	LogsMapFieldFlattenSampleRows int
+	// LogsMapFieldFlattenMaxDepth is the number of nested object levels flattened, no limit when 0
+	LogsMapFieldFlattenMaxDepth int
+	// LogsMapFieldFlattenStrict fails the query on map values that cannot be flattened instead of keeping them JSON
+	LogsMapFieldFlattenStrict bool
+	// registry resolves the converters of the instance, the default registry when nil
+	registry *converters.Registry
+	// logs enriches logs frames, nil when the datasource does not
+	logs *logsEnrichment
+	// LogsContextTieBreaker is the column ordering log lines sharing a timestamp in the context of a line
+	LogsContextTieBreaker string
+	// LogsTailMinInterval is the shortest time between two polls of a live tail
+	LogsTailMinInterval time.Duration
+	// LogsTailMaxStreams is the number of live tails the datasource runs at once
+	LogsTailMaxStreams int
+	// ResultLimits cap the results of the queries, LockResultLimits keeps queries from raising them
+	ResultLimits     ResultLimits
+	LockResultLimits bool
+	// location is the timezone of the datasource
+	location *time.Location
+	// limiter caps the queries of the datasource on all its connections, nil when they are not capped
+	limiter *queryLimiter
+	retry   retryPolicy
 }
 
+// NewDatabend creates the driver of a datasource instance, with the type overrides of its settings applied.
+func NewDatabend(settings Settings) (*Databend, error) {
+	registry := converters.DefaultRegistry.Clone()
+	for _, o := range settings.TypeOverrides {
+		fieldType, err := converters.OverrideFieldType(o.FieldType)
+		if err == nil {
+			err = registry.Override(o.Pattern, fieldType)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid type override for %s: %w", o.Pattern, err)
+		}
+	}
+	logs, err := newLogsEnrichment(settings)
+	if err != nil {
+		return nil, err
+	}
+	location, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid timezone: %s", settings.Timezone))
+	}
+	return &Databend{
+		EnableLogsMapFieldFlatten:     settings.EnableLogsMapFieldFlatten,
+		LogsMapFieldFlattenSampleRows: int(settings.LogsMapFieldFlattenSampleRows),
+		LogsMapFieldFlattenMaxDepth:   int(settings.LogsMapFieldFlattenMaxDepth),
+		LogsMapFieldFlattenStrict:     settings.LogsMapFieldFlattenStrict,
+		registry:                      registry,
+		logs:                          logs,
+		LogsContextTieBreaker:         settings.LogsContextTieBreaker,
+		LogsTailMinInterval:           logsTailMinInterval(settings),
+		LogsTailMaxStreams:            logsTailMaxStreams(settings),
+		ResultLimits:                  ResultLimits{MaxRows: settings.MaxResultRows, MaxBytes: settings.MaxResultBytes},
+		LockResultLimits:              settings.LockResultLimits,
+		location:                      location,
+		limiter:                       newQueryLimiter(settings),
+		retry:                         newRetryPolicy(settings),
+	}, nil
+}
+
+// Connect opens the connection of the datasource. What the driver derives from the settings is set once by
+// NewDatabend, MutateResponse reads it while sqlds connects again.
 func (d *Databend) Connect(config backend.DataSourceInstanceSettings, message json.RawMessage) (*sql.DB, error) {
 	settings, err := LoadSettings(config)
 	if err != nil {
 		return nil, err
 	}
-	d.EnableLogsMapFieldFlatten = settings.EnableLogsMapFieldFlatten
 	t, err := strconv.Atoi(settings.Timeout)
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf("invalid timeout: %s", settings.Timeout))
@@ -54,10 +117,12 @@ func (d *Databend) Connect(config backend.DataSourceInstanceSettings, message js
 		Location:     tz,
 	}
 
-	db, err := sql.Open("databend", cfg.FormatDSN())
+	c, err := newConnector(cfg.FormatDSN())
 	if err != nil {
 		return nil, err
 	}
+	c.limiter, c.retry = d.limiter, d.retry
+	db := sql.OpenDB(c)
 
 	timeout := time.Duration(t)
 	ctx, cancel := context.WithTimeout(context.Background(), timeout*time.Second)
@@ -84,8 +149,10 @@ func (d *Databend) Connect(config backend.DataSourceInstanceSettings, message js
 }
 
 func (d *Databend) Converters() []sqlutil.Converter {
-	// todo: replace converters
-	return converters.DatabendConverters
+	if d.registry == nil {
+		return converters.DatabendConverters
+	}
+	return d.registry.Converters()
 }
 
 func (d *Databend) Macros() sqlds.Macros {
@@ -119,150 +186,123 @@ func (d *Databend) Settings(config backend.DataSourceInstanceSettings) sqlds.Dri
 }
 
 func (d *Databend) MutateQuery(ctx context.Context, req backend.DataQuery) (context.Context, backend.DataQuery) {
-	return ctx, req
-}
-
-type MapField struct {
-	dfField *data.Field
-	t       string
+	if state := queryStateFor(ctx, req.RefID); state != nil {
+		state.setLimits(d.resultLimits(state.options.Limits))
+	}
+	return withQueryState(ctx, req.RefID), req
 }
 
-func newMapField(name string, labels data.Labels, v interface{}) (*MapField, error) {
-	var newInitialValueSlice interface{}
-	var t string
-
-	switch ov := v.(type) {
-	case float64:
-		newInitialValueSlice = []*float64{&ov}
-		t = "number"
-	case string:
-		newInitialValueSlice = []*string{&ov}
-		t = "string"
-	case bool:
-		newInitialValueSlice = []*bool{&ov}
-		t = "bool"
-	case nil:
-		newInitialValueSlice = []*string{nil}
-		t = "string"
-	default:
-		return nil, errors.New(fmt.Sprintf("unknown type: %T", v))
+// logsLevelColumns returns the columns the level of log lines is read from.
+func (d *Databend) logsLevelColumns() []string {
+	if d.logs == nil {
+		return defaultLogsLevelColumns
 	}
-
-	return &MapField{
-		dfField: data.NewField(name, labels, newInitialValueSlice),
-		t:       t,
-	}, nil
+	return d.logs.levelColumns
 }
 
-func (mf *MapField) convValue(value interface{}) interface{} {
-	switch mf.t {
-	case "number":
-		v, ok := value.(float64)
-		if !ok {
-			return nil
-		}
-		return &v
-	case "string":
-		v, ok := value.(string)
-		if !ok {
-			return nil
-		}
-		return &v
-	case "bool":
-		v, ok := value.(bool)
-		if !ok {
-			return nil
-		}
-		return &v
-	default:
-		panic(fmt.Sprintf("unknown type: %s", mf.t))
+func (d *Databend) flattenOptions() flattenOptions {
+	return flattenOptions{
+		sampleRows: d.LogsMapFieldFlattenSampleRows,
+		maxDepth:   d.LogsMapFieldFlattenMaxDepth,
+		strict:     d.LogsMapFieldFlattenStrict,
 	}
 }
 
-func (mf *MapField) append(value interface{}) error {
-	if value == nil {
-		mf.dfField.Append(nil)
-		return nil
-	} else {
-		v := mf.convValue(value)
-		if v == nil {
-			mf.dfField.Append(nil)
-			return nil
+// flattenOptionsFor returns how the map fields of the frame are flattened, if they are. The flatten options
+// of the query win over the datasource flattening logs.
+func (d *Databend) flattenOptionsFor(ctx context.Context, frame *data.Frame) (flattenOptions, bool, error) {
+	opts := d.flattenOptions()
+	if state := queryStateFor(ctx, frame.Name); state != nil && state.options.Flatten != nil {
+		f := state.options.Flatten
+		if err := f.validate(); err != nil {
+			return opts, false, err
 		}
-		mf.dfField.Append(v)
-		return nil
+		opts.columns, opts.include, opts.exclude, opts.maxFields = f.Columns, f.Include, f.Exclude, f.MaxFields
+		return opts, true, nil
 	}
+	isLogs := frame.Meta != nil && frame.Meta.PreferredVisualization == data.VisType(data.VisTypeLogs)
+	return opts, isLogs && d.EnableLogsMapFieldFlatten, nil
 }
 
-func flattenDFKvField(field *data.Field) ([]*data.Field, error) {
-	var newFields []*data.Field
-	firstRowKv := make(map[string]interface{})
-	firstRowRawMessage := field.At(0).(*json.RawMessage)
-	if firstRowRawMessage == nil {
-		return newFields, nil
-	}
-
-	err := json.Unmarshal(*firstRowRawMessage, &firstRowKv)
-	if err != nil {
-		return nil, err
-	}
-
-	mapFields := make(map[string]*MapField)
-
-	for k, v := range firstRowKv {
-		mapField, err := newMapField(fmt.Sprintf("%s['%s']", field.Name, k), field.Labels, v)
-		if err != nil {
-			return nil, err
+func (d *Databend) MutateResponse(ctx context.Context, res data.Frames) (data.Frames, error) {
+	newRes := make(data.Frames, 0, len(res))
+	for _, frame := range res {
+		if state := queryStateFor(ctx, frame.Name); state != nil && state.options.LogsVolume != nil {
+			frames, err := logsVolumeFrames(frame)
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range frames {
+				withStateStats(f, state)
+			}
+			newRes = append(newRes, frames...)
+			continue
 		}
-		mapFields[k] = mapField
-	}
-
-	for i := 1; i < field.Len(); i++ {
-		val := field.At(i).(*json.RawMessage)
-		if val == nil {
-			for _, v := range mapFields {
-				v.append(nil)
+		if state := queryStateFor(ctx, frame.Name); state != nil {
+			var err error
+			frame, err = applyFormatOptions(frame, state.options.FormatOptions, state.columnType)
+			if err != nil {
+				return nil, err
+			}
+			recordTimezone(frame, state.timezone(), state.columnType)
+			withStateStats(frame, state)
+			withTruncationNotice(frame, state.truncation())
+		}
+		if frame.Meta != nil && frame.Meta.PreferredVisualization == data.VisTypeTrace {
+			var traceOpts TraceOptions
+			if state := queryStateFor(ctx, frame.Name); state != nil {
+				traceOpts = state.options.Trace
 			}
-		} else {
-			var rowValues map[string]interface{}
-			err := json.Unmarshal(*val, &rowValues)
+			var err error
+			frame, err = traceFrame(frame, traceOpts)
 			if err != nil {
 				return nil, err
 			}
-			for kField, vField := range mapFields {
-				vField.append(rowValues[kField])
+		}
+		opts, ok, err := d.flattenOptionsFor(ctx, frame)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			frame, err = flattenFrame(frame, opts)
+			if err != nil {
+				return nil, err
 			}
 		}
+		if d.logs != nil && frame.Meta != nil && frame.Meta.PreferredVisualization == data.VisTypeLogs {
+			frame = d.logs.enrichFor(frame, queryStateFor(ctx, frame.Name))
+		}
+		if state := queryStateFor(ctx, frame.Name); state != nil {
+			frame = withPointCoordinates(frame, state.columnType)
+		}
+		newRes = append(newRes, frame)
 	}
-	for _, v := range mapFields {
-		newFields = append(newFields, v.dfField)
-	}
-	return newFields, nil
+	return newRes, nil
 }
 
-func (d *Databend) MutateResponse(ctx context.Context, res data.Frames) (data.Frames, error) {
-	newRes := make(data.Frames, 0, len(res))
-	for _, frame := range res {
-		if frame.Meta.PreferredVisualization == data.VisType(data.VisTypeLogs) && d.EnableLogsMapFieldFlatten {
-			// newFrame := data.NewFrame(frame.Name)
-			var newFields []*data.Field
-
-			for _, field := range frame.Fields {
-				newFields = append(newFields, field)
-				if (field.Type() == data.FieldTypeNullableJSON || field.Type() == data.FieldTypeJSON) && field.Len() > 0 {
-					flattenedFields, err := flattenDFKvField(field)
-					if err != nil {
-						return nil, err
-					}
-					newFields = append(newFields, flattenedFields...)
-				}
-			}
-			newFrame := data.NewFrame(frame.Name, newFields...)
-			newFrame.SetMeta(frame.Meta)
-			newRes = append(newRes, newFrame)
-		} else {
-			newRes = append(newRes, frame)
+// withPointCoordinates adds lat/lon fields next to every Geometry or Geography field holding points, so Geomap
+// panels can plot them. JSON columns holding GeoJSON are left as they are.
+func withPointCoordinates(frame *data.Frame, columnType func(name string) (converters.ColumnType, bool)) *data.Frame {
+	var newFields []*data.Field
+	found := false
+	for _, field := range frame.Fields {
+		newFields = append(newFields, field)
+		t, ok := columnType(field.Name)
+		if !ok {
+			continue
+		}
+		if name := t.NotNull().Name; name != "Geometry" && name != "Geography" {
+			continue
+		}
+		if coordinates := converters.PointCoordinateFields(field); coordinates != nil {
+			newFields = append(newFields, coordinates...)
+			found = true
 		}
 	}
-	return newRes, nil
+	if !found {
+		return frame
+	}
+	newFrame := data.NewFrame(frame.Name, newFields...)
+	newFrame.SetMeta(frame.Meta)
+	return newFrame
 }
@@ -393,6 +393,58 @@ func TestConvertString(t *testing.T) {
 //			checkRows(t, conn, 2, &val, nil)
 //		})
 //	}
+
+// setupPluginConnection connects the way the plugin does, so the types databend-go cannot scan are read as well.
+func setupPluginConnection(t *testing.T) *sql.DB {
+	settings := backend.DataSourceInstanceSettings{
+		JSONData:                []byte(fmt.Sprintf(`{ "server": "%s", "port": %d, "username": "%s", "defaultDatabase": "default"}`, databendHost, databendPort, databendUsername)),
+		DecryptedSecureJSONData: map[string]string{"password": databendPassword},
+	}
+	db, err := (&plugin.Databend{}).Connect(settings, json.RawMessage{})
+	require.NoError(t, err)
+	return db
+}
+
+func checkPluginRows(t *testing.T, rowLimit int64, expectedValues ...interface{}) {
+	conn := setupPluginConnection(t)
+	rows, err := conn.Query(fmt.Sprintf("SELECT * FROM simple_table LIMIT %d", rowLimit))
+	require.NoError(t, err)
+	frame, err := sqlutil.FrameFromRows(rows, rowLimit, converters.DatabendConverters...)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(frame.Fields))
+	checkFieldValue(t, frame.Fields[0], expectedValues...)
+}
+
+func TestConvertBinary(t *testing.T) {
+	t.Run(t.Name(), func(t *testing.T) {
+		conn, close := setupTest(t, "col1 Binary")
+		defer close(t)
+		_, err := conn.Exec("INSERT INTO simple_table VALUES (to_binary('Hello'))")
+		require.NoError(t, err)
+		checkPluginRows(t, 1, "48656C6C6F")
+	})
+}
+
+func TestConvertBitmap(t *testing.T) {
+	t.Run(t.Name(), func(t *testing.T) {
+		conn, close := setupTest(t, "col1 Bitmap")
+		defer close(t)
+		_, err := conn.Exec("INSERT INTO simple_table VALUES (to_bitmap('1,3,5'))")
+		require.NoError(t, err)
+		checkPluginRows(t, 1, []uint64{1, 3, 5})
+	})
+}
+
+func TestConvertInterval(t *testing.T) {
+	t.Run(t.Name(), func(t *testing.T) {
+		conn, close := setupTest(t, "col1 Interval")
+		defer close(t)
+		_, err := conn.Exec("INSERT INTO simple_table VALUES ('1 day 01:00:00'::INTERVAL)")
+		require.NoError(t, err)
+		checkPluginRows(t, 1, float64(90000))
+	})
+}
+
 func TestConvertDecimal(t *testing.T) {
 	t.Run(t.Name(), func(t *testing.T) {
 		conn, close := setupTest(t, "col1 Decimal(15,3)")
@@ -1,6 +1,15 @@
 package plugin
 
-import "github.com/pkg/errors"
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	godatabend "github.com/databendcloud/databend-go"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/pkg/errors"
+)
 
 var (
 	ErrorMessageInvalidJSON       = errors.New("could not parse json")
@@ -8,7 +17,181 @@ var (
 	ErrorMessageInvalidPort       = errors.New("invalid port")
 	ErrorMessageInvalidUserName   = errors.New("username is either empty or not set")
 	ErrorMessageInvalidPassword   = errors.New("password is either empty or not set")
-	ErrorMessageInvalidProtocol   = errors.New("protocol is invalid, use native or http")
 	ErrorInvalidClientCertificate = errors.New("tls: failed to find any PEM data in certificate input")
 	ErrorInvalidCACertificate     = errors.New("failed to parse TLS CA PEM certificate")
+	ErrorDatasourceBusy           = errors.New("datasource busy")
+	ErrorInvalidTypeOverride      = errors.New("invalid type override")
+)
+
+// The kinds of the errors Databend fails queries with.
+var (
+	ErrorSyntax               = errors.New("syntax error")
+	ErrorUnknownDatabase      = errors.New("unknown database")
+	ErrorUnknownTable         = errors.New("unknown table")
+	ErrorUnknownColumn        = errors.New("unknown column")
+	ErrorPermissionDenied     = errors.New("permission denied")
+	ErrorAuthentication       = errors.New("authentication failed")
+	ErrorQueryTimeout         = errors.New("query timeout")
+	ErrorMemoryLimit          = errors.New("memory limit exceeded")
+	ErrorWarehouseUnavailable = errors.New("warehouse unavailable")
+	ErrorQueryFailed          = errors.New("query failed")
 )
+
+const queryTimeoutHint = "Narrow the time range of the query, or raise the query timeout of the datasource"
+
+// databendErrorKind is how an error of Databend is recognized, by its code or its message, and explained.
+type databendErrorKind struct {
+	kind     error
+	codes    []int
+	messages []string
+	hint     string
+	status   backend.Status
+}
+
+// databendErrorKinds are matched in order, the codes of a kind before the messages of any.
+var databendErrorKinds = []databendErrorKind{
+	{kind: ErrorSyntax, codes: []int{1005}, messages: []string{"syntax error"}, status: backend.StatusBadRequest,
+		hint: "Check the SQL of the query near the position the error points at"},
+	{kind: ErrorUnknownDatabase, codes: []int{1003}, messages: []string{"unknown database"}, status: backend.StatusBadRequest,
+		hint: "Check the database exists and the default database of the datasource"},
+	{kind: ErrorUnknownTable, codes: []int{1025}, messages: []string{"unknown table"}, status: backend.StatusBadRequest,
+		hint: "Check the table exists, or qualify it with its database"},
+	{kind: ErrorUnknownColumn, codes: []int{1058}, messages: []string{"unknown column", "column not found"}, status: backend.StatusBadRequest,
+		hint: "Check the column exists in the tables of the query"},
+	{kind: ErrorPermissionDenied, codes: []int{1063}, messages: []string{"permission denied"}, status: backend.StatusForbidden,
+		hint: "Grant the user of the datasource the privileges the query needs"},
+	{kind: ErrorMemoryLimit, messages: []string{"memory limit", "memory usage exceeded", "out of memory"}, status: backend.StatusBadGateway,
+		hint: "Narrow the time range of the query or aggregate more, or raise the memory limit of the warehouse"},
+}
+
+// DatabendError is an error a query failed with, typed by its kind, with a hint on how to fix the query and
+// the source of the error: downstream for the errors Databend and its HTTP API returned, so the failures of
+// the warehouse do not count against the plugin, and the plugin for the rest.
+type DatabendError struct {
+	// Kind is one of the errors of the kinds above
+	Kind    error
+	Code    int
+	Message string
+	Hint    string
+	Source  backend.ErrorSource
+	Status  backend.Status
+	err     error
+}
+
+func (e *DatabendError) Error() string {
+	message := fmt.Sprintf("%s: %s", e.Kind, e.Message)
+	if e.Code != 0 {
+		message += fmt.Sprintf(" (code %d)", e.Code)
+	}
+	if e.Hint != "" {
+		message = strings.TrimRight(message, ".") + ". " + e.Hint
+	}
+	return message
+}
+
+// Is matches the kind of the error, errors.Is(err, ErrorUnknownTable) tells whether a table was unknown.
+func (e *DatabendError) Is(target error) bool {
+	return e.Kind == target
+}
+
+func (e *DatabendError) Unwrap() error {
+	return e.err
+}
+
+// mapDatabendError returns the DatabendError of an error the driver returned, the errors of the plugin as
+// they are. The error is downstream only when Databend or the requests of the client to it failed.
+func mapDatabendError(err error) error {
+	var dbErr *DatabendError
+	if err == nil || errors.As(err, &dbErr) || errors.Is(err, context.Canceled) || errors.Is(err, ErrorDatasourceBusy) {
+		return err
+	}
+	e := &DatabendError{Kind: ErrorQueryFailed, Message: err.Error(), Source: backend.ErrorSourcePlugin, Status: backend.StatusInternal, err: err}
+	var queryErr *godatabend.QueryError
+	var apiErr godatabend.APIError
+	if errors.As(err, &queryErr) || errors.As(err, &apiErr) || errors.Is(err, godatabend.ErrDoRequest) ||
+		errors.Is(err, godatabend.ErrReadResponse) || errors.Is(err, context.DeadlineExceeded) {
+		e.Source, e.Status = backend.ErrorSourceDownstream, backend.StatusBadGateway
+	}
+	switch {
+	case errors.As(err, &queryErr):
+		e.Code, e.Message = queryErr.Code, queryErr.Message
+	case errors.As(err, &apiErr):
+		e.Message = apiErr.Error()
+		switch apiErr.StatusCode {
+		case http.StatusUnauthorized:
+			e.Kind, e.Status, e.Hint = ErrorAuthentication, backend.StatusUnauthorized, "Check the username and password of the datasource"
+			return e
+		case http.StatusRequestTimeout:
+			e.Kind, e.Status, e.Hint = ErrorQueryTimeout, backend.StatusTimeout, queryTimeoutHint
+			return e
+		}
+	case errors.Is(err, context.DeadlineExceeded):
+		e.Kind, e.Hint, e.Status = ErrorQueryTimeout, queryTimeoutHint, backend.StatusTimeout
+		e.Message = "the query did not finish within the query timeout of the datasource"
+		return e
+	}
+	if isTransient(err) {
+		e.Kind, e.Hint = ErrorWarehouseUnavailable, "Databend could not be reached or its warehouse is starting, try again shortly"
+		return e
+	}
+	if kind, ok := matchErrorKind(e.Code, strings.ToLower(err.Error())); ok {
+		e.Kind, e.Hint, e.Status = kind.kind, kind.hint, kind.status
+	}
+	return e
+}
+
+func matchErrorKind(code int, message string) (databendErrorKind, bool) {
+	for _, kind := range databendErrorKinds {
+		for _, c := range kind.codes {
+			if c == code {
+				return kind, true
+			}
+		}
+	}
+	for _, kind := range databendErrorKinds {
+		for _, m := range kind.messages {
+			if strings.Contains(message, m) {
+				return kind, true
+			}
+		}
+	}
+	return databendErrorKind{}, false
+}
+
+// recordError maps the error the query failed with and keeps it in the state of the query, sqlds only passes
+// its text on.
+func recordError(ctx context.Context, err error) error {
+	err = mapDatabendError(err)
+	if state := queryStateFromContext(ctx); state != nil && err != nil {
+		state.setError(err)
+	}
+	return err
+}
+
+// withErrorSources types the errors of the responses with those the driver recorded for their queries, and
+// sets the source of their errors on the responses.
+func withErrorSources(ctx context.Context, res *backend.QueryDataResponse) {
+	for refID, queryRes := range res.Responses {
+		if queryRes.Error == nil {
+			continue
+		}
+		if state := queryStateFor(ctx, refID); state != nil {
+			if err := state.queryError(); err != nil {
+				queryRes.Error = err
+			}
+		}
+		res.Responses[refID] = withErrorSource(queryRes)
+	}
+}
+
+// withErrorSource sets the status of the response from its error and the source of the error.
+func withErrorSource(res backend.DataResponse) backend.DataResponse {
+	res.ErrorSource = backend.ErrorSourcePlugin
+	var dbErr *DatabendError
+	if errors.As(res.Error, &dbErr) {
+		res.Error, res.Status, res.ErrorSource = dbErr, dbErr.Status, dbErr.Source
+	} else if errors.Is(res.Error, ErrorDatasourceBusy) {
+		res.Status = backend.StatusTooManyRequests
+	}
+	return res
+}
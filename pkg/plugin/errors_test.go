@@ -0,0 +1,108 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	godatabend "github.com/databendcloud/databend-go"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapDatabendError(t *testing.T) {
+	queryError := func(code int, message string) error {
+		return errors.Wrap(errors.Wrap(&godatabend.QueryError{Code: code, Message: message}, "query error"), "failed to do query request")
+	}
+	tests := []struct {
+		err    error
+		kind   error
+		source backend.ErrorSource
+		status backend.Status
+	}{
+		{queryError(1005, "error: unexpected `FORM`"), ErrorSyntax, backend.ErrorSourceDownstream, backend.StatusBadRequest},
+		{queryError(1025, "Unknown table `default`.`logs`"), ErrorUnknownTable, backend.ErrorSourceDownstream, backend.StatusBadRequest},
+		{queryError(1063, "Permission denied, privilege [Select] is required"), ErrorPermissionDenied, backend.ErrorSourceDownstream, backend.StatusForbidden},
+		{queryError(1001, "query memory usage exceeded the limit"), ErrorMemoryLimit, backend.ErrorSourceDownstream, backend.StatusBadGateway},
+		{queryError(1001, "something else"), ErrorQueryFailed, backend.ErrorSourceDownstream, backend.StatusBadGateway},
+		{godatabend.NewAPIError("authorization failed", http.StatusUnauthorized, nil), ErrorAuthentication, backend.ErrorSourceDownstream, backend.StatusUnauthorized},
+		{godatabend.NewAPIError("please retry again later.", http.StatusServiceUnavailable, nil), ErrorWarehouseUnavailable, backend.ErrorSourceDownstream, backend.StatusBadGateway},
+		{godatabend.NewAPIError("request timeout", http.StatusRequestTimeout, nil), ErrorQueryTimeout, backend.ErrorSourceDownstream, backend.StatusTimeout},
+		{fmt.Errorf("query: %w", context.DeadlineExceeded), ErrorQueryTimeout, backend.ErrorSourceDownstream, backend.StatusTimeout},
+		// a timeout in the message of a query error is not the timeout of the query
+		{queryError(1001, "invalid argument: lock_timeout must be positive"), ErrorQueryFailed, backend.ErrorSourceDownstream, backend.StatusBadGateway},
+		{errors.Wrap(godatabend.ErrDoRequest, "dial tcp: connection refused"), ErrorWarehouseUnavailable, backend.ErrorSourceDownstream, backend.StatusBadGateway},
+		// the errors of the plugin reading the results are not those of Databend
+		{fmt.Errorf("failed to parse the type 'Tuple(' of column t: %w", errors.New("unexpected end")), ErrorQueryFailed, backend.ErrorSourcePlugin, backend.StatusInternal},
+	}
+	for _, tt := range tests {
+		err := mapDatabendError(tt.err)
+		var dbErr *DatabendError
+		if !assert.True(t, errors.As(err, &dbErr), tt.err.Error()) {
+			continue
+		}
+		assert.ErrorIs(t, err, tt.kind, tt.err.Error())
+		assert.Equal(t, tt.source, dbErr.Source)
+		assert.Equal(t, tt.status, dbErr.Status)
+	}
+
+	err := mapDatabendError(queryError(1025, "Unknown table `default`.`logs`."))
+	assert.Equal(t, "unknown table: Unknown table `default`.`logs`. (code 1025). Check the table exists, or qualify it with its database", err.Error())
+	// the errors of the plugin are left as they are
+	busy := fmt.Errorf("%w: 1 queries are running and 0 waiting, try again later", ErrorDatasourceBusy)
+	assert.Equal(t, busy, mapDatabendError(busy))
+	assert.Equal(t, context.Canceled, mapDatabendError(context.Canceled))
+}
+
+func TestQueryDataErrorSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, json.NewEncoder(w).Encode(godatabend.QueryResponse{
+			Error: &godatabend.QueryError{Code: 1025, Message: "Unknown table `default`.`missing`"},
+		}))
+	}))
+	defer server.Close()
+	ds := newTestDatasource(t, server, `"timezone":"UTC"`)
+
+	res, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: ds.uid}},
+		Queries: []backend.DataQuery{
+			{RefID: "A", JSON: json.RawMessage(`{"rawSql":"SELECT * FROM missing","format":1}`)},
+			{RefID: "B", JSON: json.RawMessage(`{"rawSql":"SELECT * FROM t WHERE $__timeFilter(a, b)","format":1}`)},
+		},
+	})
+	assert.Nil(t, err)
+
+	a := res.Responses["A"]
+	assert.ErrorIs(t, a.Error, ErrorUnknownTable)
+	assert.Equal(t, backend.StatusBadRequest, a.Status)
+	assert.Equal(t, backend.ErrorSourceDownstream, a.ErrorSource)
+
+	b := res.Responses["B"]
+	assert.NotNil(t, b.Error)
+	assert.Equal(t, backend.ErrorSourcePlugin, b.ErrorSource)
+}
+
+func TestQueryDataTypeParseErrorSource(t *testing.T) {
+	server := newTestServer(t, godatabend.QueryResponse{
+		Schema: []godatabend.DataField{{Name: "t", Type: "Tuple("}},
+		Data:   [][]string{{"(1"}},
+	})
+	defer server.Close()
+	ds := newTestDatasource(t, server, `"timezone":"UTC"`)
+
+	res, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: ds.uid}},
+		Queries:       []backend.DataQuery{{RefID: "A", JSON: json.RawMessage(`{"rawSql":"SELECT t FROM tuples","format":1}`)}},
+	})
+	assert.Nil(t, err)
+
+	// Databend answered, the plugin failed to read the type of the column
+	a := res.Responses["A"]
+	assert.ErrorContains(t, a.Error, "Tuple(")
+	assert.Equal(t, backend.ErrorSourcePlugin, a.ErrorSource)
+	assert.Equal(t, backend.StatusInternal, a.Status)
+}
@@ -0,0 +1,378 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+)
+
+const (
+	mapKindNumber = "number"
+	mapKindString = "string"
+	mapKindBool   = "bool"
+	// mapKindJSON is the kind of arrays, and of objects deeper than the flatten depth
+	mapKindJSON = "json"
+)
+
+// FlattenOptions select the map fields of a query that are flattened and the keys they are flattened into.
+// They flatten table frames as well as logs frames, whether or not the datasource flattens logs.
+type FlattenOptions struct {
+	// Columns are the JSON fields to flatten, all of them when empty
+	Columns []string `json:"columns,omitempty"`
+	// Include and Exclude are glob patterns for key paths, e.g. http.* - a path is matched by a pattern
+	// matching it or one of its parents. Exclude wins over Include, an empty Include includes every path.
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	// MaxFields caps the number of fields flattening adds to a frame, no limit when 0
+	MaxFields int `json:"maxFields,omitempty"`
+}
+
+func (o FlattenOptions) validate() error {
+	for _, pattern := range append(append([]string{}, o.Include...), o.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid key pattern %q: %w", pattern, err)
+		}
+	}
+	if o.MaxFields < 0 {
+		return fmt.Errorf("invalid maximum number of fields %d", o.MaxFields)
+	}
+	return nil
+}
+
+// flattenOptions control how map fields are flattened.
+type flattenOptions struct {
+	// columns are the fields to flatten, every JSON field when empty
+	columns []string
+	include []string
+	exclude []string
+	// maxFields is the number of fields that may be added to a frame, no limit when 0
+	maxFields int
+	// sampleRows is the number of rows the keys are taken from, all rows when 0
+	sampleRows int
+	// maxDepth is the number of nested object levels turned into fields, no limit when 0. Deeper objects
+	// stay JSON.
+	maxDepth int
+	// strict fails on values that cannot be flattened - rows that are not JSON objects and keys holding
+	// objects in some rows and other values in others. Otherwise such rows are skipped and such keys stay JSON.
+	strict bool
+}
+
+// mapKind returns the kind of a decoded JSON value, empty for null.
+func mapKind(v interface{}) string {
+	switch v.(type) {
+	case float64:
+		return mapKindNumber
+	case string:
+		return mapKindString
+	case bool:
+		return mapKindBool
+	case nil:
+		return ""
+	default:
+		return mapKindJSON
+	}
+}
+
+// mergeMapKinds returns the most general of two kinds. Nulls take the kind of the other value, JSON holds
+// every value and scalars of different kinds can only all be strings.
+func mergeMapKinds(a, b string) string {
+	switch {
+	case a == "":
+		return b
+	case b == "" || a == b:
+		return a
+	case a == mapKindJSON || b == mapKindJSON:
+		return mapKindJSON
+	default:
+		return mapKindString
+	}
+}
+
+// mapPath is a key path of a map field and what its values look like across the rows.
+type mapPath struct {
+	// kind is the merged kind of the values that are not expanded objects
+	kind string
+	// objects is whether the path held objects that were expanded into children
+	objects  bool
+	children map[string]*mapPath
+}
+
+// add merges a value of the path into it. Keys of nested objects only become children when discover is set,
+// so the keys can come from a sample of the rows while the kinds come from all of them.
+func (p *mapPath) add(v interface{}, depth int, discover bool, opts flattenOptions) {
+	if obj, ok := v.(map[string]interface{}); ok && (opts.maxDepth == 0 || depth < opts.maxDepth) {
+		p.objects = true
+		for k, child := range obj {
+			c, ok := p.children[k]
+			if !ok {
+				if !discover {
+					continue
+				}
+				if p.children == nil {
+					p.children = map[string]*mapPath{}
+				}
+				c = &mapPath{}
+				p.children[k] = c
+			}
+			c.add(child, depth+1, discover, opts)
+		}
+		return
+	}
+	p.kind = mergeMapKinds(p.kind, mapKind(v))
+}
+
+// MapField collects the values one key path of a map field has in every row.
+type MapField struct {
+	dfField *data.Field
+	t       string
+	path    []string
+}
+
+func newMapField(name string, labels data.Labels, t string, path []string, rows int) *MapField {
+	var fieldType data.FieldType
+	switch t {
+	case mapKindNumber:
+		fieldType = data.FieldTypeNullableFloat64
+	case mapKindBool:
+		fieldType = data.FieldTypeNullableBool
+	case mapKindJSON:
+		fieldType = data.FieldTypeNullableJSON
+	default:
+		// keys that are null in every row are strings
+		t, fieldType = mapKindString, data.FieldTypeNullableString
+	}
+	field := data.NewFieldFromFieldType(fieldType, rows)
+	field.Name, field.Labels = name, labels
+	return &MapField{dfField: field, t: t, path: path}
+}
+
+// set stores the value the path has in a row, as a string when the path has scalars of several kinds.
+func (mf *MapField) set(row int, rowValue map[string]interface{}) error {
+	var value interface{} = rowValue
+	for _, k := range mf.path {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = obj[k]
+	}
+	if value == nil {
+		return nil
+	}
+	switch v := value.(type) {
+	case float64:
+		if mf.t == mapKindNumber {
+			mf.dfField.Set(row, &v)
+			return nil
+		}
+		if mf.t == mapKindString {
+			s := strconv.FormatFloat(v, 'f', -1, 64)
+			mf.dfField.Set(row, &s)
+			return nil
+		}
+	case bool:
+		if mf.t == mapKindBool {
+			mf.dfField.Set(row, &v)
+			return nil
+		}
+		if mf.t == mapKindString {
+			s := strconv.FormatBool(v)
+			mf.dfField.Set(row, &s)
+			return nil
+		}
+	case string:
+		if mf.t == mapKindString {
+			mf.dfField.Set(row, &v)
+			return nil
+		}
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	raw := json.RawMessage(b)
+	mf.dfField.Set(row, &raw)
+	return nil
+}
+
+// flattenDFKvField turns a JSON map field into one field per key path, named col['a']['b'] for nested
+// objects. Arrays stay JSON. The keys are those of the sampled rows, and every field has a value for every
+// row: null where the row lacks the path.
+func flattenDFKvField(field *data.Field, opts flattenOptions) ([]*data.Field, error) {
+	rowValues := make([]map[string]interface{}, field.Len())
+	for i := 0; i < field.Len(); i++ {
+		v, ok := field.ConcreteAt(i)
+		if !ok {
+			continue
+		}
+		raw, ok := v.(json.RawMessage)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("field %s is not JSON", field.Name))
+		}
+		if err := json.Unmarshal(raw, &rowValues[i]); err != nil && opts.strict {
+			return nil, errors.Wrapf(err, "field %s has a value that is not a JSON object", field.Name)
+		}
+	}
+
+	sampleRows := opts.sampleRows
+	if sampleRows <= 0 || sampleRows > len(rowValues) {
+		sampleRows = len(rowValues)
+	}
+	root := &mapPath{}
+	for i, values := range rowValues {
+		if values != nil {
+			root.add(values, 0, i < sampleRows, opts)
+		}
+	}
+
+	mapFields, err := pathFields(field, root, nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	newFields := make([]*data.Field, 0, len(mapFields))
+	for _, mapField := range mapFields {
+		for row, values := range rowValues {
+			if err := mapField.set(row, values); err != nil {
+				return nil, err
+			}
+		}
+		newFields = append(newFields, mapField.dfField)
+	}
+	return newFields, nil
+}
+
+// pathFields returns the fields for the leaves below a path, ordered by key.
+func pathFields(field *data.Field, p *mapPath, keyPath []string, opts flattenOptions) ([]*MapField, error) {
+	keys := make([]string, 0, len(p.children))
+	for k := range p.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var fields []*MapField
+	for _, k := range keys {
+		child := p.children[k]
+		childPath := append(append([]string{}, keyPath...), k)
+		if keyPathMatches(opts.exclude, childPath) {
+			continue
+		}
+		included := len(opts.include) == 0 || keyPathMatches(opts.include, childPath)
+		if !child.objects {
+			if !included {
+				continue
+			}
+			fields = append(fields, newMapField(mapPathName(field.Name, childPath), field.Labels, child.kind, childPath, field.Len()))
+			continue
+		}
+		if child.kind != "" {
+			if !included {
+				continue
+			}
+			if opts.strict {
+				return nil, errors.New(fmt.Sprintf("%s holds objects and other values", mapPathName(field.Name, childPath)))
+			}
+			fields = append(fields, newMapField(mapPathName(field.Name, childPath), field.Labels, mapKindJSON, childPath, field.Len()))
+			continue
+		}
+		if !included && !keyPathPrefixOfPattern(opts.include, childPath) {
+			continue
+		}
+		children, err := pathFields(field, child, childPath, opts)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, children...)
+	}
+	return fields, nil
+}
+
+// mapPathName names the field of a key path, e.g. attrs['http']['method'].
+func mapPathName(name string, path []string) string {
+	for _, k := range path {
+		name += fmt.Sprintf("['%s']", k)
+	}
+	return name
+}
+
+// keyPathMatches returns whether a pattern matches the key path, e.g. http.method, or one of its parents.
+func keyPathMatches(patterns []string, keyPath []string) bool {
+	for i := 1; i <= len(keyPath); i++ {
+		p := strings.Join(keyPath[:i], ".")
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// keyPathPrefixOfPattern returns whether a pattern may match paths below the key path, e.g. http.method
+// below http, so the objects of the path have to be walked.
+func keyPathPrefixOfPattern(patterns []string, keyPath []string) bool {
+	for _, pattern := range patterns {
+		parts := strings.Split(pattern, ".")
+		if len(parts) <= len(keyPath) {
+			continue
+		}
+		matches := true
+		for i, k := range keyPath {
+			if ok, _ := path.Match(parts[i], k); !ok {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+// flattenFrame adds the flattened fields of the JSON fields of the frame after each of them.
+func flattenFrame(frame *data.Frame, opts flattenOptions) (*data.Frame, error) {
+	var newFields []*data.Field
+	added, truncated := 0, false
+	for _, field := range frame.Fields {
+		newFields = append(newFields, field)
+		if (field.Type() != data.FieldTypeNullableJSON && field.Type() != data.FieldTypeJSON) || field.Len() == 0 {
+			continue
+		}
+		if len(opts.columns) > 0 && !containsString(opts.columns, field.Name) {
+			continue
+		}
+		flattenedFields, err := flattenDFKvField(field, opts)
+		if err != nil {
+			return nil, err
+		}
+		if opts.maxFields > 0 && added+len(flattenedFields) > opts.maxFields {
+			flattenedFields, truncated = flattenedFields[:opts.maxFields-added], true
+		}
+		added += len(flattenedFields)
+		newFields = append(newFields, flattenedFields...)
+	}
+	newFrame := data.NewFrame(frame.Name, newFields...)
+	newFrame.SetMeta(frame.Meta)
+	if truncated {
+		newFrame.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("only the first %d flattened fields are shown", opts.maxFields),
+		})
+	}
+	return newFrame, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func flattenedNames(fields []*data.Field) []string {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.Name
+	}
+	return names
+}
+
+func TestFlattenUsesKeysOfAllRows(t *testing.T) {
+	field := jsonField("attrs", "", `{"a":"x"}`, `{"b":1}`, `{"a":"y","c":true}`)
+	fields, err := flattenDFKvField(field, flattenOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"attrs['a']", "attrs['b']", "attrs['c']"}, flattenedNames(fields))
+	for _, f := range fields {
+		assert.Equal(t, 4, f.Len())
+		assert.Nil(t, f.At(0))
+	}
+	assert.Equal(t, "y", *fields[0].At(3).(*string))
+	assert.Nil(t, fields[0].At(2))
+	assert.Equal(t, 1.0, *fields[1].At(2).(*float64))
+	assert.Equal(t, true, *fields[2].At(3).(*bool))
+}
+
+func TestFlattenSampleRows(t *testing.T) {
+	field := jsonField("attrs", `{"a":"x"}`, `{"a":"y","b":1}`)
+	fields, err := flattenDFKvField(field, flattenOptions{sampleRows: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"attrs['a']"}, flattenedNames(fields))
+	assert.Equal(t, "y", *fields[0].At(1).(*string))
+}
+
+func TestFlattenConflictingTypes(t *testing.T) {
+	field := jsonField("attrs", `{"code":200,"ok":null}`, `{"code":"timeout"}`, `{"code":false}`)
+	fields, err := flattenDFKvField(field, flattenOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, data.FieldTypeNullableString, fields[0].Type())
+	assert.Equal(t, "200", *fields[0].At(0).(*string))
+	assert.Equal(t, "timeout", *fields[0].At(1).(*string))
+	assert.Equal(t, "false", *fields[0].At(2).(*string))
+	// keys that are always null are strings
+	assert.Equal(t, data.FieldTypeNullableString, fields[1].Type())
+	assert.Nil(t, fields[1].At(0))
+}
+
+func TestFlattenNestedPaths(t *testing.T) {
+	field := jsonField("attrs",
+		`{"http":{"method":"GET","status":200},"tags":["a","b"],"resource":{"service":{"name":"api"}}}`,
+		`{"http":{"method":"POST"}}`,
+	)
+	fields, err := flattenDFKvField(field, flattenOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"attrs['http']['method']",
+		"attrs['http']['status']",
+		"attrs['resource']['service']['name']",
+		"attrs['tags']",
+	}, flattenedNames(fields))
+	assert.Equal(t, "POST", *fields[0].At(1).(*string))
+	assert.Nil(t, fields[1].At(1))
+	assert.Equal(t, "api", *fields[2].At(0).(*string))
+	assert.Equal(t, data.FieldTypeNullableJSON, fields[3].Type())
+	assert.JSONEq(t, `["a","b"]`, string(*fields[3].At(0).(*json.RawMessage)))
+}
+
+func TestFlattenMaxDepth(t *testing.T) {
+	field := jsonField("attrs", `{"resource":{"service":{"name":"api"}}}`)
+	fields, err := flattenDFKvField(field, flattenOptions{maxDepth: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"attrs['resource']['service']"}, flattenedNames(fields))
+	assert.JSONEq(t, `{"name":"api"}`, string(*fields[0].At(0).(*json.RawMessage)))
+}
+
+func TestFlattenUnsupportedValues(t *testing.T) {
+	field := jsonField("attrs", `{"user":{"id":1}}`, `{"user":"anonymous"}`, `[1,2]`)
+
+	fields, err := flattenDFKvField(field, flattenOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"attrs['user']"}, flattenedNames(fields))
+	assert.JSONEq(t, `{"id":1}`, string(*fields[0].At(0).(*json.RawMessage)))
+	assert.JSONEq(t, `"anonymous"`, string(*fields[0].At(1).(*json.RawMessage)))
+	assert.Nil(t, fields[0].At(2))
+
+	_, err = flattenDFKvField(field, flattenOptions{strict: true})
+	assert.NotNil(t, err)
+}
+
+func TestFlattenIncludeExclude(t *testing.T) {
+	field := jsonField("attrs", `{"http":{"method":"GET","status":200,"url":"/"},"user":"bob","trace_id":"abc"}`)
+	fields, err := flattenDFKvField(field, flattenOptions{include: []string{"http.*", "user"}, exclude: []string{"http.url"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"attrs['http']['method']", "attrs['http']['status']", "attrs['user']"}, flattenedNames(fields))
+
+	fields, err = flattenDFKvField(field, flattenOptions{include: []string{"http"}, exclude: []string{"*.status"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"attrs['http']['method']", "attrs['http']['url']"}, flattenedNames(fields))
+}
+
+func TestFlattenFrameColumnsAndMaxFields(t *testing.T) {
+	frame := data.NewFrame("A",
+		jsonField("attrs", `{"a":1,"b":2,"c":3}`),
+		jsonField("resource", `{"host":"h1"}`),
+	)
+	got, err := flattenFrame(frame, flattenOptions{columns: []string{"attrs"}, maxFields: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"attrs", "attrs['a']", "attrs['b']", "resource"}, flattenedNames(got.Fields))
+	assert.Len(t, got.Meta.Notices, 1)
+}
+
+func TestMutateResponseFlattensPerQuery(t *testing.T) {
+	ctx := withQueryStates(context.Background(), []backend.DataQuery{
+		{RefID: "A", JSON: []byte(`{"format":1,"flatten":{"include":["level"]}}`)},
+		{RefID: "B", JSON: []byte(`{"flatten":{"include":["["]}}`)},
+	})
+	d := &Databend{}
+
+	res, err := d.MutateResponse(ctx, data.Frames{data.NewFrame("A", jsonField("attrs", `{"level":"info","msg":"x"}`))})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"attrs", "attrs['level']"}, flattenedNames(res[0].Fields))
+
+	_, err = d.MutateResponse(ctx, data.Frames{data.NewFrame("B", jsonField("attrs", `{"level":"info"}`))})
+	assert.NotNil(t, err)
+
+	// without flatten options only logs frames of datasources flattening logs are flattened
+	res, err = d.MutateResponse(ctx, data.Frames{data.NewFrame("C", jsonField("attrs", `{"level":"info"}`))})
+	assert.Nil(t, err)
+	assert.Len(t, res[0].Fields, 1)
+}
@@ -0,0 +1,520 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cadl/grafana-databend-datasource/pkg/converters"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// ArrayModeJSON keeps arrays as JSON fields
+	ArrayModeJSON = "json"
+	// ArrayModeRows turns every array element into a row, like ARRAY JOIN does
+	ArrayModeRows = "rows"
+	// ArrayModeVector turns numeric arrays into one field per index, col[1], col[2] and so on, as many as the
+	// longest array of the column has elements. Data frames have no array fields panels can plot, a field per
+	// index can be. Rows whose array is shorter are null in the fields past its end.
+	ArrayModeVector = "vector"
+	// TupleModeJSON keeps tuples as JSON fields
+	TupleModeJSON = "json"
+	// TupleModeFields turns tuples into one typed field per element, col.a or col.1 for unnamed tuples
+	TupleModeFields = "fields"
+	// BinaryModeHex shows binaries hex encoded, as Databend returns them
+	BinaryModeHex = "hex"
+	// BinaryModeBase64 shows binaries base64 encoded
+	BinaryModeBase64 = "base64"
+	// BitmapModeCardinality shows the number of members of a bitmap
+	BitmapModeCardinality = "cardinality"
+	// BitmapModeMembers shows the members of a bitmap as a JSON array
+	BitmapModeMembers = "members"
+	// DateModeTime shows dates as times at midnight UTC
+	DateModeTime = "time"
+	// DateModeString shows dates as YYYY-MM-DD strings
+	DateModeString = "string"
+)
+
+// FormatOptions control how complex column types become fields. By default arrays and tuples stay JSON,
+// binaries are hex encoded, bitmaps show their cardinality and dates are times.
+type FormatOptions struct {
+	ArrayMode  string `json:"arrayMode,omitempty"`
+	TupleMode  string `json:"tupleMode,omitempty"`
+	BinaryMode string `json:"binaryMode,omitempty"`
+	BitmapMode string `json:"bitmapMode,omitempty"`
+	DateMode   string `json:"dateMode,omitempty"`
+}
+
+func (o FormatOptions) validate() error {
+	switch o.ArrayMode {
+	case "", ArrayModeJSON, ArrayModeRows, ArrayModeVector:
+	default:
+		return fmt.Errorf("unknown array mode %q", o.ArrayMode)
+	}
+	switch o.TupleMode {
+	case "", TupleModeJSON, TupleModeFields:
+	default:
+		return fmt.Errorf("unknown tuple mode %q", o.TupleMode)
+	}
+	switch o.BinaryMode {
+	case "", BinaryModeHex, BinaryModeBase64:
+	default:
+		return fmt.Errorf("unknown binary mode %q", o.BinaryMode)
+	}
+	switch o.BitmapMode {
+	case "", BitmapModeCardinality, BitmapModeMembers:
+	default:
+		return fmt.Errorf("unknown bitmap mode %q", o.BitmapMode)
+	}
+	switch o.DateMode {
+	case "", DateModeTime, DateModeString:
+	default:
+		return fmt.Errorf("unknown date mode %q", o.DateMode)
+	}
+	return nil
+}
+
+// applyFormatOptions formats the fields of the frame as the options ask. columnType returns the Databend
+// type of a field, fields without a known type are left as they are.
+func applyFormatOptions(frame *data.Frame, opts FormatOptions, columnType func(name string) (converters.ColumnType, bool)) (*data.Frame, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	changed := false
+	fields := make([]*data.Field, 0, len(frame.Fields))
+	types := make([]*converters.ColumnType, 0, len(frame.Fields))
+	for _, field := range frame.Fields {
+		t, ok := columnType(field.Name)
+		if !ok {
+			fields, types = append(fields, field), append(types, nil)
+			continue
+		}
+		switch t.NotNull().Name {
+		case "Binary":
+			if opts.BinaryMode == BinaryModeBase64 {
+				b, err := base64Field(field)
+				if err != nil {
+					return nil, err
+				}
+				field, changed = b, true
+			}
+		case "Bitmap":
+			if opts.BitmapMode != BitmapModeMembers {
+				c, err := cardinalityField(field)
+				if err != nil {
+					return nil, err
+				}
+				field, changed = c, true
+			}
+		case "Date", "Date32":
+			if opts.DateMode == DateModeString {
+				field, changed = dateStringField(field), true
+			}
+		case "Interval":
+			// the converter returns seconds
+			field.SetConfig(&data.FieldConfig{Unit: "s"})
+		case "Tuple":
+			if opts.TupleMode != TupleModeFields || field.Type() != data.FieldTypeNullableJSON {
+				break
+			}
+			elements, err := tupleFields(field, t)
+			if err != nil {
+				return nil, err
+			}
+			for i := range elements {
+				fields, types = append(fields, elements[i]), append(types, &t.Args[i])
+			}
+			changed = true
+			continue
+		}
+		fields, types = append(fields, field), append(types, &t)
+	}
+
+	var err error
+	switch opts.ArrayMode {
+	case ArrayModeRows:
+		fields, err = arrayJoinFields(fields, types)
+		changed = true
+	case ArrayModeVector:
+		fields, err = arrayVectorFields(fields, types)
+		changed = true
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		return frame, nil
+	}
+	newFrame := data.NewFrame(frame.Name, fields...)
+	newFrame.SetMeta(frame.Meta)
+	return newFrame, nil
+}
+
+// base64Field re-encodes a field of hex encoded binaries as base64. Fields a type override gave another type
+// than string are left as they are.
+func base64Field(field *data.Field) (*data.Field, error) {
+	if field.Type().NonNullableType() != data.FieldTypeString {
+		return field, nil
+	}
+	encoded := data.NewFieldFromFieldType(field.Type(), field.Len())
+	encoded.Name, encoded.Labels, encoded.Config = field.Name, field.Labels, field.Config
+	for row := 0; row < field.Len(); row++ {
+		v, ok := field.ConcreteAt(row)
+		if !ok {
+			continue
+		}
+		b, err := hex.DecodeString(v.(string))
+		if err != nil {
+			return nil, fmt.Errorf("invalid binary in field %s: %w", field.Name, err)
+		}
+		s := base64.StdEncoding.EncodeToString(b)
+		if field.Nullable() {
+			encoded.Set(row, &s)
+		} else {
+			encoded.Set(row, s)
+		}
+	}
+	return encoded, nil
+}
+
+// dateStringField replaces the dates of a field with their YYYY-MM-DD text.
+func dateStringField(field *data.Field) *data.Field {
+	dates := data.NewFieldFromFieldType(data.FieldTypeNullableString, field.Len())
+	dates.Name, dates.Labels, dates.Config = field.Name, field.Labels, field.Config
+	for row := 0; row < field.Len(); row++ {
+		v, ok := field.ConcreteAt(row)
+		if !ok {
+			continue
+		}
+		t, ok := v.(time.Time)
+		if !ok {
+			return field
+		}
+		s := t.Format("2006-01-02")
+		dates.Set(row, &s)
+	}
+	return dates
+}
+
+// recordTimezone notes the timezone the timestamps of the frame were read in on their field config. The
+// converters return them in UTC, so the original zone would be lost otherwise.
+func recordTimezone(frame *data.Frame, timezone string, columnType func(name string) (converters.ColumnType, bool)) {
+	for _, field := range frame.Fields {
+		t, ok := columnType(field.Name)
+		if !ok {
+			continue
+		}
+		switch t.NotNull().Name {
+		case "DateTime", "DateTime64", "Timestamp":
+			if field.Config == nil {
+				field.Config = &data.FieldConfig{}
+			}
+			if field.Config.Custom == nil {
+				field.Config.Custom = map[string]interface{}{}
+			}
+			field.Config.Custom["timezone"] = timezone
+		}
+	}
+}
+
+// cardinalityField replaces the member arrays of a bitmap field with their size.
+func cardinalityField(field *data.Field) (*data.Field, error) {
+	if field.Type() != data.FieldTypeNullableJSON {
+		return field, nil
+	}
+	cardinality := data.NewFieldFromFieldType(data.FieldTypeNullableUint64, field.Len())
+	cardinality.Name, cardinality.Labels, cardinality.Config = field.Name, field.Labels, field.Config
+	for row := 0; row < field.Len(); row++ {
+		raw := field.At(row).(*json.RawMessage)
+		if raw == nil {
+			continue
+		}
+		var members []json.RawMessage
+		if err := json.Unmarshal(*raw, &members); err != nil {
+			return nil, fmt.Errorf("invalid bitmap in field %s: %w", field.Name, err)
+		}
+		n := uint64(len(members))
+		cardinality.Set(row, &n)
+	}
+	return cardinality, nil
+}
+
+// tupleFields splits a tuple field into one field per element. Scalar elements get their own type, nested
+// arrays and tuples stay JSON.
+func tupleFields(field *data.Field, t converters.ColumnType) ([]*data.Field, error) {
+	elements := make([]*data.Field, len(t.Args))
+	for i, arg := range t.Args {
+		name := arg.Field
+		if name == "" {
+			// unnamed elements are addressed from 1, as in Databend
+			name = strconv.Itoa(i + 1)
+		}
+		fieldType, ok := elementFieldType(arg)
+		if !ok {
+			fieldType = data.FieldTypeNullableJSON
+		}
+		elements[i] = data.NewFieldFromFieldType(fieldType, field.Len())
+		elements[i].Name = fmt.Sprintf("%s.%s", field.Name, name)
+		elements[i].Labels = field.Labels
+	}
+	for row := 0; row < field.Len(); row++ {
+		raw := field.At(row).(*json.RawMessage)
+		if raw == nil {
+			continue
+		}
+		// databend-go scans tuples into structs with the fields Field0, Field1, ...
+		var tuple map[string]json.RawMessage
+		if err := json.Unmarshal(*raw, &tuple); err != nil {
+			return nil, fmt.Errorf("invalid tuple in field %s: %w", field.Name, err)
+		}
+		for i, element := range elements {
+			v, ok := tuple[fmt.Sprintf("Field%d", i)]
+			if !ok {
+				continue
+			}
+			value, err := elementValue(v, element.Type())
+			if err != nil {
+				return nil, fmt.Errorf("invalid tuple in field %s: %w", field.Name, err)
+			}
+			if value != nil {
+				element.Set(row, value)
+			}
+		}
+	}
+	return elements, nil
+}
+
+// arrayElementType returns the element type of a scalar array field, false for any other field.
+func arrayElementType(field *data.Field, t *converters.ColumnType) (data.FieldType, bool) {
+	if t == nil || t.Name != "Array" || len(t.Args) != 1 || field.Type() != data.FieldTypeNullableJSON {
+		return data.FieldTypeUnknown, false
+	}
+	return elementFieldType(t.Args[0])
+}
+
+func arrayValues(field *data.Field, row int) ([]json.RawMessage, error) {
+	raw := field.At(row).(*json.RawMessage)
+	if raw == nil {
+		return nil, nil
+	}
+	var values []json.RawMessage
+	if err := json.Unmarshal(*raw, &values); err != nil {
+		return nil, fmt.Errorf("invalid array in field %s: %w", field.Name, err)
+	}
+	return values, nil
+}
+
+// arrayJoinFields returns one row per array element, repeating the other fields. Like ARRAY JOIN, the arrays
+// of a row are zipped and must have the same size, rows with empty arrays are dropped.
+func arrayJoinFields(fields []*data.Field, types []*converters.ColumnType) ([]*data.Field, error) {
+	elementTypes := make([]data.FieldType, len(fields))
+	values := make([][][]json.RawMessage, len(fields))
+	var arrays []int
+	for i, field := range fields {
+		if fieldType, ok := arrayElementType(field, types[i]); ok {
+			elementTypes[i] = fieldType
+			arrays = append(arrays, i)
+		}
+	}
+	if len(arrays) == 0 {
+		return fields, nil
+	}
+
+	rowLen := fields[0].Len()
+	sizes := make([]int, rowLen)
+	total := 0
+	for _, i := range arrays {
+		values[i] = make([][]json.RawMessage, rowLen)
+		for row := 0; row < rowLen; row++ {
+			v, err := arrayValues(fields[i], row)
+			if err != nil {
+				return nil, err
+			}
+			if i != arrays[0] && len(v) != sizes[row] {
+				return nil, fmt.Errorf("sizes of ARRAY JOIN-ed arrays do not match in row %d: %s has %d elements, %s has %d",
+					row+1, fields[arrays[0]].Name, sizes[row], fields[i].Name, len(v))
+			}
+			values[i][row], sizes[row] = v, len(v)
+		}
+	}
+	for _, size := range sizes {
+		total += size
+	}
+
+	joined := make([]*data.Field, len(fields))
+	for i, field := range fields {
+		fieldType := field.Type()
+		if values[i] != nil {
+			fieldType = elementTypes[i]
+		}
+		joined[i] = data.NewFieldFromFieldType(fieldType, total)
+		joined[i].Name, joined[i].Labels, joined[i].Config = field.Name, field.Labels, field.Config
+	}
+	out := 0
+	for row := 0; row < rowLen; row++ {
+		for e := 0; e < sizes[row]; e++ {
+			for i, field := range fields {
+				if values[i] == nil {
+					joined[i].Set(out, field.At(row))
+					continue
+				}
+				value, err := elementValue(values[i][row][e], elementTypes[i])
+				if err != nil {
+					return nil, fmt.Errorf("invalid array in field %s: %w", field.Name, err)
+				}
+				if value != nil {
+					joined[i].Set(out, value)
+				}
+			}
+			out++
+		}
+	}
+	return joined, nil
+}
+
+// arrayVectorFields replaces every numeric array field with one nullable field per index, rather than a single
+// field of vectors, see ArrayModeVector.
+func arrayVectorFields(fields []*data.Field, types []*converters.ColumnType) ([]*data.Field, error) {
+	var vectors []*data.Field
+	for i, field := range fields {
+		fieldType, ok := arrayElementType(field, types[i])
+		if !ok || !isNumericFieldType(fieldType) {
+			vectors = append(vectors, field)
+			continue
+		}
+		values := make([][]json.RawMessage, field.Len())
+		width := 0
+		for row := range values {
+			v, err := arrayValues(field, row)
+			if err != nil {
+				return nil, err
+			}
+			values[row] = v
+			if len(v) > width {
+				width = len(v)
+			}
+		}
+		if width == 0 {
+			vectors = append(vectors, field)
+			continue
+		}
+		for index := 0; index < width; index++ {
+			// Databend arrays are indexed from 1
+			vector := data.NewFieldFromFieldType(fieldType, field.Len())
+			vector.Name, vector.Labels = fmt.Sprintf("%s[%d]", field.Name, index+1), field.Labels
+			for row, v := range values {
+				if index >= len(v) {
+					continue
+				}
+				value, err := elementValue(v[index], fieldType)
+				if err != nil {
+					return nil, fmt.Errorf("invalid array in field %s: %w", field.Name, err)
+				}
+				if value != nil {
+					vector.Set(row, value)
+				}
+			}
+			vectors = append(vectors, vector)
+		}
+	}
+	return vectors, nil
+}
+
+// elementFieldType returns the field type for the scalar elements of an Array or Tuple.
+func elementFieldType(t converters.ColumnType) (data.FieldType, bool) {
+	switch t.NotNull().Name {
+	case "Boolean":
+		return data.FieldTypeNullableBool, true
+	case "Int8", "Int16", "Int32", "Int64", "UInt8", "UInt16", "UInt32":
+		return data.FieldTypeNullableInt64, true
+	case "UInt64":
+		return data.FieldTypeNullableUint64, true
+	case "Float32", "Float64", "Decimal":
+		return data.FieldTypeNullableFloat64, true
+	case "Date", "Date32", "DateTime", "DateTime64", "Timestamp":
+		return data.FieldTypeNullableTime, true
+	case "String", "FixedString", "UUID", "Enum8", "Enum16", "IPv4", "IPv6":
+		return data.FieldTypeNullableString, true
+	}
+	return data.FieldTypeUnknown, false
+}
+
+func isNumericFieldType(t data.FieldType) bool {
+	return t == data.FieldTypeNullableInt64 || t == data.FieldTypeNullableUint64 || t == data.FieldTypeNullableFloat64
+}
+
+// elementValue converts a JSON encoded element to a pointer of the field type, nil for null. Nullable
+// elements are scanned as strings by databend-go, so numbers, booleans and times are read from strings too.
+func elementValue(raw json.RawMessage, fieldType data.FieldType) (interface{}, error) {
+	if raw == nil || bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+	if fieldType == data.FieldTypeNullableJSON {
+		v := append(json.RawMessage(nil), raw...)
+		return &v, nil
+	}
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	s := fmt.Sprint(v)
+	if str, ok := v.(string); ok {
+		s = str
+		if str == "NULL" || str == `\N` {
+			return nil, nil
+		}
+	}
+
+	switch fieldType {
+	case data.FieldTypeNullableBool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, err
+		}
+		return &b, nil
+	case data.FieldTypeNullableInt64:
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &i, nil
+	case data.FieldTypeNullableUint64:
+		u, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &u, nil
+	case data.FieldTypeNullableFloat64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &f, nil
+	case data.FieldTypeNullableTime:
+		t, err := parseElementTime(s)
+		if err != nil {
+			return nil, err
+		}
+		return &t, nil
+	default:
+		return &s, nil
+	}
+}
+
+// parseElementTime reads the RFC 3339 times of scanned elements and the raw Databend format of nullable ones.
+func parseElementTime(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
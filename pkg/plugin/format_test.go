@@ -0,0 +1,193 @@
+package plugin
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/cadl/grafana-databend-datasource/pkg/converters"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func jsonField(name string, values ...string) *data.Field {
+	raw := make([]*json.RawMessage, len(values))
+	for i, v := range values {
+		if v != "" {
+			m := json.RawMessage(v)
+			raw[i] = &m
+		}
+	}
+	return data.NewField(name, nil, raw)
+}
+
+func columnTypes(t *testing.T, types map[string]string) func(string) (converters.ColumnType, bool) {
+	parsed := map[string]converters.ColumnType{}
+	for name, typ := range types {
+		ct, err := converters.ParseColumnType(typ)
+		assert.Nil(t, err)
+		parsed[name] = ct
+	}
+	return func(name string) (converters.ColumnType, bool) {
+		ct, ok := parsed[name]
+		return ct, ok
+	}
+}
+
+func TestFormatOptionsDefaultKeepsJSON(t *testing.T) {
+	frame := data.NewFrame("A", jsonField("values", `[1,2]`))
+	got, err := applyFormatOptions(frame, FormatOptions{}, columnTypes(t, map[string]string{"values": "Array(Float64)"}))
+	assert.Nil(t, err)
+	assert.Same(t, frame, got)
+}
+
+func TestFormatOptionsInvalidMode(t *testing.T) {
+	frame := data.NewFrame("A", jsonField("values", `[1,2]`))
+	_, err := applyFormatOptions(frame, FormatOptions{ArrayMode: "columns"}, columnTypes(t, nil))
+	assert.NotNil(t, err)
+}
+
+func TestArrayModeRows(t *testing.T) {
+	frame := data.NewFrame("A",
+		data.NewField("id", nil, []int64{1, 2, 3}),
+		jsonField("values", `[1.5,2.5]`, `[]`, `[3.5]`),
+		jsonField("names", `["a","b"]`, `[]`, `["c"]`),
+	)
+	types := columnTypes(t, map[string]string{"id": "Int64", "values": "Array(Float64)", "names": "Array(String)"})
+	got, err := applyFormatOptions(frame, FormatOptions{ArrayMode: ArrayModeRows}, types)
+	assert.Nil(t, err)
+
+	rows, _ := got.RowLen()
+	assert.Equal(t, 3, rows)
+	assert.Equal(t, data.FieldTypeInt64, got.Fields[0].Type())
+	assert.Equal(t, []interface{}{int64(1), int64(1), int64(3)}, []interface{}{got.Fields[0].At(0), got.Fields[0].At(1), got.Fields[0].At(2)})
+	assert.Equal(t, data.FieldTypeNullableFloat64, got.Fields[1].Type())
+	assert.Equal(t, 2.5, *got.Fields[1].At(1).(*float64))
+	assert.Equal(t, data.FieldTypeNullableString, got.Fields[2].Type())
+	assert.Equal(t, "c", *got.Fields[2].At(2).(*string))
+}
+
+func TestArrayModeRowsSizeMismatch(t *testing.T) {
+	frame := data.NewFrame("A", jsonField("a", `[1,2]`), jsonField("b", `[1]`))
+	types := columnTypes(t, map[string]string{"a": "Array(Int32)", "b": "Array(Int32)"})
+	_, err := applyFormatOptions(frame, FormatOptions{ArrayMode: ArrayModeRows}, types)
+	assert.NotNil(t, err)
+}
+
+func TestArrayModeVector(t *testing.T) {
+	frame := data.NewFrame("A",
+		jsonField("embedding", `[0.1,0.2,0.3]`, `["0.4","NULL"]`, ""),
+		jsonField("tags", `["x"]`, `[]`, ""),
+	)
+	types := columnTypes(t, map[string]string{"embedding": "Array(Nullable(Float64))", "tags": "Array(String)"})
+	got, err := applyFormatOptions(frame, FormatOptions{ArrayMode: ArrayModeVector}, types)
+	assert.Nil(t, err)
+
+	assert.Len(t, got.Fields, 4)
+	assert.Equal(t, "embedding[1]", got.Fields[0].Name)
+	assert.Equal(t, "embedding[3]", got.Fields[2].Name)
+	assert.Equal(t, 0.4, *got.Fields[0].At(1).(*float64))
+	assert.Nil(t, got.Fields[1].At(1))
+	assert.Nil(t, got.Fields[2].At(1))
+	assert.Nil(t, got.Fields[0].At(2))
+	// only numeric arrays become vectors
+	assert.Equal(t, "tags", got.Fields[3].Name)
+	assert.Equal(t, data.FieldTypeNullableJSON, got.Fields[3].Type())
+}
+
+func TestTupleModeFields(t *testing.T) {
+	frame := data.NewFrame("A",
+		jsonField("point", `{"Field0":"home","Field1":1.5,"Field2":[1,2]}`, ""),
+		jsonField("pair", `{"Field0":"a","Field1":7}`, `{"Field0":"b","Field1":8}`),
+	)
+	types := columnTypes(t, map[string]string{
+		"point": "Tuple(name String, value Float64, ids Array(Int32))",
+		"pair":  "Tuple(String, Int8)",
+	})
+	got, err := applyFormatOptions(frame, FormatOptions{TupleMode: TupleModeFields}, types)
+	assert.Nil(t, err)
+
+	names := make([]string, len(got.Fields))
+	for i, f := range got.Fields {
+		names[i] = f.Name
+	}
+	assert.Equal(t, []string{"point.name", "point.value", "point.ids", "pair.1", "pair.2"}, names)
+	assert.Equal(t, "home", *got.Fields[0].At(0).(*string))
+	assert.Equal(t, 1.5, *got.Fields[1].At(0).(*float64))
+	assert.Nil(t, got.Fields[1].At(1))
+	assert.JSONEq(t, `[1,2]`, string(*got.Fields[2].At(0).(*json.RawMessage)))
+	assert.Equal(t, int64(8), *got.Fields[4].At(1).(*int64))
+}
+
+func TestTupleFieldsWithArrayMode(t *testing.T) {
+	frame := data.NewFrame("A", jsonField("point", `{"Field0":"home","Field1":[1,2]}`))
+	types := columnTypes(t, map[string]string{"point": "Tuple(name String, ids Array(Int32))"})
+	got, err := applyFormatOptions(frame, FormatOptions{TupleMode: TupleModeFields, ArrayMode: ArrayModeRows}, types)
+	assert.Nil(t, err)
+
+	rows, _ := got.RowLen()
+	assert.Equal(t, 2, rows)
+	assert.Equal(t, "point.ids", got.Fields[1].Name)
+	assert.Equal(t, int64(2), *got.Fields[1].At(1).(*int64))
+	assert.Equal(t, "home", *got.Fields[0].At(1).(*string))
+}
+
+func TestBinaryModeBase64(t *testing.T) {
+	hello := "48656C6C6F"
+	frame := data.NewFrame("A", data.NewField("payload", nil, []*string{&hello, nil}))
+	types := columnTypes(t, map[string]string{"payload": "Nullable(Binary)"})
+
+	got, err := applyFormatOptions(frame, FormatOptions{}, types)
+	assert.Nil(t, err)
+	assert.Equal(t, hello, *got.Fields[0].At(0).(*string))
+
+	got, err = applyFormatOptions(frame, FormatOptions{BinaryMode: BinaryModeBase64}, types)
+	assert.Nil(t, err)
+	assert.Equal(t, "SGVsbG8=", *got.Fields[0].At(0).(*string))
+	assert.Nil(t, got.Fields[0].At(1))
+
+	// a type override made the binaries JSON, they are left as they are
+	frame = data.NewFrame("A", jsonField("payload", `{"a":1}`))
+	got, err = applyFormatOptions(frame, FormatOptions{BinaryMode: BinaryModeBase64}, types)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"a":1}`, string(*got.Fields[0].At(0).(*json.RawMessage)))
+}
+
+func TestBitmapMode(t *testing.T) {
+	frame := data.NewFrame("A", jsonField("users", `[1,3,5]`, `[]`))
+	types := columnTypes(t, map[string]string{"users": "Bitmap"})
+
+	got, err := applyFormatOptions(frame, FormatOptions{}, types)
+	assert.Nil(t, err)
+	assert.Equal(t, data.FieldTypeNullableUint64, got.Fields[0].Type())
+	assert.Equal(t, uint64(3), *got.Fields[0].At(0).(*uint64))
+	assert.Equal(t, uint64(0), *got.Fields[0].At(1).(*uint64))
+
+	got, err = applyFormatOptions(frame, FormatOptions{BitmapMode: BitmapModeMembers}, types)
+	assert.Nil(t, err)
+	assert.Same(t, frame, got)
+}
+
+func TestIntervalUnit(t *testing.T) {
+	frame := data.NewFrame("A", data.NewField("elapsed", nil, []float64{90}))
+	got, err := applyFormatOptions(frame, FormatOptions{}, columnTypes(t, map[string]string{"elapsed": "Interval"}))
+	assert.Nil(t, err)
+	assert.Equal(t, "s", got.Fields[0].Config.Unit)
+}
+
+func TestDateModeString(t *testing.T) {
+	d := time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+	frame := data.NewFrame("A", data.NewField("day", nil, []*time.Time{&d, nil}).SetConfig(&data.FieldConfig{DisplayName: "Day"}))
+	types := columnTypes(t, map[string]string{"day": "Nullable(Date)"})
+
+	got, err := applyFormatOptions(frame, FormatOptions{}, types)
+	assert.Nil(t, err)
+	assert.Same(t, frame, got)
+
+	got, err = applyFormatOptions(frame, FormatOptions{DateMode: DateModeString}, types)
+	assert.Nil(t, err)
+	assert.Equal(t, data.FieldTypeNullableString, got.Fields[0].Type())
+	assert.Equal(t, "2023-09-01", *got.Fields[0].At(0).(*string))
+	assert.Nil(t, got.Fields[0].At(1))
+	assert.Equal(t, "Day", got.Fields[0].Config.DisplayName)
+}
@@ -0,0 +1,195 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"regexp"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/sqlds/v2"
+)
+
+// incrementalCacheTTL is how long the buckets of an incremental query are kept without a refresh reading them.
+// They are kept in the result cache, within its memory budget, queries run over their whole time range when the
+// datasource does not cache results.
+const incrementalCacheTTL = time.Hour
+
+// timeIntervalMacro matches the macros grouping rows in buckets, the queries an incremental query can be.
+var timeIntervalMacro = regexp.MustCompile(`\$__timeInterval(_ms)?\(`)
+
+// incrementalPlan is how an incremental query runs: from start to the end of its time range, the buckets
+// before start being read from the cached result.
+type incrementalPlan struct {
+	key   string
+	query backend.DataQuery
+	// bucket is the size of the buckets in seconds, start where the query runs from
+	bucket int64
+	start  time.Time
+	// cached is the frame of the previous refresh, nil when the whole time range runs
+	cached *data.Frame
+}
+
+// planIncremental tells whether the query runs incrementally and returns its plan. Queries opting in, whose
+// SQL groups rows with $__timeInterval, re-read only the buckets a previous refresh did not, or read while
+// they were still filling up, plus the overlap of the datasource for late data.
+func (ds *Datasource) planIncremental(req *backend.QueryDataRequest, q backend.DataQuery) (*incrementalPlan, bool) {
+	if ds.cache == nil || req.Headers[headerFromAlert] == "true" {
+		return nil, false
+	}
+	var options QueryOptions
+	if err := json.Unmarshal(q.JSON, &options); err != nil || !options.Incremental {
+		return nil, false
+	}
+	query, err := sqlds.GetQuery(q)
+	if err != nil || !timeIntervalMacro.MatchString(query.RawSQL) {
+		return nil, false
+	}
+	if query.Format == sqlds.FormatOptionLogs || query.Format == sqlds.FormatOptionTrace {
+		return nil, false
+	}
+	plan := &incrementalPlan{query: q, bucket: int64(math.Max(q.Interval.Seconds(), 1)), start: q.TimeRange.From}
+	// the buckets of a query are those of its SQL, its options and its bucket size, whatever its time range
+	b, err := json.Marshal([]interface{}{ds.settingsKey, q.JSON, plan.bucket})
+	if err != nil {
+		return nil, false
+	}
+	sum := sha256.Sum256(b)
+	plan.key = "incremental/" + hex.EncodeToString(sum[:])
+
+	res, covered, ok := ds.cache.getRange(plan.key)
+	if !ok || len(res.Frames) != 1 || covered.From.After(q.TimeRange.From) || !covered.To.After(q.TimeRange.From) ||
+		covered.To.After(q.TimeRange.To) {
+		return plan, true
+	}
+	// the last bucket read was still filling up, it runs again with those of the overlap
+	start := bucketStart(covered.To.Add(-ds.incrementalOverlap), plan.bucket)
+	if start.After(q.TimeRange.From) {
+		plan.start, plan.cached = start, res.Frames[0]
+	}
+	return plan, true
+}
+
+// runQuery returns the query as it runs, from the start of the plan.
+func (p *incrementalPlan) runQuery() backend.DataQuery {
+	q := p.query
+	q.TimeRange.From = p.start
+	return q
+}
+
+// merge returns the response of the whole time range, the buckets of the cached frame before start followed
+// by those of the response, and the number of rows read from the cache. It fails when the frames of the
+// response and the cache do not have the same fields, as when a new series shows up, then the whole time
+// range runs again.
+func (p *incrementalPlan) merge(res backend.DataResponse) (backend.DataResponse, int, bool) {
+	if p.cached == nil || res.Error != nil {
+		return res, 0, true
+	}
+	if len(res.Frames) != 1 || !sameFields(p.cached, res.Frames[0]) {
+		return res, 0, false
+	}
+	fresh := res.Frames[0]
+	timeIndex, ok := timeFieldIndex(fresh)
+	if !ok {
+		return res, 0, false
+	}
+	from := bucketStart(p.query.TimeRange.From, p.bucket)
+	merged := fresh.EmptyCopy()
+	reused := 0
+	for i := 0; i < p.cached.Rows(); i++ {
+		t, ok := rowTime(p.cached, timeIndex, i)
+		if ok && !t.Before(from) && t.Before(p.start) {
+			merged.AppendRow(p.cached.RowCopy(i)...)
+			reused++
+		}
+	}
+	for i := 0; i < fresh.Rows(); i++ {
+		merged.AppendRow(fresh.RowCopy(i)...)
+	}
+	return backend.DataResponse{Frames: data.Frames{merged}, Status: res.Status}, reused, true
+}
+
+// mergeIncremental merges the responses of the incremental queries with their cached buckets and keeps them
+// for the next refresh. The queries whose buckets cannot be merged run again over their whole time range.
+func (ds *Datasource) mergeIncremental(ctx context.Context, req *backend.QueryDataRequest, res *backend.QueryDataResponse,
+	plans map[string]*incrementalPlan) error {
+	reused := map[string]int{}
+	var reruns []backend.DataQuery
+	for refID, plan := range plans {
+		queryRes, ok := res.Responses[refID]
+		if !ok {
+			continue
+		}
+		merged, rows, ok := plan.merge(queryRes)
+		if !ok {
+			reruns = append(reruns, plan.query)
+			continue
+		}
+		res.Responses[refID], reused[refID] = merged, rows
+	}
+	if len(reruns) > 0 {
+		r := *req
+		r.Queries = reruns
+		ctx = withQueryStates(ctx, reruns)
+		rerun, err := ds.SQLDatasource.QueryData(ctx, &r)
+		if err != nil {
+			return err
+		}
+		withErrorSources(ctx, rerun)
+		for _, q := range reruns {
+			res.Responses[q.RefID], reused[q.RefID] = rerun.Responses[q.RefID], 0
+		}
+	}
+	for refID, rows := range reused {
+		queryRes := res.Responses[refID]
+		if queryRes.Error != nil {
+			continue
+		}
+		plan := plans[refID]
+		ds.cache.setRange(plan.key, queryRes, plan.query.TimeRange, incrementalCacheTTL)
+		res.Responses[refID] = responseWithStat(queryRes, refID, queryStat("Cached rows", "short", float64(rows)))
+	}
+	return nil
+}
+
+// bucketStart returns the start of the bucket of $__timeInterval holding the time.
+func bucketStart(t time.Time, bucket int64) time.Time {
+	seconds := t.Unix()
+	return time.Unix(seconds-((seconds%bucket)+bucket)%bucket, 0)
+}
+
+// sameFields tells whether the frames have the same fields, with the same types and labels.
+func sameFields(a, b *data.Frame) bool {
+	if len(a.Fields) != len(b.Fields) {
+		return false
+	}
+	for i, f := range a.Fields {
+		g := b.Fields[i]
+		if f.Name != g.Name || f.Type() != g.Type() || f.Labels.String() != g.Labels.String() {
+			return false
+		}
+	}
+	return true
+}
+
+func timeFieldIndex(frame *data.Frame) (int, bool) {
+	for i, f := range frame.Fields {
+		if f.Type() == data.FieldTypeTime || f.Type() == data.FieldTypeNullableTime {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func rowTime(frame *data.Frame, index, row int) (time.Time, bool) {
+	v, ok := frame.Fields[index].ConcreteAt(row)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, ok := v.(time.Time)
+	return t, ok
+}
@@ -0,0 +1,170 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	godatabend "github.com/databendcloud/databend-go"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBucketStart(t *testing.T) {
+	ts := time.Date(2023, 9, 1, 10, 0, 59, 0, time.UTC)
+	assert.Equal(t, time.Date(2023, 9, 1, 10, 0, 0, 0, time.UTC), bucketStart(ts, 60).UTC())
+	assert.Equal(t, time.Date(2023, 9, 1, 10, 0, 58, 0, time.UTC), bucketStart(ts, 7).UTC())
+}
+
+// newIncrementalTestServer answers queries with a row per minute of their time filter, the value of which
+// is the number of queries the server answered, in the column named by series, and records the time ranges
+// of the queries.
+func newIncrementalTestServer(t *testing.T, series func() string) (*httptest.Server, func() [][2]int64) {
+	filter := regexp.MustCompile(`ts >= '(\d+)' AND ts <= '(\d+)'`)
+	var mu sync.Mutex
+	var ranges [][2]int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			SQL string `json:"sql"`
+		}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+		m := filter.FindStringSubmatch(req.SQL)
+		if !assert.NotNil(t, m, req.SQL) {
+			return
+		}
+		from, _ := strconv.ParseInt(m[1], 10, 64)
+		to, _ := strconv.ParseInt(m[2], 10, 64)
+		mu.Lock()
+		ranges = append(ranges, [2]int64{from, to})
+		n := strconv.Itoa(len(ranges))
+		mu.Unlock()
+		resp := godatabend.QueryResponse{Schema: []godatabend.DataField{{Name: "time", Type: "Timestamp"}, {Name: series(), Type: "UInt64"}}}
+		for ts := from / 60 * 60; ts <= to; ts += 60 {
+			resp.Data = append(resp.Data, []string{time.Unix(ts, 0).UTC().Format("2006-01-02 15:04:05.000000"), n})
+		}
+		assert.Nil(t, json.NewEncoder(w).Encode(resp))
+	}))
+	return server, func() [][2]int64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([][2]int64{}, ranges...)
+	}
+}
+
+func TestQueryDataIncremental(t *testing.T) {
+	server, ranges := newIncrementalTestServer(t, func() string { return "n" })
+	defer server.Close()
+	ds := newTestDatasource(t, server, `"timezone":"UTC","enableResultCache":true,"incrementalCacheOverlap":60`)
+
+	from := time.Date(2023, 9, 1, 10, 0, 30, 0, time.UTC)
+	rawSQL := `{"rawSql":"SELECT $__timeInterval(ts) AS time, count(*) AS n FROM t WHERE $__timeFilter(ts) GROUP BY 1 ORDER BY 1","format":1,"incremental":true}`
+	query := func(offset time.Duration, headers map[string]string) backend.DataResponse {
+		return queryIncremental(t, ds, from.Add(offset), headers, rawSQL)
+	}
+	values := func(res backend.DataResponse) []uint64 {
+		frame := res.Frames[0]
+		var values []uint64
+		for i := 0; i < frame.Rows(); i++ {
+			v, _ := frame.Fields[1].ConcreteAt(i)
+			values = append(values, v.(uint64))
+		}
+		return values
+	}
+	cachedRows := func(res backend.DataResponse) float64 {
+		stats := res.Frames[0].Meta.Stats
+		assert.Equal(t, "Cached rows", stats[len(stats)-1].DisplayName)
+		return stats[len(stats)-1].Value
+	}
+
+	res := query(0, nil)
+	assert.Equal(t, 0.0, cachedRows(res))
+	assert.Equal(t, []uint64{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}, values(res))
+
+	// three minutes later the buckets before the last one read, and the minute of overlap, come from the cache
+	res = query(3*time.Minute, nil)
+	assert.Equal(t, 6.0, cachedRows(res))
+	assert.Equal(t, []uint64{1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 2}, values(res))
+	assert.Equal(t, [2]int64{from.Add(9 * time.Minute).Truncate(time.Minute).Unix(), from.Add(13 * time.Minute).Unix()}, ranges()[1])
+
+	// alert rules read the whole time range
+	query(3*time.Minute, map[string]string{headerFromAlert: "true"})
+	assert.Equal(t, from.Add(3*time.Minute).Unix(), ranges()[2][0])
+
+	// the buckets of the query with other options are not those of the query
+	res = queryIncremental(t, ds, from.Add(4*time.Minute), nil,
+		`{"rawSql":"SELECT $__timeInterval(ts) AS time, count(*) AS n FROM t WHERE $__timeFilter(ts) GROUP BY 1 ORDER BY 1","format":1,"incremental":true,"formatOptions":{"dateMode":"string"}}`)
+	assert.Equal(t, 0.0, cachedRows(res))
+	assert.Equal(t, from.Add(4*time.Minute).Unix(), ranges()[3][0])
+}
+
+func TestQueryDataIncrementalWithoutCache(t *testing.T) {
+	server, ranges := newIncrementalTestServer(t, func() string { return "n" })
+	defer server.Close()
+	ds := newTestDatasource(t, server, `"timezone":"UTC"`)
+
+	// the buckets are kept in the result cache, without it queries read their whole time range
+	from := time.Date(2023, 9, 1, 10, 0, 30, 0, time.UTC)
+	rawSQL := `{"rawSql":"SELECT $__timeInterval(ts) AS time, count(*) AS n FROM t WHERE $__timeFilter(ts) GROUP BY 1","format":1,"incremental":true}`
+	queryIncremental(t, ds, from, nil, rawSQL)
+	res := queryIncremental(t, ds, from.Add(time.Minute), nil, rawSQL)
+	assert.Equal(t, from.Add(time.Minute).Unix(), ranges()[1][0])
+	for _, stat := range res.Frames[0].Meta.Stats {
+		assert.NotEqual(t, "Cached rows", stat.DisplayName)
+	}
+}
+
+// queryIncremental runs the query over ten minutes from the time, in buckets of a minute.
+func queryIncremental(t *testing.T, ds *Datasource, from time.Time, headers map[string]string, rawSQL string) backend.DataResponse {
+	res, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: ds.uid}},
+		Headers:       headers,
+		Queries: []backend.DataQuery{{
+			RefID:     "A",
+			Interval:  time.Minute,
+			TimeRange: backend.TimeRange{From: from, To: from.Add(10 * time.Minute)},
+			JSON:      json.RawMessage(rawSQL),
+		}},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, res.Responses["A"].Error)
+	return res.Responses["A"]
+}
+
+func TestQueryDataIncrementalNewSeries(t *testing.T) {
+	var series atomic.Value
+	series.Store("n")
+	server, ranges := newIncrementalTestServer(t, func() string { return series.Load().(string) })
+	defer server.Close()
+	ds := newTestDatasource(t, server, `"timezone":"UTC","enableResultCache":true`)
+
+	from := time.Date(2023, 9, 1, 10, 0, 0, 0, time.UTC)
+	query := func(offset time.Duration) backend.DataResponse {
+		res, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+			PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: ds.uid}},
+			Queries: []backend.DataQuery{{
+				RefID:     "A",
+				Interval:  time.Minute,
+				TimeRange: backend.TimeRange{From: from.Add(offset), To: from.Add(10*time.Minute + offset)},
+				JSON:      json.RawMessage(`{"rawSql":"SELECT $__timeInterval(ts) AS time, count(*) AS n FROM t WHERE $__timeFilter(ts) GROUP BY 1","format":1,"incremental":true}`),
+			}},
+		})
+		assert.Nil(t, err)
+		return res.Responses["A"]
+	}
+	query(0)
+	// the new buckets do not have the fields of the cached ones, the whole time range runs again
+	series.Store("m")
+	res := query(time.Minute)
+	assert.Nil(t, res.Error)
+	assert.Len(t, ranges(), 3)
+	assert.Equal(t, from.Add(time.Minute).Unix(), ranges()[2][0])
+	assert.Equal(t, "m", res.Frames[0].Fields[1].Name)
+	assert.Equal(t, 11, res.Frames[0].Rows())
+}
@@ -0,0 +1,67 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// queryLimiter caps the queries a datasource runs at once on its warehouse. Queries beyond the cap wait in a
+// bounded queue, those finding the queue full fail at once.
+type queryLimiter struct {
+	slots     chan struct{}
+	maxQueued int
+
+	mu     sync.Mutex
+	queued int
+}
+
+// newQueryLimiter returns the limiter of the settings, nil when the queries of the datasource are not capped.
+func newQueryLimiter(settings Settings) *queryLimiter {
+	if settings.MaxConcurrentQueries <= 0 {
+		return nil
+	}
+	return &queryLimiter{slots: make(chan struct{}, settings.MaxConcurrentQueries), maxQueued: int(settings.MaxQueuedQueries)}
+}
+
+// acquire takes a slot for a query, waiting in the queue while all are taken. It returns the number of
+// queries that were waiting when the query came and how long it waited.
+func (l *queryLimiter) acquire(ctx context.Context) (int, time.Duration, error) {
+	if l == nil {
+		return 0, 0, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return 0, 0, nil
+	default:
+	}
+
+	l.mu.Lock()
+	depth := l.queued
+	if depth >= l.maxQueued {
+		l.mu.Unlock()
+		return depth, 0, fmt.Errorf("%w: %d queries are running and %d waiting, try again later", ErrorDatasourceBusy, cap(l.slots), depth)
+	}
+	l.queued++
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.queued--
+		l.mu.Unlock()
+	}()
+
+	start := time.Now()
+	select {
+	case l.slots <- struct{}{}:
+		return depth, time.Since(start), nil
+	case <-ctx.Done():
+		return depth, time.Since(start), ctx.Err()
+	}
+}
+
+func (l *queryLimiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
@@ -0,0 +1,123 @@
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	godatabend "github.com/databendcloud/databend-go"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryLimiter(t *testing.T) {
+	assert.Nil(t, newQueryLimiter(Settings{MaxQueuedQueries: 5}))
+	var unlimited *queryLimiter
+	_, _, err := unlimited.acquire(context.Background())
+	assert.Nil(t, err)
+	unlimited.release()
+
+	l := newQueryLimiter(Settings{MaxConcurrentQueries: 1, MaxQueuedQueries: 1})
+	depth, wait, err := l.acquire(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, depth)
+	assert.Equal(t, time.Duration(0), wait)
+
+	// the second query waits for the slot, the third finds the queue full
+	acquired := make(chan time.Duration)
+	go func() {
+		_, wait, err := l.acquire(context.Background())
+		assert.Nil(t, err)
+		acquired <- wait
+	}()
+	assert.Eventually(t, func() bool {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.queued == 1
+	}, time.Second, time.Millisecond)
+	depth, _, err = l.acquire(context.Background())
+	assert.True(t, errors.Is(err, ErrorDatasourceBusy))
+	assert.Equal(t, 1, depth)
+
+	time.Sleep(10 * time.Millisecond)
+	l.release()
+	assert.GreaterOrEqual(t, <-acquired, 10*time.Millisecond)
+
+	// queries stop waiting when they are cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err = l.acquire(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	l.release()
+	_, _, err = l.acquire(context.Background())
+	assert.Nil(t, err)
+}
+
+func TestConnQueryLimited(t *testing.T) {
+	server := newTestServer(t, godatabend.QueryResponse{
+		Schema: []godatabend.DataField{{Name: "n", Type: "UInt64"}},
+		Data:   [][]string{{"1"}},
+	})
+	defer server.Close()
+	cfg := godatabend.NewConfig()
+	cfg.Host = strings.TrimPrefix(server.URL, "http://")
+	cfg.SSLMode = godatabend.SSL_MODE_DISABLE
+	cfg.User, cfg.Password = "databend", "databend"
+	c, err := newConnector(cfg.FormatDSN())
+	assert.Nil(t, err)
+	c.limiter = newQueryLimiter(Settings{MaxConcurrentQueries: 1})
+	db := sql.OpenDB(c)
+
+	ctx := withQueryStates(context.Background(), []backend.DataQuery{{RefID: "A"}, {RefID: "B"}})
+	rows, err := db.QueryContext(withQueryState(ctx, "A"), "SELECT n FROM t")
+	assert.Nil(t, err)
+	// the slot is held until the rows are closed
+	_, err = db.QueryContext(withQueryState(ctx, "B"), "SELECT n FROM t")
+	assert.ErrorIs(t, err, ErrorDatasourceBusy)
+	assert.Nil(t, rows.Close())
+	rows, err = db.QueryContext(withQueryState(ctx, "B"), "SELECT n FROM t")
+	assert.Nil(t, err)
+	assert.Nil(t, rows.Close())
+
+	frame := data.NewFrame("B")
+	withStateStats(frame, queryStateFor(ctx, "B"))
+	assert.Equal(t, "Queue depth", frame.Meta.Stats[len(frame.Meta.Stats)-2].DisplayName)
+	assert.Equal(t, "Queue wait", frame.Meta.Stats[len(frame.Meta.Stats)-1].DisplayName)
+}
+
+func TestConnectSharesLimiter(t *testing.T) {
+	server := newTestServer(t, godatabend.QueryResponse{})
+	defer server.Close()
+	ds := newTestDatasource(t, server, `"maxConcurrentQueries":1,"maxQueuedQueries":0`)
+	u, err := url.Parse(server.URL)
+	assert.Nil(t, err)
+	config := backend.DataSourceInstanceSettings{
+		DecryptedSecureJSONData: map[string]string{"password": "databend"},
+		JSONData:                []byte(fmt.Sprintf(`{"server":%q,"port":%s,"username":"databend","maxConcurrentQueries":1}`, u.Hostname(), u.Port())),
+	}
+
+	// sqlds connects again after failures, the connections share the slots of the datasource
+	var limiters []*queryLimiter
+	for i := 0; i < 2; i++ {
+		db, err := ds.driver.Connect(config, nil)
+		if !assert.Nil(t, err) {
+			return
+		}
+		c, err := db.Conn(context.Background())
+		assert.Nil(t, err)
+		assert.Nil(t, c.Raw(func(dc interface{}) error {
+			limiters = append(limiters, dc.(*conn).limiter)
+			return nil
+		}))
+		assert.Nil(t, c.Close())
+	}
+	assert.NotNil(t, limiters[0])
+	assert.Same(t, limiters[0], limiters[1])
+	assert.Same(t, ds.driver.limiter, limiters[0])
+}
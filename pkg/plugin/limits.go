@@ -0,0 +1,64 @@
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// ResultLimits cap the rows and the bytes a query returns, there is no cap when 0.
+type ResultLimits struct {
+	MaxRows int64 `json:"maxRows,omitempty"`
+	// MaxBytes caps the size of the values as Databend sends them
+	MaxBytes int64 `json:"maxBytes,omitempty"`
+}
+
+// resultLimits returns the limits of a query, those it sets itself or else the datasource ones. When the
+// datasource locks its limits a query can only lower them.
+func (d *Databend) resultLimits(query ResultLimits) ResultLimits {
+	return ResultLimits{
+		MaxRows:  resultLimit(d.ResultLimits.MaxRows, query.MaxRows, d.LockResultLimits),
+		MaxBytes: resultLimit(d.ResultLimits.MaxBytes, query.MaxBytes, d.LockResultLimits),
+	}
+}
+
+func resultLimit(datasource, query int64, locked bool) int64 {
+	if query <= 0 {
+		return datasource
+	}
+	if locked && datasource > 0 && query > datasource {
+		return datasource
+	}
+	return query
+}
+
+// truncation tells how a result was cut by its limits.
+type truncation struct {
+	// limit is the limit reached, rows or bytes, and max its value
+	limit string
+	max   int64
+	// rows are the rows returned and dropped those dropped. When more is set the query was stopped before
+	// reading all of its rows and dropped is a lower bound.
+	rows    int64
+	dropped int64
+	more    bool
+}
+
+// withTruncationNotice warns that the result of the frame was truncated.
+func withTruncationNotice(frame *data.Frame, t *truncation) {
+	if t == nil {
+		return
+	}
+	if frame.Meta == nil {
+		frame.Meta = &data.FrameMeta{}
+	}
+	dropped := fmt.Sprintf("%d", t.dropped)
+	if t.more {
+		dropped = "at least " + dropped
+	}
+	frame.Meta.Notices = append(frame.Meta.Notices, data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text: fmt.Sprintf("The result was truncated to %d rows by the limit of %d %s, %s rows were dropped",
+			t.rows, t.max, t.limit, dropped),
+	})
+}
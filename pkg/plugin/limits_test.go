@@ -0,0 +1,108 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	godatabend "github.com/databendcloud/databend-go"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cadl/grafana-databend-datasource/pkg/converters"
+)
+
+func TestResultLimits(t *testing.T) {
+	d := &Databend{ResultLimits: ResultLimits{MaxRows: 100, MaxBytes: 1000}}
+	assert.Equal(t, ResultLimits{MaxRows: 100, MaxBytes: 1000}, d.resultLimits(ResultLimits{}))
+	assert.Equal(t, ResultLimits{MaxRows: 500, MaxBytes: 10}, d.resultLimits(ResultLimits{MaxRows: 500, MaxBytes: 10}))
+
+	// locked limits can only be lowered
+	d.LockResultLimits = true
+	assert.Equal(t, ResultLimits{MaxRows: 100, MaxBytes: 10}, d.resultLimits(ResultLimits{MaxRows: 500, MaxBytes: 10}))
+	d.ResultLimits.MaxBytes = 0
+	assert.Equal(t, ResultLimits{MaxRows: 100, MaxBytes: 5000}, d.resultLimits(ResultLimits{MaxBytes: 5000}))
+}
+
+// newLimitsTestServer returns the pages in turn and records the paths requested.
+func newLimitsTestServer(t *testing.T, pages ...godatabend.QueryResponse) (*httptest.Server, func() []string) {
+	var mu sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		page := godatabend.QueryResponse{}
+		switch r.URL.Path {
+		case "/v1/query":
+			page = pages[0]
+		case "/page/1":
+			page = pages[1]
+		}
+		assert.Nil(t, json.NewEncoder(w).Encode(page))
+	}))
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return paths
+	}
+}
+
+func TestRowsLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  ResultLimits
+		rows    int
+		notice  string
+		stopped bool
+	}{
+		{name: "rows", limits: ResultLimits{MaxRows: 2}, rows: 2, notice: "The result was truncated to 2 rows by the limit of 2 rows, at least 8 rows were dropped", stopped: true},
+		{name: "bytes", limits: ResultLimits{MaxBytes: 9}, rows: 3, notice: "The result was truncated to 3 rows by the limit of 9 bytes, at least 7 rows were dropped", stopped: true},
+		{name: "last page", limits: ResultLimits{MaxRows: 5}, rows: 5, notice: "The result was truncated to 5 rows by the limit of 5 rows, 1 rows were dropped"},
+		{name: "within limits", limits: ResultLimits{MaxRows: 6}, rows: 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, paths := newLimitsTestServer(t,
+				godatabend.QueryResponse{
+					Schema:   []godatabend.DataField{{Name: "s", Type: "String"}},
+					Data:     [][]string{{"aaa"}, {"bbb"}, {"ccc"}, {"ddd"}},
+					Stats:    godatabend.QueryStats{ResultProgress: godatabend.QueryProgress{Rows: 10}},
+					NextURI:  "/page/1",
+					FinalURI: "/final",
+				},
+				godatabend.QueryResponse{Data: [][]string{{"eee"}, {"fff"}}, FinalURI: "/final"},
+			)
+			defer server.Close()
+			db := openTestDB(t, server)
+
+			ctx := withQueryStates(context.Background(), []backend.DataQuery{{RefID: "A"}})
+			d := &Databend{ResultLimits: tt.limits}
+			ctx, _ = d.MutateQuery(ctx, backend.DataQuery{RefID: "A"})
+			rows, err := db.QueryContext(ctx, "SELECT s FROM t")
+			if !assert.Nil(t, err) {
+				return
+			}
+			frame, err := sqlutil.FrameFromRows(rows, -1, converters.GetConverters()...)
+			assert.Nil(t, err)
+			assert.Nil(t, rows.Close())
+			assert.Equal(t, tt.rows, frame.Rows())
+
+			frame.Name = "A"
+			res, err := d.MutateResponse(ctx, data.Frames{frame})
+			assert.Nil(t, err)
+			if tt.notice == "" {
+				assert.Empty(t, res[0].Meta.Notices)
+			} else {
+				assert.Equal(t, []data.Notice{{Severity: data.NoticeSeverityWarning, Text: tt.notice}}, res[0].Meta.Notices)
+			}
+			// a query stopped before its last page is released on the server
+			assert.Equal(t, tt.stopped, paths()[len(paths())-1] == "/final")
+		})
+	}
+}
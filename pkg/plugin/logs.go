@@ -0,0 +1,343 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	logsFieldLevel  = "level"
+	logsFieldID     = "id"
+	logsFieldLabels = "labels"
+	// defaultLogsLabelsMaxCardinality is the number of distinct values up to which a string field is a label
+	defaultLogsLabelsMaxCardinality = 10
+	// logsLabelsMinRows is the number of rows below which labels are not inferred, any string field of a few
+	// lines has few distinct values
+	logsLabelsMinRows = 50
+)
+
+var (
+	// defaultLogsLevelColumns are the columns the level of a log line is read from, in this order
+	defaultLogsLevelColumns = []string{"level", "severity", "severity_text", "lvl"}
+	// defaultLogsBodyColumns are the columns the body of a log line is read from, in this order
+	defaultLogsBodyColumns = []string{"body", "message", "msg", "log", "line", "content"}
+	// defaultLogsLevelPattern finds the level in the body of a log line
+	defaultLogsLevelPattern = regexp.MustCompile(`(?i)\b(emerg|alert|crit(?:ical)?|fatal|panic|err(?:or)?|eror|warn(?:ing)?|info|inf|notice|debug|dbug|trace)\b`)
+)
+
+// logLevels maps the level names found in logs to the levels Grafana colours log lines by.
+var logLevels = map[string]string{
+	"emerg":       "critical",
+	"alert":       "critical",
+	"crit":        "critical",
+	"critical":    "critical",
+	"fatal":       "critical",
+	"panic":       "critical",
+	"err":         "error",
+	"eror":        "error",
+	"error":       "error",
+	"warn":        "warning",
+	"warning":     "warning",
+	"info":        "info",
+	"inf":         "info",
+	"notice":      "info",
+	"information": "info",
+	"debug":       "debug",
+	"dbug":        "debug",
+	"trace":       "trace",
+}
+
+// logLevel returns the Grafana level of a level name, unknown for names it does not know.
+func logLevel(name string) string {
+	if level, ok := logLevels[strings.ToLower(strings.TrimSpace(name))]; ok {
+		return level
+	}
+	return "unknown"
+}
+
+// logsEnrichment turns the logs frames of a datasource into what the Logs panel expects: a level to colour
+// lines by, an id to tell them apart and the labels of every line.
+type logsEnrichment struct {
+	// levelColumns are the columns the level is read from
+	levelColumns []string
+	// levelPattern finds the level in the body when there is no level column, its first group is the level
+	levelPattern *regexp.Regexp
+	// bodyColumns are the columns the body is read from
+	bodyColumns []string
+	// labelColumns are the columns that are labels, when they are not inferred
+	labelColumns []string
+	// labelsMaxCardinality is the number of distinct values up to which string fields become labels, none
+	// do when 0. labelsMinRows is the number of rows a frame needs for them to be inferred
+	labelsMaxCardinality int
+	labelsMinRows        int
+}
+
+func newLogsEnrichment(settings Settings) (*logsEnrichment, error) {
+	if !settings.EnableLogsEnrichment {
+		return nil, nil
+	}
+	e := &logsEnrichment{
+		levelColumns:         defaultLogsLevelColumns,
+		levelPattern:         defaultLogsLevelPattern,
+		bodyColumns:          defaultLogsBodyColumns,
+		labelColumns:         splitColumns(settings.LogsLabelColumns),
+		labelsMaxCardinality: int(settings.LogsLabelsMaxCardinality),
+		labelsMinRows:        logsLabelsMinRows,
+	}
+	if columns := splitColumns(settings.LogsLevelColumns); len(columns) > 0 {
+		e.levelColumns = columns
+	}
+	if settings.LogsLevelPattern != "" {
+		pattern, err := regexp.Compile(settings.LogsLevelPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid logs level pattern: %w", err)
+		}
+		e.levelPattern = pattern
+	}
+	if settings.LogsBodyColumn != "" {
+		e.bodyColumns = []string{settings.LogsBodyColumn}
+	}
+	return e, nil
+}
+
+// splitColumns splits a comma separated list of column names.
+func splitColumns(s string) []string {
+	var columns []string
+	for _, c := range strings.Split(s, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			columns = append(columns, c)
+		}
+	}
+	return columns
+}
+
+// findField returns the first field of the frame named like one of the names, compared case insensitively.
+func findField(frame *data.Frame, names []string) *data.Field {
+	for _, name := range names {
+		for _, field := range frame.Fields {
+			if strings.EqualFold(field.Name, name) {
+				return field
+			}
+		}
+	}
+	return nil
+}
+
+func isStringField(field *data.Field) bool {
+	return field.Type() == data.FieldTypeString || field.Type() == data.FieldTypeNullableString
+}
+
+func isTimeField(field *data.Field) bool {
+	return field.Type() == data.FieldTypeTime || field.Type() == data.FieldTypeNullableTime
+}
+
+// stringAt returns the value of a field in a row as text, empty for nulls.
+func stringAt(field *data.Field, row int) string {
+	v, ok := field.ConcreteAt(row)
+	if !ok {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// enrich returns the logs frame with the time field first, followed by the body, level and id fields, the
+// other fields that are not labels, and the labels field.
+func (e *logsEnrichment) enrich(frame *data.Frame) *data.Frame {
+	enriched, _ := e.enrichWith(frame, e.isLabel)
+	return enriched
+}
+
+// enrichFor enriches a frame of the query with the label columns its state holds. The first frame with lines
+// sets them, so the frames of a live tail keep the same fields whatever lines each poll reads.
+func (e *logsEnrichment) enrichFor(frame *data.Frame, state *queryState) *data.Frame {
+	if state == nil {
+		return e.enrich(frame)
+	}
+	if columns, ok := state.logsLabels(); ok {
+		enriched, _ := e.enrichWith(frame, func(field *data.Field) bool { return columns[field.Name] })
+		return enriched
+	}
+	enriched, columns := e.enrichWith(frame, e.isLabel)
+	if frame.Rows() > 0 {
+		state.setLogsLabels(columns)
+	}
+	return enriched
+}
+
+// enrichWith enriches the frame with the fields isLabel tells are labels, and returns the names of those.
+func (e *logsEnrichment) enrichWith(frame *data.Frame, isLabel func(field *data.Field) bool) (*data.Frame, map[string]bool) {
+	rows := frame.Rows()
+	timeField := findTimeField(frame)
+	body := findField(frame, e.bodyColumns)
+	if body == nil {
+		// the Logs panel shows the first string field as the body
+		for _, field := range frame.Fields {
+			if isStringField(field) {
+				body = field
+				break
+			}
+		}
+	}
+
+	level := e.levelField(frame, body, rows)
+	// ids and labels the query returns itself are kept
+	id := findField(frame, []string{logsFieldID})
+	labels := findField(frame, []string{logsFieldLabels})
+	special := map[*data.Field]bool{timeField: true, body: true, id: true, labels: true}
+	if lf := findField(frame, e.levelColumns); lf != nil {
+		special[lf] = true
+	}
+	var labelFields, otherFields []*data.Field
+	columns := map[string]bool{}
+	for _, field := range frame.Fields {
+		switch {
+		case special[field]:
+		case labels == nil && isLabel(field):
+			labelFields = append(labelFields, field)
+			columns[field.Name] = true
+		default:
+			otherFields = append(otherFields, field)
+		}
+	}
+	if labels == nil {
+		labels = labelsField(labelFields, rows)
+	}
+	if id == nil {
+		id = logsIDField(timeField, body, labels, rows)
+	}
+
+	var fields []*data.Field
+	for _, field := range []*data.Field{timeField, body, level, id} {
+		if field != nil {
+			fields = append(fields, field)
+		}
+	}
+	fields = append(fields, otherFields...)
+	if labels != nil {
+		fields = append(fields, labels)
+	}
+	newFrame := data.NewFrame(frame.Name, fields...)
+	newFrame.SetMeta(frame.Meta)
+	return newFrame, columns
+}
+
+func findTimeField(frame *data.Frame) *data.Field {
+	for _, field := range frame.Fields {
+		if isTimeField(field) {
+			return field
+		}
+	}
+	return nil
+}
+
+// levelField returns a level field with Grafana's levels, read from the level column or found in the body.
+func (e *logsEnrichment) levelField(frame *data.Frame, body *data.Field, rows int) *data.Field {
+	source := findField(frame, e.levelColumns)
+	if source == nil && body == nil {
+		return nil
+	}
+	levels := make([]string, rows)
+	for row := range levels {
+		if source != nil {
+			levels[row] = logLevel(stringAt(source, row))
+			continue
+		}
+		levels[row] = "unknown"
+		if m := e.levelPattern.FindStringSubmatch(stringAt(body, row)); m != nil {
+			name := m[0]
+			if len(m) > 1 {
+				name = m[1]
+			}
+			levels[row] = logLevel(name)
+		}
+	}
+	return data.NewField(logsFieldLevel, nil, levels)
+}
+
+// isLabel tells whether the field is a label: one of the label columns when the datasource sets them, else a
+// string field with few distinct values in a frame with enough rows to tell.
+func (e *logsEnrichment) isLabel(field *data.Field) bool {
+	if len(e.labelColumns) > 0 {
+		for _, c := range e.labelColumns {
+			if strings.EqualFold(c, field.Name) {
+				return true
+			}
+		}
+		return false
+	}
+	return isStringField(field) && field.Len() >= e.labelsMinRows && e.isLowCardinality(field)
+}
+
+func (e *logsEnrichment) isLowCardinality(field *data.Field) bool {
+	if e.labelsMaxCardinality <= 0 {
+		return false
+	}
+	values := map[string]bool{}
+	for row := 0; row < field.Len(); row++ {
+		values[stringAt(field, row)] = true
+		if len(values) > e.labelsMaxCardinality {
+			return false
+		}
+	}
+	return true
+}
+
+// labelsField gathers the values of the label fields of every row in a JSON object, nil without labels.
+func labelsField(fields []*data.Field, rows int) *data.Field {
+	if len(fields) == 0 {
+		return nil
+	}
+	values := make([]json.RawMessage, rows)
+	for row := range values {
+		labels := make(map[string]string, len(fields))
+		for _, field := range fields {
+			if _, ok := field.ConcreteAt(row); ok {
+				labels[field.Name] = stringAt(field, row)
+			}
+		}
+		b, _ := json.Marshal(labels)
+		values[row] = b
+	}
+	return data.NewField(logsFieldLabels, nil, values)
+}
+
+// logsIDField returns ids made of a hash of the time, body and labels of every line. Lines that are equal
+// get the same hash, a counter makes their ids unique within the frame.
+func logsIDField(timeField, body, labels *data.Field, rows int) *data.Field {
+	ids := make([]string, rows)
+	seen := map[string]int{}
+	for row := range ids {
+		h := fnv.New64a()
+		if timeField != nil {
+			if t, ok := timeField.ConcreteAt(row); ok {
+				_, _ = h.Write([]byte(t.(time.Time).UTC().Format(time.RFC3339Nano)))
+			}
+		}
+		_, _ = h.Write([]byte{0})
+		if body != nil {
+			_, _ = h.Write([]byte(stringAt(body, row)))
+		}
+		_, _ = h.Write([]byte{0})
+		if labels != nil {
+			_, _ = h.Write([]byte(stringAt(labels, row)))
+		}
+		id := strconv.FormatUint(h.Sum64(), 16)
+		if n := seen[id]; n > 0 {
+			ids[row] = fmt.Sprintf("%s_%d", id, n)
+		} else {
+			ids[row] = id
+		}
+		seen[id]++
+	}
+	return data.NewField(logsFieldID, nil, ids)
+}
@@ -0,0 +1,197 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"github.com/grafana/sqlds/v2"
+)
+
+const (
+	// defaultLogsContextLines is the number of lines before and after a line returned when the request
+	// asks for none
+	defaultLogsContextLines = 10
+	maxLogsContextLines     = 1000
+)
+
+// LogsContextRequest asks for the lines of a table around a log line, those with the same labels.
+type LogsContextRequest struct {
+	Table      string `json:"table"`
+	TimeColumn string `json:"timeColumn"`
+	// TimeEpochNs is the time of the line in nanoseconds, as Grafana keeps it
+	TimeEpochNs string            `json:"timeEpochNs"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	// TieBreaker is the column ordering lines sharing a timestamp, the one of the datasource when empty, and
+	// TieBreakerValue its value in the line
+	TieBreaker      string      `json:"tieBreaker,omitempty"`
+	TieBreakerValue interface{} `json:"tieBreakerValue,omitempty"`
+	// Before and After are the number of lines returned before and after the line
+	Before int `json:"before"`
+	After  int `json:"after"`
+}
+
+func (r *LogsContextRequest) validate() error {
+	if r.Table == "" || r.TimeColumn == "" {
+		return fmt.Errorf("logs context needs a table and a time column")
+	}
+	if _, err := strconv.ParseInt(r.TimeEpochNs, 10, 64); err != nil {
+		return fmt.Errorf("invalid time of the log line %q", r.TimeEpochNs)
+	}
+	if r.Before < 0 || r.After < 0 || r.Before > maxLogsContextLines || r.After > maxLogsContextLines {
+		return fmt.Errorf("logs context returns between 0 and %d lines before and after a line", maxLogsContextLines)
+	}
+	if r.Before == 0 && r.After == 0 {
+		r.Before, r.After = defaultLogsContextLines, defaultLogsContextLines
+	}
+	return nil
+}
+
+// handleLogsContext returns the lines around a log line as a logs frame, in the time order.
+func (ds *Datasource) handleLogsContext(rw http.ResponseWriter, req *http.Request) {
+	var contextReq LogsContextRequest
+	decoder := json.NewDecoder(req.Body)
+	// ids can be larger than floats hold
+	decoder.UseNumber()
+	if err := decoder.Decode(&contextReq); err != nil {
+		logsContextError(rw, err)
+		return
+	}
+	if contextReq.TieBreaker == "" {
+		contextReq.TieBreaker = ds.driver.LogsContextTieBreaker
+	}
+	if err := contextReq.validate(); err != nil {
+		logsContextError(rw, err)
+		return
+	}
+	query := logsContextSQL(contextReq, ds.driver.location)
+
+	db, err := ds.GetDBFromQuery(&sqlds.Query{}, ds.uid)
+	if err != nil {
+		logsContextError(rw, err)
+		return
+	}
+	rows, err := db.QueryContext(req.Context(), query)
+	if err != nil {
+		logsContextError(rw, err)
+		return
+	}
+	defer rows.Close()
+	frame, err := sqlutil.FrameFromRows(rows, -1, ds.driver.Converters()...)
+	if err != nil {
+		logsContextError(rw, err)
+		return
+	}
+	frame.SetMeta(&data.FrameMeta{ExecutedQueryString: query, PreferredVisualization: data.VisTypeLogs})
+	frames, err := ds.driver.MutateResponse(req.Context(), data.Frames{frame})
+	if err != nil {
+		logsContextError(rw, err)
+		return
+	}
+	b, err := data.FrameToJSON(frames[0], data.IncludeAll)
+	if err != nil {
+		logsContextError(rw, err)
+		return
+	}
+	rw.Header().Add("Content-Type", "application/json")
+	if _, err := rw.Write(b); err != nil {
+		backend.Logger.Error(err.Error())
+	}
+}
+
+func logsContextError(rw http.ResponseWriter, err error) {
+	rw.WriteHeader(http.StatusBadRequest)
+	if _, err := rw.Write([]byte(err.Error())); err != nil {
+		backend.Logger.Error(err.Error())
+	}
+}
+
+// logsContextSQL selects the lines before the line, closest first, and the lines after it, and orders them
+// all by time. Lines sharing the timestamp of the line are told apart by the tie-breaker, without one they
+// are left out.
+func logsContextSQL(r LogsContextRequest, location *time.Location) string {
+	ns, _ := strconv.ParseInt(r.TimeEpochNs, 10, 64)
+	timeColumn := quoteIdentifier(r.TimeColumn)
+	timeValue := timeLiteral(time.Unix(0, ns), location)
+
+	var filters []string
+	keys := make([]string, 0, len(r.Labels))
+	for k := range r.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		filters = append(filters, fmt.Sprintf("%s = %s", quoteIdentifier(k), quoteString(r.Labels[k])))
+	}
+
+	side := func(op, dir string, limit int) string {
+		position := fmt.Sprintf("%s %s %s", timeColumn, op, timeValue)
+		order := fmt.Sprintf("%s %s", timeColumn, dir)
+		if r.TieBreaker != "" {
+			tieBreaker := quoteIdentifier(r.TieBreaker)
+			if r.TieBreakerValue != nil {
+				position = fmt.Sprintf("(%s OR (%s = %s AND %s %s %s))", position, timeColumn, timeValue, tieBreaker, op, sqlLiteral(r.TieBreakerValue))
+			}
+			order += fmt.Sprintf(", %s %s", tieBreaker, dir)
+		}
+		where := strings.Join(append(append([]string{}, filters...), position), " AND ")
+		return fmt.Sprintf("SELECT * FROM (SELECT * FROM %s WHERE %s ORDER BY %s LIMIT %d)", quoteTable(r.Table), where, order, limit)
+	}
+
+	var sides []string
+	if r.Before > 0 {
+		sides = append(sides, side("<", "DESC", r.Before)+" AS lines_before")
+	}
+	if r.After > 0 {
+		sides = append(sides, side(">", "ASC", r.After)+" AS lines_after")
+	}
+	order := timeColumn
+	if r.TieBreaker != "" {
+		order += ", " + quoteIdentifier(r.TieBreaker)
+	}
+	return fmt.Sprintf("SELECT * FROM (%s) AS context ORDER BY %s", strings.Join(sides, " UNION ALL "), order)
+}
+
+// quoteTable quotes every part of a table name, e.g. logs.events.
+func quoteTable(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = quoteIdentifier(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// timeLiteral writes a time as Databend reads timestamps of the datasource timezone.
+func timeLiteral(t time.Time, location *time.Location) string {
+	if location == nil {
+		location = time.UTC
+	}
+	return quoteString(t.In(location).Format("2006-01-02 15:04:05.999999"))
+}
+
+func quoteString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `''`).Replace(s) + "'"
+}
+
+// sqlLiteral writes a value decoded from JSON as a literal, numbers as numbers and others as strings.
+func sqlLiteral(v interface{}) string {
+	switch v := v.(type) {
+	case json.Number:
+		return v.String()
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return quoteString(v)
+	default:
+		return quoteString(fmt.Sprint(v))
+	}
+}
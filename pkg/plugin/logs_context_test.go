@@ -0,0 +1,102 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	godatabend "github.com/databendcloud/databend-go"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogsContextSQL(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	assert.Nil(t, err)
+	ns := time.Date(2023, 9, 1, 2, 0, 0, 500000000, time.UTC).UnixNano()
+	r := LogsContextRequest{
+		Table:           "default.logs",
+		TimeColumn:      "ts",
+		TimeEpochNs:     fmt.Sprint(ns),
+		Labels:          map[string]string{"service": "api", "host": "it's"},
+		TieBreaker:      "id",
+		TieBreakerValue: json.Number("18446744073709551615"),
+		Before:          5,
+		After:           3,
+	}
+	assert.Equal(t, "SELECT * FROM ("+
+		"SELECT * FROM (SELECT * FROM `default`.`logs` WHERE `host` = 'it''s' AND `service` = 'api' AND "+
+		"(`ts` < '2023-09-01 10:00:00.5' OR (`ts` = '2023-09-01 10:00:00.5' AND `id` < 18446744073709551615)) "+
+		"ORDER BY `ts` DESC, `id` DESC LIMIT 5) AS lines_before UNION ALL "+
+		"SELECT * FROM (SELECT * FROM `default`.`logs` WHERE `host` = 'it''s' AND `service` = 'api' AND "+
+		"(`ts` > '2023-09-01 10:00:00.5' OR (`ts` = '2023-09-01 10:00:00.5' AND `id` > 18446744073709551615)) "+
+		"ORDER BY `ts` ASC, `id` ASC LIMIT 3) AS lines_after"+
+		") AS context ORDER BY `ts`, `id`", logsContextSQL(r, shanghai))
+
+	// without a tie-breaker lines sharing the timestamp are left out
+	r = LogsContextRequest{Table: "logs", TimeColumn: "ts", TimeEpochNs: fmt.Sprint(ns), After: 2}
+	assert.Equal(t, "SELECT * FROM ("+
+		"SELECT * FROM (SELECT * FROM `logs` WHERE `ts` > '2023-09-01 02:00:00.5' ORDER BY `ts` ASC LIMIT 2) AS lines_after"+
+		") AS context ORDER BY `ts`", logsContextSQL(r, time.UTC))
+}
+
+func TestLogsContextRequestValidate(t *testing.T) {
+	r := LogsContextRequest{Table: "logs", TimeColumn: "ts", TimeEpochNs: "1"}
+	assert.Nil(t, r.validate())
+	assert.Equal(t, []int{defaultLogsContextLines, defaultLogsContextLines}, []int{r.Before, r.After})
+
+	for _, r := range []LogsContextRequest{
+		{TimeColumn: "ts", TimeEpochNs: "1"},
+		{Table: "logs", TimeEpochNs: "1"},
+		{Table: "logs", TimeColumn: "ts", TimeEpochNs: "now"},
+		{Table: "logs", TimeColumn: "ts", TimeEpochNs: "1", Before: maxLogsContextLines + 1},
+	} {
+		assert.NotNil(t, r.validate())
+	}
+}
+
+func TestHandleLogsContext(t *testing.T) {
+	var mu sync.Mutex
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			SQL string `json:"sql"`
+		}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+		mu.Lock()
+		queries = append(queries, req.SQL)
+		mu.Unlock()
+		assert.Nil(t, json.NewEncoder(w).Encode(godatabend.QueryResponse{
+			Schema: []godatabend.DataField{{Name: "ts", Type: "Timestamp"}, {Name: "msg", Type: "String"}, {Name: "id", Type: "UInt64"}},
+			Data:   [][]string{{"2023-09-01 10:00:00.000000", "before", "1"}, {"2023-09-01 10:00:02.000000", "after", "3"}},
+		}))
+	}))
+	defer server.Close()
+	ds := newTestDatasource(t, server, `"timezone":"Asia/Shanghai","logsContextTieBreaker":"id","enableLogsEnrichment":true`)
+
+	body := `{"table":"logs","timeColumn":"ts","timeEpochNs":"1693533601000000000","labels":{"service":"api"},"tieBreakerValue":2}`
+	rw := httptest.NewRecorder()
+	ds.handleLogsContext(rw, httptest.NewRequest(http.MethodPost, "/logs/context", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+
+	mu.Lock()
+	query := queries[len(queries)-1]
+	mu.Unlock()
+	// the time is written in the timezone of the datasource and the tie-breaker comes from its settings
+	assert.Contains(t, query, "(`ts` < '2023-09-01 10:00:01' OR (`ts` = '2023-09-01 10:00:01' AND `id` < 2))")
+	assert.Contains(t, query, "`service` = 'api'")
+
+	frame := &data.Frame{}
+	assert.Nil(t, json.Unmarshal(rw.Body.Bytes(), frame))
+	assert.Equal(t, []string{"ts", "msg", "level", "id"}, flattenedNames(frame.Fields))
+	assert.Equal(t, 2, frame.Rows())
+
+	rw = httptest.NewRecorder()
+	ds.handleLogsContext(rw, httptest.NewRequest(http.MethodPost, "/logs/context", strings.NewReader(`{"table":"logs"}`)))
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+}
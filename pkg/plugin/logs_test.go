@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func logsTestFrame() *data.Frame {
+	ts := time.Date(2023, 9, 1, 10, 0, 0, 0, time.UTC)
+	frame := data.NewFrame("A",
+		data.NewField("service", nil, []string{"api", "api", "db"}),
+		data.NewField("ts", nil, []time.Time{ts, ts, ts.Add(time.Second)}),
+		data.NewField("msg", nil, []string{"ERROR failed to connect", "ERROR failed to connect", "slow query, level=WARN"}),
+		data.NewField("trace_id", nil, []string{"a", "b", "c"}),
+		data.NewField("took", nil, []float64{1, 2, 3}),
+	)
+	frame.SetMeta(&data.FrameMeta{PreferredVisualization: data.VisTypeLogs})
+	return frame
+}
+
+func TestLogsEnrichmentFromBody(t *testing.T) {
+	e, err := newLogsEnrichment(Settings{EnableLogsEnrichment: true, LogsLabelsMaxCardinality: 2})
+	assert.Nil(t, err)
+	e.labelsMinRows = 3
+	got := e.enrich(logsTestFrame())
+
+	assert.Equal(t, []string{"ts", "msg", "level", "id", "trace_id", "took", "labels"}, flattenedNames(got.Fields))
+	assert.Equal(t, []interface{}{"error", "error", "warning"}, []interface{}{got.Fields[2].At(0), got.Fields[2].At(1), got.Fields[2].At(2)})
+	assert.JSONEq(t, `{"service":"api"}`, string(got.Fields[6].At(0).(json.RawMessage)))
+
+	// equal lines get unique, stable ids
+	ids := []string{got.Fields[3].At(0).(string), got.Fields[3].At(1).(string), got.Fields[3].At(2).(string)}
+	assert.Equal(t, ids[0]+"_1", ids[1])
+	assert.NotEqual(t, ids[0], ids[2])
+	assert.Equal(t, ids, func() []string {
+		again := e.enrich(logsTestFrame())
+		return []string{again.Fields[3].At(0).(string), again.Fields[3].At(1).(string), again.Fields[3].At(2).(string)}
+	}())
+}
+
+func TestLogsEnrichmentConfigured(t *testing.T) {
+	frame := data.NewFrame("A",
+		data.NewField("ts", nil, []time.Time{time.Unix(0, 0)}),
+		data.NewField("service", nil, []string{"api"}),
+		data.NewField("body", nil, []string{"level=dbug all good"}),
+		data.NewField("severity", nil, []string{"ERROR"}),
+	)
+	e, err := newLogsEnrichment(Settings{EnableLogsEnrichment: true, LogsBodyColumn: "body", LogsLevelPattern: `level=(\w+)`, LogsLevelColumns: "lvl"})
+	assert.Nil(t, err)
+	got := e.enrich(frame)
+	assert.Equal(t, []string{"ts", "body", "level", "id", "service", "severity"}, flattenedNames(got.Fields))
+	assert.Equal(t, "debug", got.Fields[2].At(0))
+
+	// the level column wins over the body
+	e, err = newLogsEnrichment(Settings{EnableLogsEnrichment: true, LogsLabelsMaxCardinality: 10, LogsLabelColumns: "service"})
+	assert.Nil(t, err)
+	got = e.enrich(frame)
+	assert.Equal(t, []string{"ts", "body", "level", "id", "labels"}, flattenedNames(got.Fields))
+	assert.Equal(t, "error", got.Fields[2].At(0))
+
+	_, err = newLogsEnrichment(Settings{EnableLogsEnrichment: true, LogsLevelPattern: "("})
+	assert.NotNil(t, err)
+}
+
+func TestLogsEnrichmentLabelsStable(t *testing.T) {
+	// a few lines do not tell which fields are labels, they all have few values
+	e, err := newLogsEnrichment(Settings{EnableLogsEnrichment: true, LogsLabelsMaxCardinality: 2})
+	assert.Nil(t, err)
+	got := e.enrich(logsTestFrame())
+	assert.Equal(t, []string{"ts", "msg", "level", "id", "service", "trace_id", "took"}, flattenedNames(got.Fields))
+
+	// the label columns of the datasource are labels whatever the number of rows
+	e, err = newLogsEnrichment(Settings{EnableLogsEnrichment: true, LogsLabelsMaxCardinality: 2, LogsLabelColumns: "service, trace_id"})
+	assert.Nil(t, err)
+	for _, frame := range []*data.Frame{logsTestFrame(), logsTestFrame().EmptyCopy()} {
+		got = e.enrich(frame)
+		assert.Equal(t, []string{"ts", "msg", "level", "id", "took", "labels"}, flattenedNames(got.Fields))
+	}
+	assert.JSONEq(t, `{"service":"api","trace_id":"a"}`, string(e.enrich(logsTestFrame()).Fields[5].At(0).(json.RawMessage)))
+}
+
+func TestLogsEnrichmentForQueryKeepsLabels(t *testing.T) {
+	e, err := newLogsEnrichment(Settings{EnableLogsEnrichment: true, LogsLabelsMaxCardinality: 2})
+	assert.Nil(t, err)
+	ts := time.Date(2023, 9, 1, 10, 0, 0, 0, time.UTC)
+	lines := func(rows int) *data.Frame {
+		services, times, msgs := make([]string, rows), make([]time.Time, rows), make([]string, rows)
+		for i := range services {
+			services[i], times[i], msgs[i] = []string{"api", "db"}[i%2], ts, fmt.Sprintf("line %d", i)
+		}
+		return data.NewFrame("", data.NewField("ts", nil, times), data.NewField("msg", nil, msgs), data.NewField("service", nil, services))
+	}
+	state := &queryState{}
+	// no lines set nothing, the first lines set the labels the next frames keep
+	assert.Equal(t, []string{"ts", "msg", "level", "id", "service"}, flattenedNames(e.enrichFor(lines(0), state).Fields))
+	for _, rows := range []int{logsLabelsMinRows, 1} {
+		assert.Equal(t, []string{"ts", "msg", "level", "id", "labels"}, flattenedNames(e.enrichFor(lines(rows), state).Fields))
+	}
+	assert.Equal(t, []string{"ts", "msg", "level", "id", "service"}, flattenedNames(e.enrich(lines(1)).Fields))
+}
+
+func TestMutateResponseEnrichesLogs(t *testing.T) {
+	d, err := NewDatabend(Settings{EnableLogsEnrichment: true})
+	assert.Nil(t, err)
+	res, err := d.MutateResponse(context.Background(), data.Frames{logsTestFrame()})
+	assert.Nil(t, err)
+	assert.Equal(t, "level", res[0].Fields[2].Name)
+
+	d, err = NewDatabend(Settings{})
+	assert.Nil(t, err)
+	res, err = d.MutateResponse(context.Background(), data.Frames{logsTestFrame()})
+	assert.Nil(t, err)
+	assert.Equal(t, "service", res[0].Fields[0].Name)
+}
@@ -0,0 +1,291 @@
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cadl/grafana-databend-datasource/pkg/converters"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"github.com/grafana/sqlds/v2"
+)
+
+const (
+	logsVolumeFieldTime  = "Time"
+	logsVolumeFieldValue = "Value"
+	// logsVolumeAll names the bars of logs without a level column
+	logsVolumeAll = "logs"
+)
+
+// logsVolumeColors are the colours of the bars of every level, those of the Logs panel.
+var logsVolumeColors = map[string]string{
+	"critical": "#705DA0",
+	"error":    "#E24D42",
+	"warning":  "#EAB839",
+	"info":     "#7EB26D",
+	"debug":    "#1F78C1",
+	"trace":    "#6ED0E0",
+	"unknown":  "#8E8E8E",
+}
+
+// logsVolumeLimit is a LIMIT ending the query, which would cap the lines counted.
+var logsVolumeLimit = regexp.MustCompile(`(?is)\s+LIMIT\s+\d+(\s*,\s*\d+)?(\s+OFFSET\s+\d+)?\s*$`)
+
+// LogsVolumeOptions turn a logs query into the query of its volume histogram: the number of lines of every
+// level in every interval.
+type LogsVolumeOptions struct {
+	// TimeColumn is the column the lines are bucketed by, the first time column when empty
+	TimeColumn string `json:"timeColumn,omitempty"`
+	// LevelColumn is the column the lines are grouped by, the first of the level columns of the datasource
+	// when empty. Without one lines are only counted.
+	LevelColumn string `json:"levelColumn,omitempty"`
+}
+
+// logsVolumeQuery rewrites a query with logs volume options into the volume query of its SQL. The columns
+// the options leave out are found by running the SQL without returning any rows.
+func (ds *Datasource) logsVolumeQuery(ctx context.Context, pluginContext backend.PluginContext, q backend.DataQuery) (backend.DataQuery, error) {
+	var options QueryOptions
+	if err := json.Unmarshal(q.JSON, &options); err != nil || options.LogsVolume == nil {
+		return q, nil
+	}
+	query, err := sqlds.GetQuery(q)
+	if err != nil {
+		return q, err
+	}
+	timeColumn, levelColumn := options.LogsVolume.TimeColumn, options.LogsVolume.LevelColumn
+	if timeColumn == "" || levelColumn == "" {
+		rawSQL, err := sqlds.Interpolate(ds.driver, query)
+		if err != nil {
+			return q, fmt.Errorf("%s: %w", "Could not apply macros", err)
+		}
+		db, err := ds.GetDBFromQuery(query, datasourceUID(pluginContext.DataSourceInstanceSettings))
+		if err != nil {
+			return q, err
+		}
+		// the columns are found by a query of its own, which waits for its turn and fails like the query
+		ctx = withQueryState(withQueryStates(ctx, []backend.DataQuery{q}), q.RefID)
+		names, types, err := queryColumns(ctx, db, rawSQL)
+		if err != nil {
+			return q, err
+		}
+		timeColumn, levelColumn, err = logsVolumeColumns(names, types, *options.LogsVolume, ds.driver.logsLevelColumns())
+		if err != nil {
+			return q, err
+		}
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(q.JSON, &body); err != nil {
+		return q, err
+	}
+	body["rawSql"] = logsVolumeSQL(query.RawSQL, timeColumn, levelColumn)
+	body["format"] = sqlutil.FormatOptionTable
+	if q.JSON, err = json.Marshal(body); err != nil {
+		return q, err
+	}
+	return q, nil
+}
+
+// datasourceUID returns the key sqlds keeps the connection of the datasource under.
+func datasourceUID(settings *backend.DataSourceInstanceSettings) string {
+	if settings == nil {
+		return ""
+	}
+	if settings.UID == "" {
+		return fmt.Sprintf("%d", settings.ID)
+	}
+	return settings.UID
+}
+
+// queryColumns returns the names and types of the columns of the query.
+func queryColumns(ctx context.Context, db *sql.DB, query string) ([]string, []converters.ColumnType, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT * FROM (%s) AS logs LIMIT 0", trimQuery(query)))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, nil, err
+	}
+	names := make([]string, len(columnTypes))
+	types := make([]converters.ColumnType, len(columnTypes))
+	for i, c := range columnTypes {
+		names[i] = c.Name()
+		if types[i], err = converters.ParseColumnType(c.DatabaseTypeName()); err != nil {
+			return nil, nil, err
+		}
+	}
+	return names, types, rows.Err()
+}
+
+// logsVolumeColumns returns the time column and the level column of a logs query, the level column is empty
+// when it has none.
+func logsVolumeColumns(names []string, types []converters.ColumnType, opts LogsVolumeOptions, levelColumns []string) (string, string, error) {
+	timeColumn, err := findTimeColumn(names, types, opts.TimeColumn)
+	if err != nil {
+		return "", "", err
+	}
+	if opts.LevelColumn != "" {
+		levelColumn := findColumn(names, []string{opts.LevelColumn})
+		if levelColumn == "" {
+			return "", "", fmt.Errorf("level column %s is not in the result", opts.LevelColumn)
+		}
+		return timeColumn, levelColumn, nil
+	}
+	return timeColumn, findColumn(names, levelColumns), nil
+}
+
+// findColumn returns the first column named like one of the candidates, compared case insensitively.
+func findColumn(names []string, candidates []string) string {
+	for _, candidate := range candidates {
+		for _, name := range names {
+			if strings.EqualFold(name, candidate) {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// findTimeColumn returns the column named column, or the first column of a time type when column is empty.
+func findTimeColumn(names []string, types []converters.ColumnType, column string) (string, error) {
+	if column != "" {
+		if found := findColumn(names, []string{column}); found != "" {
+			return found, nil
+		}
+		return "", fmt.Errorf("time column %s is not in the result", column)
+	}
+	for i, t := range types {
+		if name := t.NotNull().Name; name == "DateTime" || name == "DateTime64" || name == "Timestamp" {
+			return names[i], nil
+		}
+	}
+	return "", fmt.Errorf("the result has no time column")
+}
+
+// trimQuery removes the semicolon and the LIMIT ending a query, the volume counts every line of the range.
+func trimQuery(query string) string {
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+	return logsVolumeLimit.ReplaceAllString(query, "")
+}
+
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// logsVolumeSQL counts the lines of the query by time bucket and level. Levels are named as Grafana does
+// once counted, so the query groups by the values of the level column.
+func logsVolumeSQL(query, timeColumn, levelColumn string) string {
+	level := "NULL"
+	if levelColumn != "" {
+		level = quoteIdentifier(levelColumn)
+	}
+	return fmt.Sprintf("SELECT $__timeInterval(%s) AS time, %s AS level, count(*) AS count FROM (%s) AS logs GROUP BY 1, 2 ORDER BY 1",
+		quoteIdentifier(timeColumn), level, trimQuery(query))
+}
+
+// logsVolumeFrames turns the counts of a logs volume query into a frame of bars for every level, the frames
+// Explore shows in its logs volume histogram.
+func logsVolumeFrames(frame *data.Frame) (data.Frames, error) {
+	if len(frame.Fields) != 3 {
+		return nil, fmt.Errorf("logs volume results need a time, a level and a count column")
+	}
+	timeField, levelField, countField := frame.Fields[0], frame.Fields[1], frame.Fields[2]
+	if !isTimeField(timeField) {
+		return nil, fmt.Errorf("logs volume results need a time column first")
+	}
+
+	var times []time.Time
+	counts := map[string]map[time.Time]float64{}
+	leveled := false
+	for row := 0; row < frame.Rows(); row++ {
+		v, ok := timeField.ConcreteAt(row)
+		if !ok {
+			continue
+		}
+		t := v.(time.Time)
+		if len(times) == 0 || !times[len(times)-1].Equal(t) {
+			times = append(times, t)
+		}
+		level := logsVolumeAll
+		if _, ok := levelField.ConcreteAt(row); ok {
+			level, leveled = logLevel(stringAt(levelField, row)), true
+		}
+		count, err := countField.FloatAt(row)
+		if err != nil {
+			return nil, fmt.Errorf("invalid logs volume count: %w", err)
+		}
+		if counts[level] == nil {
+			counts[level] = map[time.Time]float64{}
+		}
+		counts[level][t] += count
+	}
+	if leveled && counts[logsVolumeAll] != nil {
+		// lines without a level among lines with one
+		for t, count := range counts[logsVolumeAll] {
+			if counts["unknown"] == nil {
+				counts["unknown"] = map[time.Time]float64{}
+			}
+			counts["unknown"][t] += count
+		}
+		delete(counts, logsVolumeAll)
+	}
+
+	levels := make([]string, 0, len(counts))
+	for level := range counts {
+		levels = append(levels, level)
+	}
+	sort.Strings(levels)
+	frames := make(data.Frames, 0, len(levels))
+	for _, level := range levels {
+		values := make([]float64, len(times))
+		for i, t := range times {
+			values[i] = counts[level][t]
+		}
+		value := data.NewField(logsVolumeFieldValue, data.Labels{logsFieldLevel: level}, values)
+		value.Config = logsVolumeFieldConfig(level)
+		volume := data.NewFrame(frame.Name, data.NewField(logsVolumeFieldTime, nil, times), value)
+		volume.SetMeta(&data.FrameMeta{
+			PreferredVisualization: data.VisTypeGraph,
+			Custom:                 map[string]interface{}{"logsVolumeType": "FullRange"},
+		})
+		frames = append(frames, volume)
+	}
+	return frames, nil
+}
+
+// logsVolumeFieldConfig draws the counts of a level as stacked bars in the colour of the level.
+func logsVolumeFieldConfig(level string) *data.FieldConfig {
+	color, ok := logsVolumeColors[level]
+	if !ok {
+		color = logsVolumeColors["unknown"]
+	}
+	return &data.FieldConfig{
+		DisplayNameFromDS: level,
+		Color: map[string]interface{}{
+			"mode":       "fixed",
+			"fixedColor": color,
+		},
+		Custom: map[string]interface{}{
+			"drawStyle":    "bars",
+			"barAlignment": 0,
+			"lineColor":    color,
+			"pointColor":   color,
+			"fillColor":    color,
+			"lineWidth":    1,
+			"fillOpacity":  100,
+			"stacking": map[string]interface{}{
+				"mode":  "normal",
+				"group": "A",
+			},
+		},
+	}
+}
@@ -0,0 +1,174 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	godatabend "github.com/databendcloud/databend-go"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cadl/grafana-databend-datasource/pkg/converters"
+)
+
+func TestLogsVolumeSQL(t *testing.T) {
+	got := logsVolumeSQL("SELECT * FROM logs WHERE $__timeFilter(ts) ORDER BY ts DESC LIMIT 1000;", "ts", "severity")
+	assert.Equal(t, "SELECT $__timeInterval(`ts`) AS time, `severity` AS level, count(*) AS count FROM (SELECT * FROM logs WHERE $__timeFilter(ts) ORDER BY ts DESC) AS logs GROUP BY 1, 2 ORDER BY 1", got)
+
+	// a limit of a subquery is kept
+	got = logsVolumeSQL("SELECT * FROM (SELECT * FROM logs LIMIT 10)", "ts", "")
+	assert.Equal(t, "SELECT $__timeInterval(`ts`) AS time, NULL AS level, count(*) AS count FROM (SELECT * FROM (SELECT * FROM logs LIMIT 10)) AS logs GROUP BY 1, 2 ORDER BY 1", got)
+}
+
+func TestLogsVolumeColumns(t *testing.T) {
+	names := []string{"id", "Timestamp", "SeverityText", "body"}
+	var types []converters.ColumnType
+	for _, name := range []string{"UInt64", "Nullable(Timestamp)", "String", "String"} {
+		ct, err := converters.ParseColumnType(name)
+		assert.Nil(t, err)
+		types = append(types, ct)
+	}
+
+	timeColumn, levelColumn, err := logsVolumeColumns(names, types, LogsVolumeOptions{}, []string{"severity_text", "severitytext"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Timestamp", timeColumn)
+	assert.Equal(t, "SeverityText", levelColumn)
+
+	timeColumn, levelColumn, err = logsVolumeColumns(names, types, LogsVolumeOptions{}, defaultLogsLevelColumns)
+	assert.Nil(t, err)
+	assert.Equal(t, "Timestamp", timeColumn)
+	assert.Equal(t, "", levelColumn)
+
+	timeColumn, levelColumn, err = logsVolumeColumns(names, types, LogsVolumeOptions{TimeColumn: "timestamp", LevelColumn: "body"}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "Timestamp", timeColumn)
+	assert.Equal(t, "body", levelColumn)
+
+	_, _, err = logsVolumeColumns(names, types, LogsVolumeOptions{LevelColumn: "lvl"}, nil)
+	assert.NotNil(t, err)
+	_, _, err = logsVolumeColumns(names[:1], types[:1], LogsVolumeOptions{}, nil)
+	assert.NotNil(t, err)
+}
+
+func TestLogsVolumeFrames(t *testing.T) {
+	t0 := time.Date(2023, 9, 1, 10, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Minute)
+	level := func(s string) *string { return &s }
+	frame := data.NewFrame("A",
+		data.NewField("time", nil, []time.Time{t0, t0, t0, t1}),
+		data.NewField("level", nil, []*string{level("ERROR"), level("err"), level("info"), nil}),
+		data.NewField("count", nil, []uint64{2, 1, 5, 3}),
+	)
+	frames, err := logsVolumeFrames(frame)
+	assert.Nil(t, err)
+	assert.Len(t, frames, 3)
+
+	byLevel := map[string]*data.Frame{}
+	for _, f := range frames {
+		assert.Equal(t, []string{"Time", "Value"}, flattenedNames(f.Fields))
+		assert.Equal(t, 2, f.Rows())
+		byLevel[f.Fields[1].Config.DisplayNameFromDS] = f
+	}
+	assert.Equal(t, []interface{}{3.0, 0.0}, []interface{}{byLevel["error"].Fields[1].At(0), byLevel["error"].Fields[1].At(1)})
+	assert.Equal(t, []interface{}{5.0, 0.0}, []interface{}{byLevel["info"].Fields[1].At(0), byLevel["info"].Fields[1].At(1)})
+	// lines without a level among lines with one are unknown
+	assert.Equal(t, []interface{}{0.0, 3.0}, []interface{}{byLevel["unknown"].Fields[1].At(0), byLevel["unknown"].Fields[1].At(1)})
+	assert.Equal(t, data.Labels{"level": "error"}, byLevel["error"].Fields[1].Labels)
+	assert.Equal(t, "FullRange", frames[0].Meta.Custom.(map[string]interface{})["logsVolumeType"])
+
+	// without a level column lines are only counted
+	frame = data.NewFrame("A",
+		data.NewField("time", nil, []time.Time{t0, t1}),
+		data.NewField("level", nil, []*string{nil, nil}),
+		data.NewField("count", nil, []uint64{2, 1}),
+	)
+	frames, err = logsVolumeFrames(frame)
+	assert.Nil(t, err)
+	assert.Len(t, frames, 1)
+	assert.Equal(t, "logs", frames[0].Fields[1].Config.DisplayNameFromDS)
+}
+
+func TestMutateResponseLogsVolume(t *testing.T) {
+	ctx := withQueryStates(context.Background(), []backend.DataQuery{{RefID: "A", JSON: json.RawMessage(`{"logsVolume":{}}`)}})
+	frame := data.NewFrame("A",
+		data.NewField("time", nil, []time.Time{time.Unix(0, 0)}),
+		data.NewField("level", nil, []string{"warn"}),
+		data.NewField("count", nil, []uint64{4}),
+	)
+	d, err := NewDatabend(Settings{EnableLogsEnrichment: true})
+	assert.Nil(t, err)
+	res, err := d.MutateResponse(ctx, data.Frames{frame})
+	assert.Nil(t, err)
+	assert.Len(t, res, 1)
+	assert.Equal(t, "warning", res[0].Fields[1].Config.DisplayNameFromDS)
+	assert.Equal(t, 4.0, res[0].Fields[1].At(0))
+}
+
+func TestQueryColumns(t *testing.T) {
+	server := newTestServer(t, godatabend.QueryResponse{
+		Schema: []godatabend.DataField{
+			{Name: "ts", Type: "Timestamp"},
+			{Name: "level", Type: "Nullable(String)"},
+		},
+	})
+	defer server.Close()
+	db := openTestDB(t, server)
+
+	names, types, err := queryColumns(context.Background(), db, "SELECT ts, level FROM logs LIMIT 100")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ts", "level"}, names)
+	assert.Equal(t, []string{"Timestamp", "Nullable(String)"}, []string{types[0].String(), types[1].String()})
+}
+
+func TestQueryDataLogsVolumeColumns(t *testing.T) {
+	var mu sync.Mutex
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req godatabend.QueryRequest
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+		mu.Lock()
+		queries = append(queries, req.SQL)
+		mu.Unlock()
+		if strings.Contains(req.SQL, "missing") {
+			assert.Nil(t, json.NewEncoder(w).Encode(godatabend.QueryResponse{
+				Error: &godatabend.QueryError{Code: 1025, Message: "Unknown table `default`.`missing`"},
+			}))
+			return
+		}
+		assert.Nil(t, json.NewEncoder(w).Encode(godatabend.QueryResponse{
+			Schema: []godatabend.DataField{{Name: "time", Type: "Timestamp"}, {Name: "level", Type: "String"}, {Name: "count", Type: "UInt64"}},
+			Data:   [][]string{{"2023-09-01 10:00:00.000000", "warn", "4"}},
+		}))
+	}))
+	defer server.Close()
+	ds := newTestDatasource(t, server, `"timezone":"UTC"`)
+	queryData := func(query string) backend.DataResponse {
+		res, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+			PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: ds.uid}},
+			Queries:       []backend.DataQuery{{RefID: "A", Interval: time.Minute, JSON: json.RawMessage(query)}},
+		})
+		assert.Nil(t, err)
+		return res.Responses["A"]
+	}
+
+	// the columns the query names are not looked for
+	a := queryData(`{"rawSql":"SELECT * FROM logs","format":2,"logsVolume":{"timeColumn":"ts","levelColumn":"severity"}}`)
+	assert.Nil(t, a.Error)
+	if assert.Len(t, queries, 1) {
+		assert.Contains(t, queries[0], "`ts`")
+		assert.Contains(t, queries[0], "`severity` AS level")
+	}
+
+	// looking for them fails like the query does
+	a = queryData(`{"rawSql":"SELECT * FROM missing","format":2,"logsVolume":{}}`)
+	assert.ErrorIs(t, a.Error, ErrorUnknownTable)
+	assert.Equal(t, backend.StatusBadRequest, a.Status)
+	assert.Len(t, queries, 2)
+}
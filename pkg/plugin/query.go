@@ -0,0 +1,222 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/cadl/grafana-databend-datasource/pkg/converters"
+	godatabend "github.com/databendcloud/databend-go"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// QueryOptions are the Databend specific options of a query, read from the query JSON next to the
+// fields sqlds understands.
+type QueryOptions struct {
+	FormatOptions FormatOptions `json:"formatOptions"`
+	// Flatten flattens the map fields of the query when set
+	Flatten *FlattenOptions `json:"flatten,omitempty"`
+	// Trace maps the columns of a query in the trace format to the trace frame fields
+	Trace TraceOptions `json:"trace"`
+	// LogsVolume turns the query into the volume query of its logs when set
+	LogsVolume *LogsVolumeOptions `json:"logsVolume,omitempty"`
+	// Limits cap the result of the query, within those of the datasource when it locks them
+	Limits ResultLimits `json:"limits"`
+	// NoCache runs the query even when the datasource cached its result
+	NoCache bool `json:"noCache,omitempty"`
+	// Incremental reads the buckets of $__timeInterval a previous refresh read from the cache, and only queries
+	// the new ones
+	Incremental bool `json:"incremental,omitempty"`
+}
+
+// queryState is what the plugin knows about a query while sqlds runs it. sqlds only passes the frames to
+// MutateResponse, so the state travels in the context, keyed by the query RefID.
+type queryState struct {
+	options QueryOptions
+
+	mu          sync.Mutex
+	columnTypes map[string]converters.ColumnType
+	location    *time.Location
+	// queryID and stats are those of the last page Databend returned for the query
+	queryID string
+	stats   *godatabend.QueryStats
+	// limits cap the rows read, truncated tells how the result was cut when they did
+	limits    ResultLimits
+	truncated *truncation
+	// queued is set when the datasource caps its queries, the query waited behind queueDepth queries for
+	// queueWait then
+	queued     bool
+	queueDepth int
+	queueWait  time.Duration
+	// retries is the number of times the query ran again after a transient error
+	retries int
+	// err is the error the query failed with, typed
+	err error
+	// labels are the columns the labels of its logs are taken from, once a frame with lines set them
+	labels map[string]bool
+}
+
+func (s *queryState) setColumns(names []string, types []converters.ColumnType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.columnTypes = make(map[string]converters.ColumnType, len(names))
+	for i, name := range names {
+		s.columnTypes[name] = types[i]
+	}
+}
+
+func (s *queryState) setLocation(location *time.Location) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.location = location
+}
+
+func (s *queryState) setStats(queryID string, stats godatabend.QueryStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queryID, s.stats = queryID, &stats
+}
+
+// queryStats returns the id and the stats of the query, nil stats if it did not run.
+func (s *queryState) queryStats() (string, *godatabend.QueryStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queryID, s.stats
+}
+
+func (s *queryState) setQueue(depth int, wait time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queued, s.queueDepth, s.queueWait = true, depth, wait
+}
+
+// queue returns the queries the query waited behind and how long, ok is false when queries are not capped.
+func (s *queryState) queue() (depth int, wait time.Duration, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queueDepth, s.queueWait, s.queued
+}
+
+func (s *queryState) setRetries(retries int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retries = retries
+}
+
+func (s *queryState) retryCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.retries
+}
+
+func (s *queryState) setError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+func (s *queryState) queryError() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *queryState) setLimits(limits ResultLimits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limits = limits
+}
+
+func (s *queryState) resultLimits() ResultLimits {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limits
+}
+
+func (s *queryState) setTruncation(t truncation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.truncated = &t
+}
+
+// truncation returns how the result of the query was cut, nil when it was not.
+func (s *queryState) truncation() *truncation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.truncated
+}
+
+// timezone returns the name of the location the query read its dates and timestamps in, UTC by default.
+func (s *queryState) timezone() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.location == nil {
+		return time.UTC.String()
+	}
+	return s.location.String()
+}
+
+func (s *queryState) setLogsLabels(columns map[string]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.labels = columns
+}
+
+// logsLabels returns the label columns of the logs of the query, false until they are set.
+func (s *queryState) logsLabels() (map[string]bool, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.labels, s.labels != nil
+}
+
+func (s *queryState) columnType(name string) (converters.ColumnType, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.columnTypes[name]
+	return t, ok
+}
+
+type queryStatesKey struct{}
+
+type queryStateKey struct{}
+
+// withQueryStates adds a state for every query of the request to the context.
+func withQueryStates(ctx context.Context, queries []backend.DataQuery) context.Context {
+	states := make(map[string]*queryState, len(queries))
+	for _, q := range queries {
+		state := &queryState{}
+		// invalid JSON is reported by sqlds when it parses the query
+		_ = json.Unmarshal(q.JSON, &state.options)
+		states[q.RefID] = state
+	}
+	return context.WithValue(ctx, queryStatesKey{}, states)
+}
+
+// queryStateFor returns the state of the query with the RefID, nil if the request has no states.
+func queryStateFor(ctx context.Context, refID string) *queryState {
+	states, _ := ctx.Value(queryStatesKey{}).(map[string]*queryState)
+	return states[refID]
+}
+
+// withQueryState marks the query with the RefID as the one the context runs.
+func withQueryState(ctx context.Context, refID string) context.Context {
+	state := queryStateFor(ctx, refID)
+	if state == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, queryStateKey{}, state)
+}
+
+// withKeptQueryState runs the context with a state kept across requests, as a live tail keeps its state
+// from one poll to the next.
+func withKeptQueryState(ctx context.Context, refID string, state *queryState) context.Context {
+	ctx = context.WithValue(ctx, queryStatesKey{}, map[string]*queryState{refID: state})
+	return context.WithValue(ctx, queryStateKey{}, state)
+}
+
+// queryStateFromContext returns the state of the query the context runs.
+func queryStateFromContext(ctx context.Context) *queryState {
+	state, _ := ctx.Value(queryStateKey{}).(*queryState)
+	return state
+}
@@ -0,0 +1,150 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	godatabend "github.com/databendcloud/databend-go"
+)
+
+const (
+	// defaultMaxQueryRetries is the number of times a read query failing on a transient error runs again,
+	// when the settings leave it out
+	defaultMaxQueryRetries = 2
+	// retryBaseDelay and retryMaxDelay bound the backoff between two runs of a query
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 8 * time.Second
+)
+
+// transientErrorCodes are the codes of the Databend errors a query may not fail with when it runs again.
+var transientErrorCodes = map[int]string{
+	1041: "TooManyUserConnections",
+	1042: "AbortedSession",
+}
+
+// transientErrorMessages are found in the errors of warehouses starting or resuming.
+var transientErrorMessages = []string{
+	godatabend.ProvisionWarehouseTimeout,
+	"warehouse is starting",
+	"warehouse is resuming",
+	"warehouse is suspended",
+}
+
+// retryPolicy runs read queries again when they fail on a transient error, waiting longer every time, as long
+// as the context of the query leaves the time to. Queries that write are never run again by the policy, only
+// started again by the connector while they fail before the server ran them.
+type retryPolicy struct {
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+}
+
+func newRetryPolicy(settings Settings) retryPolicy {
+	return retryPolicy{maxRetries: int(settings.MaxQueryRetries), baseDelay: retryBaseDelay, maxDelay: retryMaxDelay}
+}
+
+// backoff returns how long to wait before the retry, a random delay up to the base delay doubled for every
+// retry before, so queries failing together do not all run again at once.
+func (p retryPolicy) backoff(retry int) time.Duration {
+	ceiling := p.maxDelay
+	if retry < 30 && p.baseDelay<<retry < p.maxDelay {
+		ceiling = p.baseDelay << retry
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// do runs the query, again while it fails on a transient error and the policy allows, and returns the number of
+// retries it took.
+func (p retryPolicy) do(ctx context.Context, query string, run func() (*godatabend.QueryResponse, error)) (*godatabend.QueryResponse, int, error) {
+	resp, err := run()
+	if !isReadQuery(query) {
+		return resp, 0, err
+	}
+	retries := 0
+	for ; err != nil && retries < p.maxRetries && isTransient(err); retries++ {
+		delay := p.backoff(retries)
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			break
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, retries, ctx.Err()
+		}
+		resp, err = run()
+	}
+	return resp, retries, err
+}
+
+// isTransient tells whether the error is one a query may not fail with when it runs again: the server could
+// not be reached or did not answer, is restarting, or its warehouse is starting.
+func isTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, godatabend.ErrDoRequest) || errors.Is(err, godatabend.ErrReadResponse) {
+		return true
+	}
+	var apiErr godatabend.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout, 520:
+			return true
+		}
+	}
+	var queryErr *godatabend.QueryError
+	if errors.As(err, &queryErr) {
+		if _, ok := transientErrorCodes[queryErr.Code]; ok {
+			return true
+		}
+	}
+	message := strings.ToLower(err.Error())
+	for _, m := range transientErrorMessages {
+		if strings.Contains(message, strings.ToLower(m)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isReadQuery tells whether the query only reads, running it again changes nothing.
+func isReadQuery(query string) bool {
+	query = strings.TrimSpace(query)
+	for {
+		switch {
+		case strings.HasPrefix(query, "--"):
+			i := strings.IndexByte(query, '\n')
+			if i < 0 {
+				return false
+			}
+			query = strings.TrimSpace(query[i:])
+		case strings.HasPrefix(query, "/*"):
+			i := strings.Index(query, "*/")
+			if i < 0 {
+				return false
+			}
+			query = strings.TrimSpace(query[i+2:])
+		case strings.HasPrefix(query, "("):
+			query = strings.TrimSpace(query[1:])
+		default:
+			end := strings.IndexFunc(query, func(r rune) bool {
+				return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+			})
+			if end < 0 {
+				end = len(query)
+			}
+			switch strings.ToUpper(query[:end]) {
+			case "SELECT", "WITH", "SHOW", "DESC", "DESCRIBE", "EXPLAIN":
+				return true
+			}
+			return false
+		}
+	}
+}
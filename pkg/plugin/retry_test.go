@@ -0,0 +1,107 @@
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	godatabend "github.com/databendcloud/databend-go"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{errors.Wrap(errors.Wrap(godatabend.ErrDoRequest, "dial tcp: connection refused"), "failed to do query request"), true},
+		{errors.Wrap(godatabend.NewAPIError("please retry again later.", http.StatusServiceUnavailable, nil), "failed to do query request"), true},
+		{godatabend.NewAPIError("please retry again later.", http.StatusInternalServerError, nil), false},
+		{godatabend.NewAPIError("please check your arguments.", http.StatusBadRequest, []byte(`{"message":"ProvisionWarehouseTimeout"}`)), true},
+		{errors.Wrap(&godatabend.QueryError{Code: 1042, Message: "aborted"}, "query error"), true},
+		{errors.Wrap(&godatabend.QueryError{Code: 1005, Message: "syntax error"}, "query error"), false},
+		{fmt.Errorf("query: %w", context.DeadlineExceeded), false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, isTransient(tt.err), tt.err.Error())
+	}
+}
+
+func TestIsReadQuery(t *testing.T) {
+	for query, want := range map[string]bool{
+		"SELECT 1":                                  true,
+		"  with t AS (SELECT 1) SELECT * FROM t":    true,
+		"-- panel\n/* note */ (SELECT 1) UNION ALL": true,
+		"SHOW TABLES":                               true,
+		"INSERT INTO t SELECT 1":                    false,
+		"KILL QUERY 'q'":                            false,
+		"-- only a comment":                         false,
+		"":                                          false,
+	} {
+		assert.Equal(t, want, isReadQuery(query), query)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := retryPolicy{baseDelay: 100 * time.Millisecond, maxDelay: time.Second}
+	for retry := 0; retry < 40; retry++ {
+		ceiling := time.Second
+		if retry < 4 {
+			ceiling = 100 * time.Millisecond << retry
+		}
+		delay := p.backoff(retry)
+		assert.True(t, delay >= 0 && delay <= ceiling, "retry %d waited %s", retry, delay)
+	}
+}
+
+func TestConnQueryRetried(t *testing.T) {
+	var requests, failures atomic.Int32
+	failures.Store(2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if failures.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		assert.Nil(t, json.NewEncoder(w).Encode(godatabend.QueryResponse{
+			Schema: []godatabend.DataField{{Name: "n", Type: "UInt64"}},
+			Data:   [][]string{{"1"}},
+		}))
+	}))
+	defer server.Close()
+	cfg := godatabend.NewConfig()
+	cfg.Host = strings.TrimPrefix(server.URL, "http://")
+	cfg.SSLMode = godatabend.SSL_MODE_DISABLE
+	cfg.User, cfg.Password = "databend", "databend"
+	c, err := newConnector(cfg.FormatDSN())
+	assert.Nil(t, err)
+	c.retry = retryPolicy{maxRetries: 2, baseDelay: time.Millisecond, maxDelay: time.Millisecond}
+	db := sql.OpenDB(c)
+
+	ctx := withQueryStates(context.Background(), []backend.DataQuery{{RefID: "A"}, {RefID: "B"}})
+	rows, err := db.QueryContext(withQueryState(ctx, "A"), "SELECT n FROM t")
+	if assert.Nil(t, err) {
+		assert.Nil(t, rows.Close())
+	}
+	assert.Equal(t, int32(3), requests.Load())
+	frame := data.NewFrame("A")
+	withStateStats(frame, queryStateFor(ctx, "A"))
+	assert.Equal(t, "Retries", frame.Meta.Stats[len(frame.Meta.Stats)-1].DisplayName)
+	assert.Equal(t, 2.0, frame.Meta.Stats[len(frame.Meta.Stats)-1].Value)
+
+	// queries that write are not run again
+	failures.Store(1)
+	_, err = db.QueryContext(withQueryState(ctx, "B"), "INSERT INTO t VALUES (1)")
+	assert.NotNil(t, err)
+	assert.Equal(t, int32(4), requests.Load())
+}
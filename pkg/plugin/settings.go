@@ -11,23 +11,46 @@ import (
 
 // Settings - data loaded from grafana settings database
 type Settings struct {
-	Server                    string `json:"server,omitempty"`
-	Port                      int64  `json:"port,omitempty"`
-	Username                  string `json:"username,omitempty"`
-	DefaultDatabase           string `json:"defaultDatabase,omitempty"`
-	InsecureSkipVerify        bool   `json:"tlsSkipVerify,omitempty"`
-	TlsClientAuth             bool   `json:"tlsAuth,omitempty"`
-	TlsAuthWithCACert         bool   `json:"tlsAuthWithCACert,omitempty"`
-	Password                  string `json:"-,omitempty"`
-	TlsCACert                 string
-	TlsClientCert             string
-	TlsClientKey              string
-	Secure                    bool            `json:"secure,omitempty"`
-	Timezone                  string          `json:"timezone,omitempty"`
-	Timeout                   string          `json:"timeout,omitempty"`
-	EnableLogsMapFieldFlatten bool            `json:"enableLogsMapFieldFlatten,omitempty"`
-	QueryTimeout              string          `json:"queryTimeout,omitempty"`
-	CustomSettings            []CustomSetting `json:"customSettings"`
+	Server                        string `json:"server,omitempty"`
+	Port                          int64  `json:"port,omitempty"`
+	Username                      string `json:"username,omitempty"`
+	DefaultDatabase               string `json:"defaultDatabase,omitempty"`
+	InsecureSkipVerify            bool   `json:"tlsSkipVerify,omitempty"`
+	TlsClientAuth                 bool   `json:"tlsAuth,omitempty"`
+	TlsAuthWithCACert             bool   `json:"tlsAuthWithCACert,omitempty"`
+	Password                      string `json:"-,omitempty"`
+	TlsCACert                     string
+	TlsClientCert                 string
+	TlsClientKey                  string
+	Secure                        bool            `json:"secure,omitempty"`
+	Timezone                      string          `json:"timezone,omitempty"`
+	Timeout                       string          `json:"timeout,omitempty"`
+	EnableLogsMapFieldFlatten     bool            `json:"enableLogsMapFieldFlatten,omitempty"`
+	LogsMapFieldFlattenSampleRows int64           `json:"logsMapFieldFlattenSampleRows,omitempty"`
+	LogsMapFieldFlattenMaxDepth   int64           `json:"logsMapFieldFlattenMaxDepth,omitempty"`
+	LogsMapFieldFlattenStrict     bool            `json:"logsMapFieldFlattenStrict,omitempty"`
+	EnableLogsEnrichment          bool            `json:"enableLogsEnrichment,omitempty"`
+	LogsLevelColumns              string          `json:"logsLevelColumns,omitempty"`
+	LogsLevelPattern              string          `json:"logsLevelPattern,omitempty"`
+	LogsBodyColumn                string          `json:"logsBodyColumn,omitempty"`
+	LogsLabelsMaxCardinality      int64           `json:"logsLabelsMaxCardinality,omitempty"`
+	LogsLabelColumns              string          `json:"logsLabelColumns,omitempty"`
+	LogsContextTieBreaker         string          `json:"logsContextTieBreaker,omitempty"`
+	LogsTailMinInterval           int64           `json:"logsTailMinInterval,omitempty"`
+	LogsTailMaxStreams            int64           `json:"logsTailMaxStreams,omitempty"`
+	MaxResultRows                 int64           `json:"maxResultRows,omitempty"`
+	MaxResultBytes                int64           `json:"maxResultBytes,omitempty"`
+	LockResultLimits              bool            `json:"lockResultLimits,omitempty"`
+	MaxConcurrentQueries          int64           `json:"maxConcurrentQueries,omitempty"`
+	MaxQueuedQueries              int64           `json:"maxQueuedQueries,omitempty"`
+	EnableResultCache             bool            `json:"enableResultCache,omitempty"`
+	ResultCacheTTL                int64           `json:"resultCacheTTL,omitempty"`
+	ResultCacheMaxBytes           int64           `json:"resultCacheMaxBytes,omitempty"`
+	IncrementalCacheOverlap       int64           `json:"incrementalCacheOverlap,omitempty"`
+	MaxQueryRetries               int64           `json:"maxQueryRetries,omitempty"`
+	QueryTimeout                  string          `json:"queryTimeout,omitempty"`
+	CustomSettings                []CustomSetting `json:"customSettings"`
+	TypeOverrides                 []TypeOverride  `json:"typeOverrides"`
 }
 
 type CustomSetting struct {
@@ -35,6 +58,12 @@ type CustomSetting struct {
 	Value   string `json:"value"`
 }
 
+// TypeOverride renders the Databend types matching Pattern as fields of FieldType, e.g. UInt8 as bool.
+type TypeOverride struct {
+	Pattern   string `json:"pattern"`
+	FieldType string `json:"fieldType"`
+}
+
 func (settings *Settings) isValid() (err error) {
 	if settings.Server == "" {
 		return ErrorMessageInvalidServerName
@@ -55,15 +84,8 @@ func LoadSettings(config backend.DataSourceInstanceSettings) (settings Settings,
 	if jsonData["server"] != nil {
 		settings.Server = jsonData["server"].(string)
 	}
-	if jsonData["port"] != nil {
-		if port, ok := jsonData["port"].(string); ok {
-			settings.Port, err = strconv.ParseInt(port, 0, 64)
-			if err != nil {
-				return settings, fmt.Errorf("could not parse port value: %w", err)
-			}
-		} else {
-			settings.Port = int64(jsonData["port"].(float64))
-		}
+	if settings.Port, err = parseNumberSetting(jsonData, "port", 0); err != nil {
+		return settings, err
 	}
 	if jsonData["username"] != nil {
 		settings.Username = jsonData["username"].(string)
@@ -121,6 +143,73 @@ func LoadSettings(config backend.DataSourceInstanceSettings) (settings Settings,
 	if jsonData["enableLogsMapFieldFlatten"] != nil {
 		settings.EnableLogsMapFieldFlatten = jsonData["enableLogsMapFieldFlatten"].(bool)
 	}
+	if settings.LogsMapFieldFlattenSampleRows, err = parseNumberSetting(jsonData, "logsMapFieldFlattenSampleRows", 0); err != nil {
+		return settings, err
+	}
+	if settings.LogsMapFieldFlattenMaxDepth, err = parseNumberSetting(jsonData, "logsMapFieldFlattenMaxDepth", 0); err != nil {
+		return settings, err
+	}
+	if jsonData["logsMapFieldFlattenStrict"] != nil {
+		settings.LogsMapFieldFlattenStrict = jsonData["logsMapFieldFlattenStrict"].(bool)
+	}
+
+	if jsonData["enableLogsEnrichment"] != nil {
+		settings.EnableLogsEnrichment = jsonData["enableLogsEnrichment"].(bool)
+	}
+	if jsonData["logsLevelColumns"] != nil {
+		settings.LogsLevelColumns = jsonData["logsLevelColumns"].(string)
+	}
+	if jsonData["logsLevelPattern"] != nil {
+		settings.LogsLevelPattern = jsonData["logsLevelPattern"].(string)
+	}
+	if jsonData["logsBodyColumn"] != nil {
+		settings.LogsBodyColumn = jsonData["logsBodyColumn"].(string)
+	}
+	if settings.LogsLabelsMaxCardinality, err = parseNumberSetting(jsonData, "logsLabelsMaxCardinality", defaultLogsLabelsMaxCardinality); err != nil {
+		return settings, err
+	}
+	if jsonData["logsLabelColumns"] != nil {
+		settings.LogsLabelColumns = jsonData["logsLabelColumns"].(string)
+	}
+	if jsonData["logsContextTieBreaker"] != nil {
+		settings.LogsContextTieBreaker = jsonData["logsContextTieBreaker"].(string)
+	}
+	if settings.LogsTailMinInterval, err = parseNumberSetting(jsonData, "logsTailMinInterval", 0); err != nil {
+		return settings, err
+	}
+	if settings.LogsTailMaxStreams, err = parseNumberSetting(jsonData, "logsTailMaxStreams", 0); err != nil {
+		return settings, err
+	}
+	if settings.MaxResultRows, err = parseNumberSetting(jsonData, "maxResultRows", 0); err != nil {
+		return settings, err
+	}
+	if settings.MaxResultBytes, err = parseNumberSetting(jsonData, "maxResultBytes", 0); err != nil {
+		return settings, err
+	}
+	if jsonData["lockResultLimits"] != nil {
+		settings.LockResultLimits = jsonData["lockResultLimits"].(bool)
+	}
+	if settings.MaxConcurrentQueries, err = parseNumberSetting(jsonData, "maxConcurrentQueries", 0); err != nil {
+		return settings, err
+	}
+	if settings.MaxQueuedQueries, err = parseNumberSetting(jsonData, "maxQueuedQueries", 0); err != nil {
+		return settings, err
+	}
+	if jsonData["enableResultCache"] != nil {
+		settings.EnableResultCache = jsonData["enableResultCache"].(bool)
+	}
+	if settings.ResultCacheTTL, err = parseNumberSetting(jsonData, "resultCacheTTL", 0); err != nil {
+		return settings, err
+	}
+	if settings.ResultCacheMaxBytes, err = parseNumberSetting(jsonData, "resultCacheMaxBytes", 0); err != nil {
+		return settings, err
+	}
+	if settings.IncrementalCacheOverlap, err = parseNumberSetting(jsonData, "incrementalCacheOverlap", 0); err != nil {
+		return settings, err
+	}
+	if settings.MaxQueryRetries, err = parseNumberSetting(jsonData, "maxQueryRetries", defaultMaxQueryRetries); err != nil {
+		return settings, err
+	}
 
 	if jsonData["customSettings"] != nil {
 		customSettingsRaw := jsonData["customSettings"].([]interface{})
@@ -137,6 +226,26 @@ func LoadSettings(config backend.DataSourceInstanceSettings) (settings Settings,
 		settings.CustomSettings = customSettings
 	}
 
+	if jsonData["typeOverrides"] != nil {
+		typeOverridesRaw, ok := jsonData["typeOverrides"].([]interface{})
+		if !ok {
+			return settings, fmt.Errorf("%w: typeOverrides must be a list", ErrorInvalidTypeOverride)
+		}
+		typeOverrides := make([]TypeOverride, len(typeOverridesRaw))
+
+		for i, raw := range typeOverridesRaw {
+			rawMap, _ := raw.(map[string]interface{})
+			pattern, patternOk := rawMap["pattern"].(string)
+			fieldType, fieldTypeOk := rawMap["fieldType"].(string)
+			if !patternOk || !fieldTypeOk {
+				return settings, fmt.Errorf("%w: type override %d needs a pattern and a fieldType", ErrorInvalidTypeOverride, i+1)
+			}
+			typeOverrides[i] = TypeOverride{Pattern: pattern, FieldType: fieldType}
+		}
+
+		settings.TypeOverrides = typeOverrides
+	}
+
 	if strings.TrimSpace(settings.Timeout) == "" {
 		settings.Timeout = "10"
 	}
@@ -162,3 +271,22 @@ func LoadSettings(config backend.DataSourceInstanceSettings) (settings Settings,
 
 	return settings, settings.isValid()
 }
+
+// parseNumberSetting reads a numeric setting of the JSON data, a number or the text of one as older config editors
+// stored it. It returns the default when the setting is not set.
+func parseNumberSetting(jsonData map[string]interface{}, key string, defaultValue int64) (int64, error) {
+	switch v := jsonData[key].(type) {
+	case nil:
+		return defaultValue, nil
+	case float64:
+		return int64(v), nil
+	case string:
+		n, err := strconv.ParseInt(v, 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("could not parse %s value: %w", key, err)
+		}
+		return n, nil
+	default:
+		return 0, fmt.Errorf("could not parse %s value: %v is not a number", key, v)
+	}
+}
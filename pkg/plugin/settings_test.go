@@ -1,12 +1,17 @@
 package plugin
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"reflect"
 	"testing"
 
+	godatabend "github.com/databendcloud/databend-go"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -26,26 +31,48 @@ func TestLoadSettings(t *testing.T) {
 				args: args{
 					config: backend.DataSourceInstanceSettings{
 						UID:                     "ds-uid",
-						JSONData:                []byte(`{ "server": "foo", "port": 443, "username": "baz", "defaultDatabase":"example", "tlsSkipVerify": true, "tlsAuth" : true, "tlsAuthWithCACert": true, "timeout": "10","timezone":"Aisa/Shanghai","enableLogsMapFieldFlatten":true}`),
+						JSONData:                []byte(`{ "server": "foo", "port": 443, "username": "baz", "defaultDatabase":"example", "tlsSkipVerify": true, "tlsAuth" : true, "tlsAuthWithCACert": true, "timeout": "10","timezone":"Aisa/Shanghai","enableLogsMapFieldFlatten":true,"logsMapFieldFlattenSampleRows":100,"logsMapFieldFlattenMaxDepth":"3","logsMapFieldFlattenStrict":true,"enableLogsEnrichment":true,"logsLevelColumns":"level,severity","logsLevelPattern":"level=(\\w+)","logsBodyColumn":"msg","logsLabelsMaxCardinality":"5","logsLabelColumns":"service,host","logsContextTieBreaker":"id","logsTailMinInterval":"2","logsTailMaxStreams":4,"maxResultRows":"100000","maxResultBytes":1048576,"lockResultLimits":true,"maxConcurrentQueries":"8","maxQueuedQueries":32,"enableResultCache":true,"resultCacheTTL":"30","resultCacheMaxBytes":16777216,"incrementalCacheOverlap":"120","maxQueryRetries":"4"}`),
 						DecryptedSecureJSONData: map[string]string{"password": "bar", "tlsCACert": "caCert", "tlsClientCert": "clientCert", "tlsClientKey": "clientKey"},
 					},
 				},
 				wantSettings: Settings{
-					Server:                    "foo",
-					Port:                      443,
-					Username:                  "baz",
-					DefaultDatabase:           "example",
-					InsecureSkipVerify:        true,
-					TlsClientAuth:             true,
-					TlsAuthWithCACert:         true,
-					Password:                  "bar",
-					TlsCACert:                 "caCert",
-					TlsClientCert:             "clientCert",
-					TlsClientKey:              "clientKey",
-					Timeout:                   "10",
-					QueryTimeout:              "60",
-					Timezone:                  "Aisa/Shanghai",
-					EnableLogsMapFieldFlatten: true,
+					Server:                        "foo",
+					Port:                          443,
+					Username:                      "baz",
+					DefaultDatabase:               "example",
+					InsecureSkipVerify:            true,
+					TlsClientAuth:                 true,
+					TlsAuthWithCACert:             true,
+					Password:                      "bar",
+					TlsCACert:                     "caCert",
+					TlsClientCert:                 "clientCert",
+					TlsClientKey:                  "clientKey",
+					Timeout:                       "10",
+					QueryTimeout:                  "60",
+					Timezone:                      "Aisa/Shanghai",
+					EnableLogsMapFieldFlatten:     true,
+					LogsMapFieldFlattenSampleRows: 100,
+					LogsMapFieldFlattenMaxDepth:   3,
+					LogsMapFieldFlattenStrict:     true,
+					EnableLogsEnrichment:          true,
+					LogsLevelColumns:              "level,severity",
+					LogsLevelPattern:              `level=(\w+)`,
+					LogsBodyColumn:                "msg",
+					LogsLabelsMaxCardinality:      5,
+					LogsLabelColumns:              "service,host",
+					LogsContextTieBreaker:         "id",
+					LogsTailMinInterval:           2,
+					LogsTailMaxStreams:            4,
+					MaxResultRows:                 100000,
+					MaxResultBytes:                1048576,
+					LockResultLimits:              true,
+					MaxConcurrentQueries:          8,
+					MaxQueuedQueries:              32,
+					EnableResultCache:             true,
+					ResultCacheTTL:                30,
+					ResultCacheMaxBytes:           16777216,
+					IncrementalCacheOverlap:       120,
+					MaxQueryRetries:               4,
 				},
 				wantErr: nil,
 			},
@@ -58,14 +85,38 @@ func TestLoadSettings(t *testing.T) {
 					},
 				},
 				wantSettings: Settings{
-					Server:             "test",
-					Port:               443,
-					InsecureSkipVerify: true,
-					TlsClientAuth:      true,
-					TlsAuthWithCACert:  true,
-					Timeout:            "10",
-					QueryTimeout:       "60",
-					Timezone:           "Aisa/Shanghai",
+					Server:                   "test",
+					Port:                     443,
+					InsecureSkipVerify:       true,
+					TlsClientAuth:            true,
+					TlsAuthWithCACert:        true,
+					Timeout:                  "10",
+					QueryTimeout:             "60",
+					LogsLabelsMaxCardinality: 10,
+					MaxQueryRetries:          2,
+					Timezone:                 "Aisa/Shanghai",
+				},
+				wantErr: nil,
+			},
+			{
+				name: "should parse type overrides",
+				args: args{
+					config: backend.DataSourceInstanceSettings{
+						JSONData:                []byte(`{"server": "test", "port": 8000, "typeOverrides": [{"pattern": "UInt8", "fieldType": "bool"}, {"pattern": "Date", "fieldType": "string"}]}`),
+						DecryptedSecureJSONData: map[string]string{},
+					},
+				},
+				wantSettings: Settings{
+					Server:                   "test",
+					Port:                     8000,
+					Timeout:                  "10",
+					QueryTimeout:             "60",
+					LogsLabelsMaxCardinality: 10,
+					MaxQueryRetries:          2,
+					TypeOverrides: []TypeOverride{
+						{Pattern: "UInt8", FieldType: "bool"},
+						{Pattern: "Date", FieldType: "string"},
+					},
 				},
 				wantErr: nil,
 			},
@@ -90,6 +141,9 @@ func TestLoadSettings(t *testing.T) {
 			{jsonData: `{ "server": "", "port": 443 }`, password: "", wantErr: ErrorMessageInvalidServerName, description: "should capture empty server name"},
 			{jsonData: `{ "server": "foo" }`, password: "", wantErr: ErrorMessageInvalidPort, description: "should capture nil port"},
 			{jsonData: `  "server": "foo", "port": 443, "username" : "foo" }`, password: "", wantErr: ErrorMessageInvalidJSON, description: "should capture invalid json"},
+			{jsonData: `{ "server": "foo", "port": 443, "typeOverrides": {"pattern": "UInt8"} }`, password: "", wantErr: ErrorInvalidTypeOverride, description: "should capture type overrides not in a list"},
+			{jsonData: `{ "server": "foo", "port": 443, "typeOverrides": [{"pattern": "UInt8"}] }`, password: "", wantErr: ErrorInvalidTypeOverride, description: "should capture a type override without field type"},
+			{jsonData: `{ "server": "foo", "port": 443, "typeOverrides": [null] }`, password: "", wantErr: ErrorInvalidTypeOverride, description: "should capture a null type override"},
 		}
 		for i, tc := range tests {
 			t.Run(fmt.Sprintf("[%v/%v] %s", i+1, len(tests), tc.description), func(t *testing.T) {
@@ -104,3 +158,53 @@ func TestLoadSettings(t *testing.T) {
 		}
 	})
 }
+
+func TestParseNumberSetting(t *testing.T) {
+	var jsonData map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(`{"a": 5, "b": "0x10", "c": null, "d": "five", "e": true}`), &jsonData))
+	for key, want := range map[string]int64{"a": 5, "b": 16, "c": 7, "missing": 7} {
+		got, err := parseNumberSetting(jsonData, key, 7)
+		assert.Nil(t, err, key)
+		assert.Equal(t, want, got, key)
+	}
+	_, err := parseNumberSetting(jsonData, "d", 7)
+	assert.ErrorContains(t, err, "could not parse d value")
+	_, err = parseNumberSetting(jsonData, "e", 7)
+	assert.ErrorContains(t, err, "could not parse e value")
+}
+
+func TestNewDatabendTypeOverrides(t *testing.T) {
+	d, err := NewDatabend(Settings{TypeOverrides: []TypeOverride{{Pattern: "UInt8", FieldType: "bool"}}})
+	assert.Nil(t, err)
+	assert.Equal(t, "UInt8", d.Converters()[0].Name)
+	assert.Equal(t, data.FieldTypeNullableBool, d.Converters()[0].FrameConverter.FieldType)
+
+	_, err = NewDatabend(Settings{TypeOverrides: []TypeOverride{{Pattern: "UInt8", FieldType: "bit"}}})
+	assert.NotNil(t, err)
+	_, err = NewDatabend(Settings{TypeOverrides: []TypeOverride{{Pattern: "UInt8(", FieldType: "bool"}}})
+	assert.NotNil(t, err)
+}
+
+func TestConnectKeepsDriverSettings(t *testing.T) {
+	server := newTestServer(t, godatabend.QueryResponse{})
+	defer server.Close()
+	ds := newTestDatasource(t, server, `"maxResultRows":"10","enableLogsEnrichment":true`)
+	logs := ds.driver.logs
+
+	// sqlds connects again while queries read the driver
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = ds.driver.MutateResponse(context.Background(), data.Frames{data.NewFrame("A")})
+	}()
+	u, err := url.Parse(server.URL)
+	assert.Nil(t, err)
+	_, err = ds.driver.Connect(backend.DataSourceInstanceSettings{
+		DecryptedSecureJSONData: map[string]string{"password": "databend"},
+		JSONData:                []byte(fmt.Sprintf(`{"server":%q,"port":%s,"username":"databend"}`, u.Hostname(), u.Port())),
+	}, nil)
+	assert.Nil(t, err)
+	<-done
+	assert.Equal(t, int64(10), ds.driver.ResultLimits.MaxRows)
+	assert.Same(t, logs, ds.driver.logs)
+}
@@ -0,0 +1,75 @@
+package plugin
+
+import (
+	"time"
+
+	godatabend "github.com/databendcloud/databend-go"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// withStateStats adds the stats recorded in the state of a query to its frame.
+func withStateStats(frame *data.Frame, state *queryState) {
+	queryID, stats := state.queryStats()
+	withQueryStats(frame, queryID, stats)
+	if depth, wait, ok := state.queue(); ok {
+		withQueueStats(frame, depth, wait)
+	}
+	if retries := state.retryCount(); retries > 0 {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		frame.Meta.Stats = append(frame.Meta.Stats, queryStat("Retries", "short", float64(retries)))
+	}
+}
+
+// withQueueStats adds how long a query waited for the datasource to run it, behind how many queries.
+func withQueueStats(frame *data.Frame, depth int, wait time.Duration) {
+	if frame.Meta == nil {
+		frame.Meta = &data.FrameMeta{}
+	}
+	frame.Meta.Stats = append(frame.Meta.Stats,
+		queryStat("Queue depth", "short", float64(depth)),
+		queryStat("Queue wait", "ms", float64(wait)/float64(time.Millisecond)),
+	)
+}
+
+// withQueryStats adds the stats Databend returned for a query to the meta of its frame, where the query
+// inspector shows them, and its id, to look the query up in system.query_log.
+func withQueryStats(frame *data.Frame, queryID string, stats *godatabend.QueryStats) {
+	if stats == nil {
+		return
+	}
+	if frame.Meta == nil {
+		frame.Meta = &data.FrameMeta{}
+	}
+	frame.Meta.Stats = append(frame.Meta.Stats,
+		queryStat("Rows read", "short", float64(stats.ScanProgress.Rows)),
+		queryStat("Bytes read", "decbytes", float64(stats.ScanProgress.Bytes)),
+		queryStat("Server elapsed", "ms", stats.RunningTimeMS),
+		queryStat("Result rows", "short", float64(stats.ResultProgress.Rows)),
+		queryStat("Result bytes", "decbytes", float64(stats.ResultProgress.Bytes)),
+	)
+	if queryID != "" {
+		withCustomMeta(frame, "queryId", queryID)
+	}
+}
+
+func queryStat(name, unit string, value float64) data.QueryStat {
+	return data.QueryStat{FieldConfig: data.FieldConfig{DisplayName: name, Unit: unit}, Value: value}
+}
+
+// withCustomMeta sets a key of the custom meta of the frame, custom meta of another shape is left as it is.
+func withCustomMeta(frame *data.Frame, key string, value interface{}) {
+	if frame.Meta == nil {
+		frame.Meta = &data.FrameMeta{}
+	}
+	custom, ok := frame.Meta.Custom.(map[string]interface{})
+	if !ok {
+		if frame.Meta.Custom != nil {
+			return
+		}
+		custom = map[string]interface{}{}
+	}
+	custom[key] = value
+	frame.Meta.Custom = custom
+}
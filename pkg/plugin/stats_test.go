@@ -0,0 +1,77 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	godatabend "github.com/databendcloud/databend-go"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cadl/grafana-databend-datasource/pkg/converters"
+)
+
+func TestQueryStats(t *testing.T) {
+	server := newTestServer(t,
+		godatabend.QueryResponse{
+			ID:      "q-1",
+			Schema:  []godatabend.DataField{{Name: "n", Type: "UInt64"}},
+			Data:    [][]string{{"1"}},
+			Stats:   godatabend.QueryStats{RunningTimeMS: 3, ScanProgress: godatabend.QueryProgress{Rows: 10, Bytes: 80}},
+			NextURI: "/page/1",
+		},
+		godatabend.QueryResponse{
+			ID:   "q-1",
+			Data: [][]string{{"2"}},
+			Stats: godatabend.QueryStats{
+				RunningTimeMS:  12.5,
+				ScanProgress:   godatabend.QueryProgress{Rows: 20, Bytes: 160},
+				ResultProgress: godatabend.QueryProgress{Rows: 2, Bytes: 16},
+			},
+		},
+	)
+	defer server.Close()
+	db := openTestDB(t, server)
+
+	ctx := withQueryStates(context.Background(), []backend.DataQuery{{RefID: "A"}})
+	rows, err := db.QueryContext(withQueryState(ctx, "A"), "SELECT n FROM t")
+	if !assert.Nil(t, err) {
+		return
+	}
+	frame, err := sqlutil.FrameFromRows(rows, -1, converters.GetConverters()...)
+	assert.Nil(t, err)
+	assert.Nil(t, rows.Close())
+	frame.Name = "A"
+	frame.SetMeta(&data.FrameMeta{ExecutedQueryString: "SELECT n FROM t"})
+
+	d, err := NewDatabend(Settings{})
+	assert.Nil(t, err)
+	res, err := d.MutateResponse(ctx, data.Frames{frame})
+	assert.Nil(t, err)
+
+	// the stats are those of the last page
+	meta := res[0].Meta
+	assert.Equal(t, "SELECT n FROM t", meta.ExecutedQueryString)
+	assert.Equal(t, []data.QueryStat{
+		queryStat("Rows read", "short", 20),
+		queryStat("Bytes read", "decbytes", 160),
+		queryStat("Server elapsed", "ms", 12.5),
+		queryStat("Result rows", "short", 2),
+		queryStat("Result bytes", "decbytes", 16),
+	}, meta.Stats)
+	assert.Equal(t, map[string]interface{}{"queryId": "q-1"}, meta.Custom)
+}
+
+func TestWithQueryStats(t *testing.T) {
+	frame := data.NewFrame("A")
+	withQueryStats(frame, "q-1", nil)
+	assert.Nil(t, frame.Meta)
+
+	// the id joins custom meta set already
+	frame.SetMeta(&data.FrameMeta{Custom: map[string]interface{}{"logsVolumeType": "FullRange"}})
+	withQueryStats(frame, "q-1", &godatabend.QueryStats{})
+	assert.Len(t, frame.Meta.Stats, 5)
+	assert.Equal(t, map[string]interface{}{"logsVolumeType": "FullRange", "queryId": "q-1"}, frame.Meta.Custom)
+}
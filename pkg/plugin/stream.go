@@ -0,0 +1,299 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"github.com/grafana/sqlds/v2"
+)
+
+const (
+	// logsTailPath is the prefix of the paths of live tails, tail/<hash of the query>
+	logsTailPath = "tail/"
+	// defaultLogsTailMinInterval and defaultLogsTailMaxStreams apply when the settings leave them out
+	defaultLogsTailMinInterval = 5 * time.Second
+	defaultLogsTailMaxStreams  = 10
+	// logsTailMaxRows caps the lines read by a poll, the lines after them are read by the next polls
+	logsTailMaxRows = 1000
+)
+
+func logsTailMinInterval(settings Settings) time.Duration {
+	if settings.LogsTailMinInterval <= 0 {
+		return defaultLogsTailMinInterval
+	}
+	return time.Duration(settings.LogsTailMinInterval) * time.Second
+}
+
+func logsTailMaxStreams(settings Settings) int {
+	if settings.LogsTailMaxStreams <= 0 {
+		return defaultLogsTailMaxStreams
+	}
+	return int(settings.LogsTailMaxStreams)
+}
+
+// TailOptions control the live tail of a logs query.
+type TailOptions struct {
+	// TimeColumn is the column new lines are found by, the first time column when empty
+	TimeColumn string `json:"timeColumn,omitempty"`
+	// Interval is the number of seconds between two polls, the minimum interval of the datasource when lower
+	Interval int `json:"interval,omitempty"`
+}
+
+// tailQuery is the query a live tail is subscribed with.
+type tailQuery struct {
+	RawSQL string      `json:"rawSql"`
+	Tail   TailOptions `json:"tail"`
+}
+
+func parseTailQuery(path string, raw json.RawMessage) (tailQuery, error) {
+	var q tailQuery
+	if !strings.HasPrefix(path, logsTailPath) {
+		return q, fmt.Errorf("unknown stream %s", path)
+	}
+	if err := json.Unmarshal(raw, &q); err != nil {
+		return q, fmt.Errorf("invalid live tail query: %w", err)
+	}
+	if strings.TrimSpace(q.RawSQL) == "" {
+		return q, fmt.Errorf("live tail needs a query")
+	}
+	return q, nil
+}
+
+// streamLimiter counts the streams a datasource runs.
+type streamLimiter struct {
+	mu      sync.Mutex
+	running int
+}
+
+// acquire counts a new stream unless max streams run already.
+func (l *streamLimiter) acquire(max int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.running >= max {
+		return false
+	}
+	l.running++
+	return true
+}
+
+func (l *streamLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.running--
+}
+
+func (l *streamLimiter) full(max int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.running >= max
+}
+
+func (ds *Datasource) SubscribeStream(ctx context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	if !strings.HasPrefix(req.Path, logsTailPath) {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+	if _, err := parseTailQuery(req.Path, req.Data); err != nil {
+		return nil, err
+	}
+	if ds.tails.full(ds.driver.LogsTailMaxStreams) {
+		return nil, fmt.Errorf("the datasource runs %d live tails already", ds.driver.LogsTailMaxStreams)
+	}
+	return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusOK}, nil
+}
+
+func (ds *Datasource) PublishStream(ctx context.Context, req *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	return &backend.PublishStreamResponse{Status: backend.PublishStreamStatusPermissionDenied}, nil
+}
+
+// RunStream polls the query of a live tail until its last subscriber leaves, sending the lines that are new
+// since the previous poll.
+func (ds *Datasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	q, err := parseTailQuery(req.Path, req.Data)
+	if err != nil {
+		return err
+	}
+	if !ds.tails.acquire(ds.driver.LogsTailMaxStreams) {
+		return fmt.Errorf("the datasource runs %d live tails already", ds.driver.LogsTailMaxStreams)
+	}
+	defer ds.tails.release()
+
+	interval := time.Duration(q.Tail.Interval) * time.Second
+	if interval < ds.driver.LogsTailMinInterval {
+		interval = ds.driver.LogsTailMinInterval
+	}
+	tail := &logsTail{ds: ds, query: q.RawSQL, timeColumn: q.Tail.TimeColumn, interval: interval, from: time.Now().Add(-interval),
+		state: &queryState{}}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		frame, err := tail.poll(ctx, time.Now())
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if frame.Rows() > 0 {
+			if err := sender.SendFrame(frame, data.IncludeAll); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// logsTail reads the lines a logs query gets after a moving high-water mark on its time column.
+type logsTail struct {
+	ds         *Datasource
+	query      string
+	timeColumn string
+	interval   time.Duration
+	// from is the high-water mark, the time of the newest line sent
+	from time.Time
+	// sent are the keys of the lines sent with the time from. The next poll reads them again, lines of the
+	// same time can arrive after a poll.
+	sent map[string]bool
+	// fromRows are the lines of the time from read so far. When the tie-breaker of the datasource orders the
+	// lines of a time, the next poll skips them, so more lines than a poll reads can share a time.
+	fromRows int
+	// state is kept from one poll to the next, the frames of the tail keep the labels of its first lines
+	state *queryState
+}
+
+// poll returns the lines that are new since the previous poll.
+func (t *logsTail) poll(ctx context.Context, now time.Time) (*data.Frame, error) {
+	q := &sqlds.Query{RawSQL: t.query, TimeRange: backend.TimeRange{From: t.from, To: now}, Interval: t.interval}
+	rawSQL, err := sqlds.Interpolate(t.ds.driver, q)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", "Could not apply macros", err)
+	}
+	db, err := t.ds.GetDBFromQuery(q, t.ds.uid)
+	if err != nil {
+		return nil, err
+	}
+	if t.state != nil {
+		// the frames of the tail are not named
+		ctx = withKeptQueryState(ctx, "", t.state)
+	}
+	if t.timeColumn == "" {
+		names, types, err := queryColumns(ctx, db, rawSQL)
+		if err != nil {
+			return nil, err
+		}
+		if t.timeColumn, err = findTimeColumn(names, types, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	column := quoteIdentifier(t.timeColumn)
+	order, offset := column, ""
+	if tieBreaker := t.ds.driver.LogsContextTieBreaker; tieBreaker != "" {
+		order += ", " + quoteIdentifier(tieBreaker)
+		if t.fromRows > 0 {
+			offset = fmt.Sprintf(" OFFSET %d", t.fromRows)
+		}
+	}
+	query := fmt.Sprintf("SELECT * FROM (%s) AS tail WHERE %s >= %s ORDER BY %s LIMIT %d%s",
+		trimQuery(rawSQL), column, timeLiteral(t.from, t.ds.driver.location), order, logsTailMaxRows, offset)
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	frame, err := sqlutil.FrameFromRows(rows, -1, t.ds.driver.Converters()...)
+	if err != nil {
+		return nil, err
+	}
+	if offset == "" {
+		// the lines of the time from are all read again
+		t.fromRows = 0
+	}
+	frame, err = t.newLines(frame)
+	if err != nil {
+		return nil, err
+	}
+	frame.SetMeta(&data.FrameMeta{ExecutedQueryString: query, PreferredVisualization: data.VisTypeLogs})
+	frames, err := t.ds.driver.MutateResponse(ctx, data.Frames{frame})
+	if err != nil {
+		return nil, err
+	}
+	return frames[0], nil
+}
+
+// newLines drops the lines sent already from the frame and moves the high-water mark to its newest line.
+func (t *logsTail) newLines(frame *data.Frame) (*data.Frame, error) {
+	timeField := findField(frame, []string{t.timeColumn})
+	if timeField == nil || !isTimeField(timeField) {
+		return nil, fmt.Errorf("time column %s is not in the result", t.timeColumn)
+	}
+	newFrame := frame.EmptyCopy()
+	from, sent, fromRows := t.from, make(map[string]bool, len(t.sent)), t.fromRows
+	for key := range t.sent {
+		sent[key] = true
+	}
+	for row := 0; row < frame.Rows(); row++ {
+		v, ok := timeField.ConcreteAt(row)
+		if !ok {
+			continue
+		}
+		ts := v.(time.Time)
+		values := frame.RowCopy(row)
+		key := rowKey(values)
+		if ts.After(from) {
+			from, sent, fromRows = ts, map[string]bool{}, 0
+		}
+		if ts.Equal(from) {
+			fromRows++
+		}
+		if ts.Equal(t.from) && t.sent[key] {
+			continue
+		}
+		newFrame.AppendRow(values...)
+		if ts.Equal(from) {
+			sent[key] = true
+		}
+	}
+	if frame.Rows() >= logsTailMaxRows && newFrame.Rows() == 0 {
+		// a whole poll of lines of the time from sent already, the next polls would read them again
+		backend.Logger.Warn("More log lines share a time than a live tail poll reads, the others are skipped",
+			"time", t.from, "lines", logsTailMaxRows)
+		from, sent, fromRows = t.from.Add(time.Microsecond), nil, 0
+	}
+	t.from, t.sent, t.fromRows = from, sent, fromRows
+	return newFrame, nil
+}
+
+// rowKey tells lines apart by all of their values.
+func rowKey(values []interface{}) string {
+	h := fnv.New64a()
+	for _, v := range values {
+		_, _ = fmt.Fprintf(h, "%v\x00", derefValue(v))
+	}
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// derefValue returns the value a nullable field value points to.
+func derefValue(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr {
+		return v
+	}
+	if rv.IsNil() {
+		return nil
+	}
+	return rv.Elem().Interface()
+}
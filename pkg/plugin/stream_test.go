@@ -0,0 +1,193 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	godatabend "github.com/databendcloud/databend-go"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTailQuery(t *testing.T) {
+	q, err := parseTailQuery("tail/abc", json.RawMessage(`{"rawSql":"SELECT * FROM logs","tail":{"timeColumn":"ts","interval":10}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, tailQuery{RawSQL: "SELECT * FROM logs", Tail: TailOptions{TimeColumn: "ts", Interval: 10}}, q)
+
+	_, err = parseTailQuery("tail/abc", json.RawMessage(`{"rawSql":" "}`))
+	assert.NotNil(t, err)
+	_, err = parseTailQuery("other", json.RawMessage(`{"rawSql":"SELECT 1"}`))
+	assert.NotNil(t, err)
+}
+
+func TestStreamLimiter(t *testing.T) {
+	var l streamLimiter
+	assert.True(t, l.acquire(2))
+	assert.True(t, l.acquire(2))
+	assert.True(t, l.full(2))
+	assert.False(t, l.acquire(2))
+	l.release()
+	assert.False(t, l.full(2))
+	assert.True(t, l.acquire(2))
+}
+
+// newTailTestServer answers the polls of a live tail with the pages in turn, and other queries with no rows.
+func newTailTestServer(t *testing.T, pages ...[][]string) (*httptest.Server, func() []string) {
+	var mu sync.Mutex
+	var polls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			SQL string `json:"sql"`
+		}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+		resp := godatabend.QueryResponse{
+			Schema: []godatabend.DataField{{Name: "ts", Type: "Timestamp"}, {Name: "msg", Type: "String"}},
+		}
+		mu.Lock()
+		if strings.Contains(req.SQL, "AS tail") {
+			resp.Data = pages[len(polls)]
+			polls = append(polls, req.SQL)
+		}
+		mu.Unlock()
+		assert.Nil(t, json.NewEncoder(w).Encode(resp))
+	}))
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return polls
+	}
+}
+
+func TestLogsTailPoll(t *testing.T) {
+	server, polls := newTailTestServer(t,
+		[][]string{{"2023-09-01 10:00:01.000000", "a"}, {"2023-09-01 10:00:02.000000", "b"}, {"2023-09-01 10:00:02.000000", "c"}},
+		// the lines of the boundary time are read again, with a line of that time that arrived since
+		[][]string{{"2023-09-01 10:00:02.000000", "b"}, {"2023-09-01 10:00:02.000000", "c"}, {"2023-09-01 10:00:02.000000", "d"}, {"2023-09-01 10:00:03.000000", "e"}},
+		[][]string{{"2023-09-01 10:00:03.000000", "e"}},
+	)
+	defer server.Close()
+	ds := newTestDatasource(t, server, `"timezone":"UTC"`)
+	tail := &logsTail{ds: ds, query: "SELECT * FROM logs WHERE $__timeFilter(ts) LIMIT 10;", timeColumn: "ts", interval: time.Second,
+		from: time.Date(2023, 9, 1, 10, 0, 0, 0, time.UTC)}
+
+	msgs := func(ctx context.Context) []string {
+		frame, err := tail.poll(ctx, time.Date(2023, 9, 1, 10, 1, 0, 0, time.UTC))
+		assert.Nil(t, err)
+		field := findField(frame, []string{"msg"})
+		var msgs []string
+		for row := 0; row < frame.Rows(); row++ {
+			msgs = append(msgs, stringAt(field, row))
+		}
+		return msgs
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, msgs(context.Background()))
+	assert.Equal(t, []string{"d", "e"}, msgs(context.Background()))
+	assert.Nil(t, msgs(context.Background()))
+
+	sqls := polls()
+	assert.Equal(t, "SELECT * FROM (SELECT * FROM logs WHERE ts >= '1693562400' AND ts <= '1693562460') AS tail "+
+		"WHERE `ts` >= '2023-09-01 10:00:00' ORDER BY `ts` LIMIT 1000", sqls[0])
+	// the high-water mark moves to the newest line
+	assert.Contains(t, sqls[1], "WHERE `ts` >= '2023-09-01 10:00:02' ORDER BY")
+	assert.Contains(t, sqls[2], "WHERE `ts` >= '2023-09-01 10:00:03' ORDER BY")
+}
+
+func TestLogsTailPollTieBreaker(t *testing.T) {
+	server, polls := newTailTestServer(t,
+		[][]string{{"2023-09-01 10:00:01.000000", "a"}, {"2023-09-01 10:00:02.000000", "b"}, {"2023-09-01 10:00:02.000000", "c"}},
+		[][]string{{"2023-09-01 10:00:02.000000", "d"}},
+		[][]string{{"2023-09-01 10:00:03.000000", "e"}},
+	)
+	defer server.Close()
+	ds := newTestDatasource(t, server, `"timezone":"UTC","logsContextTieBreaker":"msg"`)
+	tail := &logsTail{ds: ds, query: "SELECT * FROM logs", timeColumn: "ts", interval: time.Second,
+		from: time.Date(2023, 9, 1, 10, 0, 0, 0, time.UTC)}
+	for i := 0; i < 3; i++ {
+		_, err := tail.poll(context.Background(), time.Date(2023, 9, 1, 10, 1, 0, 0, time.UTC))
+		assert.Nil(t, err)
+	}
+
+	// the lines of the newest time read so far are skipped, they keep their order
+	sqls := polls()
+	assert.True(t, strings.HasSuffix(sqls[0], "ORDER BY `ts`, `msg` LIMIT 1000"), sqls[0])
+	assert.True(t, strings.HasSuffix(sqls[1], "WHERE `ts` >= '2023-09-01 10:00:02' ORDER BY `ts`, `msg` LIMIT 1000 OFFSET 2"), sqls[1])
+	assert.True(t, strings.HasSuffix(sqls[2], "WHERE `ts` >= '2023-09-01 10:00:02' ORDER BY `ts`, `msg` LIMIT 1000 OFFSET 3"), sqls[2])
+}
+
+func TestLogsTailPollFullPageOfOneTime(t *testing.T) {
+	page := make([][]string, logsTailMaxRows)
+	for i := range page {
+		page[i] = []string{"2023-09-01 10:00:02.000000", fmt.Sprintf("line %d", i)}
+	}
+	server, polls := newTailTestServer(t, page, page, [][]string{{"2023-09-01 10:00:03.000000", "e"}})
+	defer server.Close()
+	ds := newTestDatasource(t, server, `"timezone":"UTC"`)
+	tail := &logsTail{ds: ds, query: "SELECT * FROM logs", timeColumn: "ts", interval: time.Second,
+		from: time.Date(2023, 9, 1, 10, 0, 0, 0, time.UTC)}
+	rows := func() int {
+		frame, err := tail.poll(context.Background(), time.Date(2023, 9, 1, 10, 1, 0, 0, time.UTC))
+		assert.Nil(t, err)
+		return frame.Rows()
+	}
+	assert.Equal(t, logsTailMaxRows, rows())
+	// a poll reading only lines sent already moves past their time rather than reading them forever
+	assert.Equal(t, 0, rows())
+	assert.Equal(t, 1, rows())
+	assert.Contains(t, polls()[2], "WHERE `ts` >= '2023-09-01 10:00:02.000001' ORDER BY")
+}
+
+func TestSubscribeStream(t *testing.T) {
+	server, _ := newTailTestServer(t)
+	defer server.Close()
+	ds := newTestDatasource(t, server, `"logsTailMaxStreams":1`)
+
+	res, err := ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{Path: "other"})
+	assert.Nil(t, err)
+	assert.Equal(t, backend.SubscribeStreamStatusNotFound, res.Status)
+
+	req := &backend.SubscribeStreamRequest{Path: "tail/abc", Data: json.RawMessage(`{"rawSql":"SELECT * FROM logs"}`)}
+	res, err = ds.SubscribeStream(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, backend.SubscribeStreamStatusOK, res.Status)
+
+	// streams are capped per datasource
+	assert.True(t, ds.tails.acquire(ds.driver.LogsTailMaxStreams))
+	_, err = ds.SubscribeStream(context.Background(), req)
+	assert.NotNil(t, err)
+	err = ds.RunStream(context.Background(), &backend.RunStreamRequest{Path: req.Path, Data: req.Data}, nil)
+	assert.NotNil(t, err)
+}
+
+type testPacketSender struct {
+	packets []*backend.StreamPacket
+	sent    func()
+}
+
+func (s *testPacketSender) Send(packet *backend.StreamPacket) error {
+	s.packets = append(s.packets, packet)
+	s.sent()
+	return nil
+}
+
+func TestRunStream(t *testing.T) {
+	server, _ := newTailTestServer(t, [][]string{{"2023-09-01 10:00:01.000000", "a"}})
+	defer server.Close()
+	ds := newTestDatasource(t, server, `"logsTailMinInterval":1`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	packets := &testPacketSender{sent: cancel}
+	req := &backend.RunStreamRequest{Path: "tail/abc", Data: json.RawMessage(`{"rawSql":"SELECT * FROM logs","tail":{"timeColumn":"ts"}}`)}
+	assert.Nil(t, ds.RunStream(ctx, req, backend.NewStreamSender(packets)))
+	assert.Len(t, packets.packets, 1)
+	assert.Contains(t, string(packets.packets[0].Data), `"a"`)
+	// the stream is released when it ends
+	assert.False(t, ds.tails.full(1))
+}
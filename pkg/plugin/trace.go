@@ -0,0 +1,376 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// The fields of a Grafana trace frame.
+const (
+	traceFieldTraceID       = "traceID"
+	traceFieldSpanID        = "spanID"
+	traceFieldParentSpanID  = "parentSpanID"
+	traceFieldServiceName   = "serviceName"
+	traceFieldOperationName = "operationName"
+	traceFieldStartTime     = "startTime"
+	traceFieldDuration      = "duration"
+	traceFieldTags          = "tags"
+	traceFieldServiceTags   = "serviceTags"
+	traceFieldLogs          = "logs"
+)
+
+// traceFields are the trace frame fields in their order, with the column names they are found in when the
+// query does not map them, e.g. the columns of the OpenTelemetry exporter tables. Names are compared case
+// insensitively and without underscores.
+var traceFields = []struct {
+	name    string
+	aliases []string
+}{
+	{name: traceFieldTraceID, aliases: []string{"traceID", "TraceId"}},
+	{name: traceFieldSpanID, aliases: []string{"spanID", "SpanId"}},
+	{name: traceFieldParentSpanID, aliases: []string{"parentSpanID", "ParentSpanId"}},
+	{name: traceFieldServiceName, aliases: []string{"serviceName"}},
+	{name: traceFieldOperationName, aliases: []string{"operationName", "SpanName"}},
+	{name: traceFieldStartTime, aliases: []string{"startTime", "Timestamp"}},
+	{name: traceFieldDuration, aliases: []string{"duration"}},
+	{name: traceFieldTags, aliases: []string{"tags", "SpanAttributes"}},
+	{name: traceFieldServiceTags, aliases: []string{"serviceTags", "ResourceAttributes"}},
+	{name: traceFieldLogs, aliases: []string{"logs", "Events"}},
+}
+
+// durationUnits are the units a duration column can be in, in milliseconds.
+var durationUnits = map[string]float64{
+	"ns": 1e-6,
+	"us": 1e-3,
+	"ms": 1,
+	"s":  1e3,
+}
+
+// TraceOptions control how the result of a query in the trace format becomes a trace frame.
+type TraceOptions struct {
+	// Columns maps trace frame fields, e.g. traceID, to the columns holding them
+	Columns map[string]string `json:"columns,omitempty"`
+	// DurationUnit is the unit of the duration column, ms by default
+	DurationUnit string `json:"durationUnit,omitempty"`
+}
+
+func (o TraceOptions) validate() error {
+	for name := range o.Columns {
+		if !isTraceField(name) {
+			return fmt.Errorf("unknown trace field %q", name)
+		}
+	}
+	if _, ok := durationUnits[o.DurationUnit]; o.DurationUnit != "" && !ok {
+		return fmt.Errorf("unknown duration unit %q", o.DurationUnit)
+	}
+	return nil
+}
+
+func isTraceField(name string) bool {
+	for _, f := range traceFields {
+		if f.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeColumnName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", ""))
+}
+
+// traceColumns finds the field of the frame holding every trace field.
+func traceColumns(frame *data.Frame, opts TraceOptions) (map[string]*data.Field, error) {
+	byName := make(map[string]*data.Field, len(frame.Fields))
+	for _, field := range frame.Fields {
+		byName[normalizeColumnName(field.Name)] = field
+	}
+	columns := map[string]*data.Field{}
+	for _, f := range traceFields {
+		if column, ok := opts.Columns[f.name]; ok {
+			field, ok := byName[normalizeColumnName(column)]
+			if !ok {
+				return nil, fmt.Errorf("column %s of trace field %s is not in the result", column, f.name)
+			}
+			columns[f.name] = field
+			continue
+		}
+		for _, alias := range f.aliases {
+			if field, ok := byName[normalizeColumnName(alias)]; ok {
+				columns[f.name] = field
+				break
+			}
+		}
+	}
+	if columns[traceFieldTraceID] == nil || columns[traceFieldSpanID] == nil {
+		return nil, fmt.Errorf("trace results need a %s and a %s column", traceFieldTraceID, traceFieldSpanID)
+	}
+	return columns, nil
+}
+
+// traceFrame turns a span table into a Grafana trace frame. Columns that are no trace field, e.g. kind or
+// statusCode, are kept as they are.
+func traceFrame(frame *data.Frame, opts TraceOptions) (*data.Frame, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	columns, err := traceColumns(frame, opts)
+	if err != nil {
+		return nil, err
+	}
+	durationUnit := durationUnits["ms"]
+	if opts.DurationUnit != "" {
+		durationUnit = durationUnits[opts.DurationUnit]
+	}
+
+	rows := frame.Rows()
+	var fields []*data.Field
+	mapped := map[*data.Field]bool{}
+	for _, f := range traceFields {
+		column, ok := columns[f.name]
+		if !ok {
+			continue
+		}
+		mapped[column] = true
+		var field *data.Field
+		switch f.name {
+		case traceFieldStartTime:
+			field, err = traceMillisField(column, rows, 1)
+		case traceFieldDuration:
+			field, err = traceMillisField(column, rows, durationUnit)
+		case traceFieldTags, traceFieldServiceTags:
+			field, err = traceKeyValueField(column, rows)
+		case traceFieldLogs:
+			field, err = traceLogsField(column, rows)
+		default:
+			field = traceStringField(column, rows)
+		}
+		if err != nil {
+			return nil, err
+		}
+		field.Name = f.name
+		fields = append(fields, field)
+	}
+	for _, field := range frame.Fields {
+		if !mapped[field] {
+			fields = append(fields, field)
+		}
+	}
+
+	newFrame := data.NewFrame(frame.Name, fields...)
+	newFrame.SetMeta(frame.Meta)
+	return newFrame, nil
+}
+
+func traceStringField(column *data.Field, rows int) *data.Field {
+	values := make([]string, rows)
+	for row := range values {
+		if v, ok := column.ConcreteAt(row); ok {
+			values[row] = traceString(v)
+		}
+	}
+	return data.NewField("", column.Labels, values)
+}
+
+func traceString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.RawMessage:
+		var s string
+		if err := json.Unmarshal(v, &s); err == nil {
+			return s
+		}
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// traceMillisField returns times as epoch milliseconds, and numbers in the unit given in milliseconds.
+func traceMillisField(column *data.Field, rows int, unit float64) (*data.Field, error) {
+	values := make([]float64, rows)
+	for row := range values {
+		v, ok := column.ConcreteAt(row)
+		if !ok {
+			continue
+		}
+		ms, err := traceMillis(v, unit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of trace column %s: %w", column.Name, err)
+		}
+		values[row] = ms
+	}
+	return data.NewField("", column.Labels, values), nil
+}
+
+func traceMillis(v interface{}, unit float64) (float64, error) {
+	switch v := v.(type) {
+	case time.Time:
+		return float64(v.UnixMicro()) / 1e3, nil
+	case float64:
+		return v * unit, nil
+	case float32:
+		return float64(v) * unit, nil
+	case int64:
+		return float64(v) * unit, nil
+	case int32:
+		return float64(v) * unit, nil
+	case int16:
+		return float64(v) * unit, nil
+	case int8:
+		return float64(v) * unit, nil
+	case uint64:
+		return float64(v) * unit, nil
+	case uint32:
+		return float64(v) * unit, nil
+	case uint16:
+		return float64(v) * unit, nil
+	case uint8:
+		return float64(v) * unit, nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, err
+		}
+		return f * unit, nil
+	default:
+		return 0, fmt.Errorf("%v is no time or number", v)
+	}
+}
+
+// traceKeyValue is a tag of a span, the form the trace view expects.
+type traceKeyValue struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+// traceKeyValueField converts tags to key/value arrays. Maps, arrays of key/value maps, e.g. built with
+// map('key', k, 'value', v), and arrays of (key, value) tuples are understood.
+func traceKeyValueField(column *data.Field, rows int) (*data.Field, error) {
+	values := make([]json.RawMessage, rows)
+	for row := range values {
+		values[row] = json.RawMessage(`[]`)
+		v, ok := column.ConcreteAt(row)
+		if !ok {
+			continue
+		}
+		raw, ok := v.(json.RawMessage)
+		if !ok {
+			return nil, fmt.Errorf("trace column %s is not JSON", column.Name)
+		}
+		var decoded interface{}
+		if err := json.Unmarshal(raw, &decoded); err != nil {
+			return nil, fmt.Errorf("invalid value of trace column %s: %w", column.Name, err)
+		}
+		b, err := json.Marshal(traceKeyValues(decoded))
+		if err != nil {
+			return nil, err
+		}
+		values[row] = b
+	}
+	return data.NewField("", column.Labels, values), nil
+}
+
+func traceKeyValues(v interface{}) []traceKeyValue {
+	tags := []traceKeyValue{}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			tags = append(tags, traceKeyValue{Key: k, Value: v[k]})
+		}
+	case []interface{}:
+		for _, e := range v {
+			switch e := e.(type) {
+			case map[string]interface{}:
+				if k, ok := e["key"]; ok {
+					tags = append(tags, traceKeyValue{Key: traceString(k), Value: e["value"]})
+				} else if k, ok := e["Field0"]; ok {
+					// a tuple, as the driver returns it
+					tags = append(tags, traceKeyValue{Key: traceString(k), Value: e["Field1"]})
+				}
+			case []interface{}:
+				if len(e) == 2 {
+					tags = append(tags, traceKeyValue{Key: traceString(e[0]), Value: e[1]})
+				}
+			}
+		}
+	}
+	return tags
+}
+
+// traceLog is a span event, the form the trace view expects.
+type traceLog struct {
+	Timestamp float64         `json:"timestamp"`
+	Fields    []traceKeyValue `json:"fields"`
+}
+
+// traceLogsField converts span events, objects with a timestamp, an optional name and fields or attributes,
+// to trace logs.
+func traceLogsField(column *data.Field, rows int) (*data.Field, error) {
+	values := make([]json.RawMessage, rows)
+	for row := range values {
+		values[row] = json.RawMessage(`[]`)
+		v, ok := column.ConcreteAt(row)
+		if !ok {
+			continue
+		}
+		raw, ok := v.(json.RawMessage)
+		if !ok {
+			return nil, fmt.Errorf("trace column %s is not JSON", column.Name)
+		}
+		var events []map[string]interface{}
+		if err := json.Unmarshal(raw, &events); err != nil {
+			return nil, fmt.Errorf("invalid value of trace column %s: %w", column.Name, err)
+		}
+		logs := make([]traceLog, 0, len(events))
+		for _, event := range events {
+			log := traceLog{Fields: []traceKeyValue{}}
+			var attributes []traceKeyValue
+			for k, v := range event {
+				switch strings.ToLower(k) {
+				case "timestamp", "time":
+					log.Timestamp = traceEventMillis(v)
+				case "name":
+					log.Fields = append(log.Fields, traceKeyValue{Key: "event", Value: v})
+				case "fields", "attributes":
+					attributes = append(attributes, traceKeyValues(v)...)
+				}
+			}
+			log.Fields = append(log.Fields, attributes...)
+			logs = append(logs, log)
+		}
+		b, err := json.Marshal(logs)
+		if err != nil {
+			return nil, err
+		}
+		values[row] = b
+	}
+	return data.NewField("", column.Labels, values), nil
+}
+
+// traceEventMillis reads the timestamp of an event, a number of milliseconds or a time as text.
+func traceEventMillis(v interface{}) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999"} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return float64(t.UnixMicro()) / 1e3
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,79 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceFrameFromOpenTelemetryColumns(t *testing.T) {
+	start := time.Date(2023, 9, 1, 10, 0, 0, 0, time.UTC)
+	frame := data.NewFrame("A",
+		data.NewField("TraceId", nil, []string{"t1", "t1"}),
+		data.NewField("SpanId", nil, []string{"s1", "s2"}),
+		data.NewField("ParentSpanId", nil, []string{"", "s1"}),
+		data.NewField("ServiceName", nil, []string{"api", "db"}),
+		data.NewField("SpanName", nil, []string{"GET /", "SELECT"}),
+		data.NewField("Timestamp", nil, []time.Time{start, start.Add(time.Millisecond)}),
+		data.NewField("Duration", nil, []uint64{5000000, 2000000}),
+		jsonField("SpanAttributes", `{"http.method":"GET","http.status_code":"200"}`, ""),
+		jsonField("ResourceAttributes", `[{"key":"host","value":"h1"}]`, `[{"Field0":"host","Field1":"h2"}]`),
+		jsonField("Events", `[{"timestamp":"2023-09-01 10:00:00.002","name":"retry","attributes":{"attempt":"2"}}]`, ""),
+		data.NewField("StatusCode", nil, []string{"STATUS_CODE_OK", "STATUS_CODE_ERROR"}),
+	)
+	got, err := traceFrame(frame, TraceOptions{DurationUnit: "ns"})
+	assert.Nil(t, err)
+
+	names := make([]string, len(got.Fields))
+	for i, f := range got.Fields {
+		names[i] = f.Name
+	}
+	assert.Equal(t, []string{
+		"traceID", "spanID", "parentSpanID", "serviceName", "operationName",
+		"startTime", "duration", "tags", "serviceTags", "logs", "StatusCode",
+	}, names)
+	assert.Equal(t, "s1", got.Fields[2].At(1))
+	assert.Equal(t, float64(start.UnixMilli()), got.Fields[5].At(0))
+	assert.Equal(t, 5.0, got.Fields[6].At(0))
+	assert.JSONEq(t, `[{"key":"http.method","value":"GET"},{"key":"http.status_code","value":"200"}]`, string(got.Fields[7].At(0).(json.RawMessage)))
+	assert.JSONEq(t, `[]`, string(got.Fields[7].At(1).(json.RawMessage)))
+	assert.JSONEq(t, `[{"key":"host","value":"h2"}]`, string(got.Fields[8].At(1).(json.RawMessage)))
+	assert.JSONEq(t, `[{"timestamp":1693562400002,"fields":[{"key":"event","value":"retry"},{"key":"attempt","value":"2"}]}]`, string(got.Fields[9].At(0).(json.RawMessage)))
+}
+
+func TestTraceFrameColumnMapping(t *testing.T) {
+	frame := data.NewFrame("A",
+		data.NewField("tid", nil, []string{"t1"}),
+		data.NewField("sid", nil, []string{"s1"}),
+		data.NewField("ms", nil, []float64{1.5}),
+	)
+	got, err := traceFrame(frame, TraceOptions{Columns: map[string]string{"traceID": "tid", "spanID": "sid", "duration": "ms"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "traceID", got.Fields[0].Name)
+	assert.Equal(t, 1.5, got.Fields[2].At(0))
+
+	_, err = traceFrame(frame, TraceOptions{})
+	assert.NotNil(t, err)
+	_, err = traceFrame(frame, TraceOptions{Columns: map[string]string{"traceID": "missing", "spanID": "sid"}})
+	assert.NotNil(t, err)
+	_, err = traceFrame(frame, TraceOptions{Columns: map[string]string{"trace": "tid"}})
+	assert.NotNil(t, err)
+}
+
+func TestMutateResponseTraceFrame(t *testing.T) {
+	ctx := withQueryStates(context.Background(), []backend.DataQuery{
+		{RefID: "A", JSON: []byte(`{"format":3,"trace":{"columns":{"traceID":"tid"}}}`)},
+	})
+	frame := data.NewFrame("A", data.NewField("tid", nil, []string{"t1"}), data.NewField("spanID", nil, []string{"s1"}))
+	frame.SetMeta(&data.FrameMeta{PreferredVisualization: data.VisTypeTrace})
+
+	res, err := (&Databend{}).MutateResponse(ctx, data.Frames{frame})
+	assert.Nil(t, err)
+	assert.Equal(t, "traceID", res[0].Fields[0].Name)
+	assert.Equal(t, data.VisType(data.VisTypeTrace), res[0].Meta.PreferredVisualization)
+}
@@ -0,0 +1,10 @@
+import React from 'react';
+import { render } from '@testing-library/react';
+import { AsyncSwitch } from './AsyncSwitch';
+
+describe('AsyncSwitch', () => {
+  it('renders the switch', () => {
+    const result = render(<AsyncSwitch value={true} onChange={() => {}} />);
+    expect(result.container.firstChild).not.toBeNull();
+  });
+});
@@ -0,0 +1,10 @@
+import React from 'react';
+import { render } from '@testing-library/react';
+import { CacheSwitch } from './CacheSwitch';
+
+describe('CacheSwitch', () => {
+  it('renders the switch', () => {
+    const result = render(<CacheSwitch value={true} onChange={() => {}} />);
+    expect(result.container.firstChild).not.toBeNull();
+  });
+});
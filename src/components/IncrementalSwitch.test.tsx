@@ -0,0 +1,10 @@
+import React from 'react';
+import { render } from '@testing-library/react';
+import { IncrementalSwitch } from './IncrementalSwitch';
+
+describe('IncrementalSwitch', () => {
+  it('renders the switch', () => {
+    const result = render(<IncrementalSwitch value={true} onChange={() => {}} />);
+    expect(result.container.firstChild).not.toBeNull();
+  });
+});
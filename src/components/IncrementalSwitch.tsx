@@ -0,0 +1,18 @@
+import React from 'react';
+import { InlineFormLabel, Switch } from '@grafana/ui';
+import { selectors } from './../selectors';
+
+export type Props = { value: boolean; onChange: (value: boolean) => void };
+
+export const IncrementalSwitch = (props: Props) => {
+  const { onChange, value } = props;
+  const { label, tooltip } = selectors.components.QueryEditor.Incremental;
+  return (
+    <div className="gf-form">
+      <InlineFormLabel width={8} className="query-keyword" tooltip={tooltip}>
+        {label}
+      </InlineFormLabel>
+      <Switch value={value} onChange={(e) => onChange(e.currentTarget.checked)} />
+    </div>
+  );
+};
@@ -14,7 +14,7 @@ import { DataSourceWithBackend } from '@grafana/runtime';
 import { mockDatasource } from '__mocks__/datasource';
 import { BuilderMode, CHBuilderQuery, CHQuery, CHSQLQuery, Format, QueryType, SqlBuilderOptionsList } from 'types';
 import { cloneDeep } from 'lodash';
-import { Datasource } from './CHDatasource';
+import { Datasource, getLogContextTable, hashString } from './CHDatasource';
 import * as logs from './logs';
 
 jest.mock('./logs', () => ({
@@ -368,6 +368,7 @@ describe('DatabendDatasource', () => {
           datasource.getSupplementaryLogsVolumeQuery(request, {
             ...query,
             queryType: QueryType.SQL,
+            rawSql: '',
           })
         ).toBeUndefined();
         expect(
@@ -399,6 +400,19 @@ describe('DatabendDatasource', () => {
         ).toBeUndefined();
       });
 
+      it('should let the backend compute the volume of raw SQL queries', async () => {
+        const result = datasource.getSupplementaryLogsVolumeQuery(request, {
+          refId: 'A',
+          queryType: QueryType.SQL,
+          rawSql: 'SELECT * FROM logs',
+          format: Format.LOGS,
+          selectedFormat: Format.LOGS,
+        });
+        expect(result?.rawSql).toEqual('SELECT * FROM logs');
+        expect(result?.format).toEqual(Format.TABLE);
+        expect(result?.logsVolume).toEqual({});
+      });
+
       it('should render a basic query if we have no log level field set', async () => {
         jest
           .spyOn(logs, 'getTimeFieldRoundingClause')
@@ -496,4 +510,27 @@ describe('DatabendDatasource', () => {
       });
     });
   });
+
+  describe('getLogContextTable', () => {
+    const row = (executedQueryString?: string) =>
+      ({ dataFrame: toDataFrame({ fields: [], meta: { executedQueryString } }) } as any);
+
+    it('should return the table of the executed query', () => {
+      expect(getLogContextTable(row('SELECT * FROM "default"."logs" WHERE level = 1'))).toEqual('default.logs');
+      expect(getLogContextTable(row('select ts, msg from logs limit 10'))).toEqual('logs');
+    });
+
+    it('should return undefined without a table', () => {
+      expect(getLogContextTable(row())).toBeUndefined();
+      expect(getLogContextTable(row('SELECT * FROM (SELECT 1)'))).toBeUndefined();
+    });
+  });
+
+  describe('hashString', () => {
+    it('should hash equal strings alike', () => {
+      expect(hashString('SELECT * FROM logs')).toEqual(hashString('SELECT * FROM logs'));
+      expect(hashString('SELECT * FROM logs')).not.toEqual(hashString('SELECT * FROM events'));
+      expect(hashString('SELECT * FROM logs')).toMatch(/^[0-9a-f]+$/);
+    });
+  });
 });
@@ -1,21 +1,28 @@
 import {
   DataFrame,
+  dataFrameFromJSON,
+  DataFrameJSON,
   DataFrameView,
   DataQueryRequest,
   DataQueryResponse,
   DataSourceInstanceSettings,
+  DataSourceWithLogsContextSupport,
   DataSourceWithSupplementaryQueriesSupport,
+  FieldType,
   getTimeZone,
   getTimeZoneInfo,
+  LiveChannelScope,
+  LogRowModel,
   MetricFindValue,
   QueryFixAction,
+  RowContextOptions,
   ScopedVars,
   SupplementaryQueryType,
   TypedVariableModel,
   vectorator,
 } from '@grafana/data';
-import { DataSourceWithBackend, getTemplateSrv } from '@grafana/runtime';
-import { Observable } from 'rxjs';
+import { DataSourceWithBackend, getGrafanaLiveSrv, getTemplateSrv } from '@grafana/runtime';
+import { merge, Observable } from 'rxjs';
 import {
   BuilderMetricField,
   BuilderMetricFieldAggregation,
@@ -40,10 +47,11 @@ import {
   queryLogsVolume,
 } from './logs';
 import { getSQLFromQueryOptions } from '../components/queryBuilder/utils';
+import { runAsyncQueries } from './async';
 
 export class Datasource
   extends DataSourceWithBackend<CHQuery, CHConfig>
-  implements DataSourceWithSupplementaryQueriesSupport<CHQuery>
+  implements DataSourceWithSupplementaryQueriesSupport<CHQuery>, DataSourceWithLogsContextSupport
 {
   // This enables default annotation support for 7.2+
   annotations = {};
@@ -111,6 +119,15 @@ export class Datasource
   }
 
   getSupplementaryLogsVolumeQuery(logsVolumeRequest: DataQueryRequest<CHQuery>, query: CHQuery): CHQuery | undefined {
+    if (query.format === Format.LOGS && query.queryType === QueryType.SQL && query.rawSql) {
+      // the backend finds the time and level columns of raw SQL and counts the lines
+      return {
+        ...query,
+        format: Format.TABLE,
+        selectedFormat: Format.TABLE,
+        logsVolume: query.logsVolume ?? {},
+      };
+    }
     if (
       query.format !== Format.LOGS ||
       query.queryType !== QueryType.Builder ||
@@ -174,6 +191,32 @@ export class Datasource
     return undefined;
   }
 
+  showContextToggle(row?: LogRowModel): boolean {
+    return row === undefined || getLogContextTable(row) !== undefined;
+  }
+
+  async getLogRowContext(row: LogRowModel, options?: RowContextOptions): Promise<DataQueryResponse> {
+    const table = getLogContextTable(row);
+    const timeField = row.dataFrame.fields.find((f) => f.type === FieldType.time);
+    if (table === undefined || timeField === undefined) {
+      return { data: [] };
+    }
+    const limit = options?.limit ?? 10;
+    const forward = options?.direction === 'FORWARD';
+    const tieBreaker = this.settings.jsonData.logsContextTieBreaker;
+    const tieBreakerField = tieBreaker ? row.dataFrame.fields.find((f) => f.name === tieBreaker) : undefined;
+    const frame: DataFrameJSON = await this.postResource('logs/context', {
+      table,
+      timeColumn: timeField.name,
+      timeEpochNs: row.timeEpochNs,
+      labels: row.labels,
+      tieBreakerValue: tieBreakerField?.values.get(row.rowIndex),
+      before: forward ? 0 : limit,
+      after: forward ? limit : 0,
+    });
+    return { data: [dataFrameFromJSON(frame)] };
+  }
+
   async metricFindQuery(query: CHQuery | string, options: any) {
     if (this.adHocFiltersStatus === AdHocFilterStatus.none) {
       this.adHocFiltersStatus = await this.canUseAdhocFilters();
@@ -419,9 +462,45 @@ export class Datasource
         };
       });
 
-    return super.query({
-      ...request,
-      targets,
+    if (request.liveStreaming) {
+      return merge(
+        ...targets
+          .filter((t) => t.format === Format.LOGS)
+          .map((t) => this.tail(this.applyTemplateVariables(t, request.scopedVars)))
+      );
+    }
+
+    const asyncTargets = targets.filter((t) => t.async);
+    if (asyncTargets.length === 0) {
+      return super.query({
+        ...request,
+        targets,
+      });
+    }
+    const queries = asyncTargets.map((t) => ({
+      ...this.applyTemplateVariables(t, request.scopedVars),
+      datasource: this.getRef(),
+      intervalMs: request.intervalMs,
+      maxDataPoints: request.maxDataPoints,
+    }));
+    const syncTargets = targets.filter((t) => !t.async);
+    if (syncTargets.length === 0) {
+      return runAsyncQueries(this, request, queries);
+    }
+    return merge(runAsyncQueries(this, request, queries), super.query({ ...request, targets: syncTargets }));
+  }
+
+  /**
+   * Streams the lines a logs query gets as they arrive, the backend polls the query.
+   */
+  private tail(query: CHQuery): Observable<DataQueryResponse> {
+    return getGrafanaLiveSrv().getDataStream({
+      addr: {
+        scope: LiveChannelScope.DataSource,
+        namespace: this.uid,
+        path: `tail/${hashString(JSON.stringify([query.rawSql, query.tail]))}`,
+        data: { rawSql: query.rawSql, tail: query.tail },
+      },
     });
   }
 
@@ -559,6 +638,26 @@ enum TagType {
   schema,
 }
 
+/**
+ * Returns a short hash of a string, the live channels of equal queries are shared.
+ */
+export function hashString(s: string): string {
+  let hash = 5381;
+  for (let i = 0; i < s.length; i++) {
+    hash = ((hash << 5) + hash + s.charCodeAt(i)) | 0;
+  }
+  return (hash >>> 0).toString(16);
+}
+
+/**
+ * Returns the table a log line was read from, the one of the executed query.
+ */
+export function getLogContextTable(row: LogRowModel): string | undefined {
+  const sql = row.dataFrame.meta?.executedQueryString;
+  const match = sql?.match(/\bFROM\s+((?:[`"]?[\w$]+[`"]?\.)?[`"]?[\w$]+[`"]?)/i);
+  return match ? match[1].replace(/[`"]/g, '') : undefined;
+}
+
 enum AdHocFilterStatus {
   none = 0,
   enabled,
@@ -0,0 +1,54 @@
+import { dateTime, DataQueryRequest, DataQueryResponse, LoadingState } from '@grafana/data';
+import { CHQuery, Format, QueryType } from 'types';
+import { runAsyncQueries } from './async';
+
+const request = {
+  requestId: 'r1',
+  range: { from: dateTime(1693562400000), to: dateTime(1693566000000) },
+} as unknown as DataQueryRequest<CHQuery>;
+const queries: CHQuery[] = [{ refId: 'A', queryType: QueryType.SQL, rawSql: 'SELECT 1', format: Format.TABLE }];
+
+const frame = { schema: { fields: [{ name: 'n', type: 'number' }] }, data: { values: [[1]] } };
+
+describe('runAsyncQueries', () => {
+  it('submits the queries and emits their results once done', async () => {
+    const ds = {
+      postResource: jest.fn().mockResolvedValue({ id: 'q1' }),
+      getResource: jest.fn().mockResolvedValue({ id: 'q1', state: 'done', results: { results: { A: { frames: [frame] } } } }),
+    };
+    const responses: DataQueryResponse[] = [];
+    await new Promise<void>((resolve, reject) =>
+      runAsyncQueries(ds as any, request, queries).subscribe({
+        next: (res) => responses.push(res),
+        complete: resolve,
+        error: reject,
+      })
+    );
+    expect(ds.postResource).toHaveBeenCalledWith('async/submit', {
+      from: '1693562400000',
+      to: '1693566000000',
+      queries,
+    });
+    expect(ds.getResource).toHaveBeenCalledWith('async/poll', { id: 'q1' });
+    expect(responses).toHaveLength(1);
+    expect(responses[0].state).toBe(LoadingState.Done);
+    expect(responses[0].data[0].fields[0].name).toBe('n');
+  });
+
+  it('cancels the queries when unsubscribed', async () => {
+    let polled: () => void;
+    const running = new Promise<void>((resolve) => (polled = resolve));
+    const ds = {
+      postResource: jest.fn().mockResolvedValue({ id: 'q1' }),
+      getResource: jest.fn().mockImplementation(() => {
+        polled();
+        return Promise.resolve({ id: 'q1', state: 'running', results: { results: {} } });
+      }),
+    };
+    const subscription = runAsyncQueries(ds as any, request, queries).subscribe();
+    await running;
+    await Promise.resolve();
+    subscription.unsubscribe();
+    expect(ds.postResource).toHaveBeenLastCalledWith('async/cancel', { id: 'q1' });
+  });
+});
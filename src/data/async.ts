@@ -0,0 +1,88 @@
+import { DataQueryRequest, DataQueryResponse, LoadingState } from '@grafana/data';
+import { BackendDataSourceResponse, DataSourceWithBackend, toDataQueryResponse } from '@grafana/runtime';
+import { Observable } from 'rxjs';
+import { CHQuery } from '../types';
+
+// milliseconds between two polls of an async query
+export const ASYNC_POLL_INTERVAL = 1000;
+
+export interface AsyncQueryProgress {
+  // id of the query on the server
+  queryId?: string;
+  rowsRead: number;
+  bytesRead: number;
+  elapsedMs: number;
+}
+
+export interface AsyncQueryStatus {
+  id: string;
+  state: 'running' | 'done' | 'cancelled';
+  // progress of the queries still running, by refId
+  progress?: Record<string, AsyncQueryProgress>;
+  // responses of the queries that finished
+  results?: BackendDataSourceResponse;
+}
+
+type ResourceClient = Pick<DataSourceWithBackend, 'getResource' | 'postResource'>;
+
+/**
+ * Submits the queries to run in the backend and polls them, emitting the results of the queries that finished
+ * until all have. Unsubscribing cancels the queries.
+ */
+export function runAsyncQueries(
+  ds: ResourceClient,
+  request: DataQueryRequest<CHQuery>,
+  queries: CHQuery[]
+): Observable<DataQueryResponse> {
+  return new Observable<DataQueryResponse>((subscriber) => {
+    let id: string | undefined;
+    let timer: ReturnType<typeof setTimeout> | undefined;
+    let finished = false;
+
+    const poll = async () => {
+      try {
+        const status: AsyncQueryStatus = await ds.getResource('async/poll', { id });
+        const response = toDataQueryResponse({ data: status.results ?? { results: {} } }, queries);
+        if (status.state === 'running') {
+          subscriber.next({ ...response, key: request.requestId, state: LoadingState.Loading });
+          timer = setTimeout(poll, ASYNC_POLL_INTERVAL);
+          return;
+        }
+        finished = true;
+        const state = status.state === 'done' ? LoadingState.Done : LoadingState.Error;
+        subscriber.next({ ...response, key: request.requestId, state });
+        subscriber.complete();
+      } catch (err) {
+        finished = true;
+        subscriber.error(err);
+      }
+    };
+
+    ds.postResource('async/submit', {
+      from: String(request.range.from.valueOf()),
+      to: String(request.range.to.valueOf()),
+      queries,
+    })
+      .then((res: { id: string }) => {
+        id = res.id;
+        if (subscriber.closed) {
+          ds.postResource('async/cancel', { id }).catch(() => {});
+          return;
+        }
+        poll();
+      })
+      .catch((err) => {
+        finished = true;
+        subscriber.error(err);
+      });
+
+    return () => {
+      if (timer) {
+        clearTimeout(timer);
+      }
+      if (id && !finished) {
+        ds.postResource('async/cancel', { id }).catch(() => {});
+      }
+    };
+  });
+}
@@ -64,10 +64,17 @@ export function queryLogsVolume<TQuery extends DataQuery, TOptions extends DataS
 
     const subscription = queryObservable.subscribe({
       complete: () => {
-        const aggregatedLogsVolume = aggregateRawLogsVolume(rawLogsVolume);
+        // the backend returns the volume of raw SQL queries as bars already
+        const backendLogsVolume = rawLogsVolume.filter(isBackendLogsVolume);
+        const aggregatedLogsVolume = [
+          ...backendLogsVolume,
+          ...aggregateRawLogsVolume(rawLogsVolume.filter((frame) => !isBackendLogsVolume(frame))),
+        ];
         if (aggregatedLogsVolume[0]) {
           aggregatedLogsVolume[0].meta = {
+            ...aggregatedLogsVolume[0].meta,
             custom: {
+              ...aggregatedLogsVolume[0].meta?.custom,
               targets: options.targets,
               absoluteRange: { from: options.range.from.valueOf(), to: options.range.to.valueOf() },
             },
@@ -108,6 +115,10 @@ export function queryLogsVolume<TQuery extends DataQuery, TOptions extends DataS
   });
 }
 
+function isBackendLogsVolume(frame: DataFrame): boolean {
+  return frame.meta?.custom?.logsVolumeType !== undefined;
+}
+
 /**
  * Take multiple data frames, sum up values and group by level.
  * Return a list of data frames, each representing single level.
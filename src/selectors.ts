@@ -68,11 +68,125 @@ export const Components = {
       label: 'Enable Map Field Flatten',
       tooltip: 'Enable Map Field Flatten',
     },
+    LogsMapFieldFlattenSampleRows: {
+      label: 'Map Flatten Sample Rows',
+      placeholder: 'all rows',
+      tooltip: 'Number of rows the keys of flattened map fields are collected from, all rows when empty',
+    },
+    LogsMapFieldFlattenMaxDepth: {
+      label: 'Map Flatten Max Depth',
+      placeholder: 'no limit',
+      tooltip: 'Number of nested object levels turned into fields, deeper objects stay JSON',
+    },
+    LogsMapFieldFlattenStrict: {
+      label: 'Strict Map Flatten',
+      tooltip: 'Fail the query on map values that cannot be flattened instead of keeping them as JSON',
+    },
+    EnableLogsEnrichment: {
+      label: 'Enrich Logs',
+      tooltip: 'Add level, id and labels fields to logs results, for log level colours and de-duplication',
+    },
+    LogsLevelColumns: {
+      label: 'Logs Level Columns',
+      placeholder: 'level,severity,severity_text,lvl',
+      tooltip: 'Comma separated columns the level of a log line is read from',
+    },
+    LogsLevelPattern: {
+      label: 'Logs Level Pattern',
+      placeholder: '(?i)\\b(error|warn|info|debug|trace)\\b',
+      tooltip: 'Regular expression finding the level in the log line when there is no level column, its first group is the level',
+    },
+    LogsBodyColumn: {
+      label: 'Logs Body Column',
+      placeholder: 'body',
+      tooltip: 'Column holding the log line, body, message or msg by default',
+    },
+    LogsLabelsMaxCardinality: {
+      label: 'Logs Labels Cardinality',
+      placeholder: '10',
+      tooltip: 'String columns with at most this many distinct values in results of 50 lines or more become labels, none do when 0',
+    },
+    LogsLabelColumns: {
+      label: 'Logs Label Columns',
+      placeholder: 'service,host',
+      tooltip: 'Comma separated columns that are the labels of log lines, instead of those with few distinct values',
+    },
+    LogsContextTieBreaker: {
+      label: 'Logs Context Tie-breaker',
+      placeholder: 'id',
+      tooltip: 'Column ordering log lines sharing a timestamp when showing the context of a line',
+    },
+    LogsTailMinInterval: {
+      label: 'Live Tail Interval',
+      placeholder: '5',
+      tooltip: 'Minimum number of seconds between two polls of a live tail',
+    },
+    LogsTailMaxStreams: {
+      label: 'Live Tail Streams',
+      placeholder: '10',
+      tooltip: 'Maximum number of live tails the datasource runs at once',
+    },
+    MaxResultRows: {
+      label: 'Max Result Rows',
+      placeholder: '0',
+      tooltip: 'Rows after this many are dropped from the result of a query, and the query is stopped. No limit when 0',
+    },
+    MaxResultBytes: {
+      label: 'Max Result Bytes',
+      placeholder: '0',
+      tooltip: 'Rows after this many bytes of values are dropped from the result of a query, and the query is stopped. No limit when 0',
+    },
+    LockResultLimits: {
+      label: 'Lock Result Limits',
+      tooltip: 'Queries can only lower the result limits of the datasource, not raise them',
+    },
+    MaxConcurrentQueries: {
+      label: 'Max Concurrent Queries',
+      placeholder: '0',
+      tooltip: 'Maximum number of queries the datasource runs at once, the others wait for their turn. No limit when 0',
+    },
+    MaxQueuedQueries: {
+      label: 'Max Queued Queries',
+      placeholder: '0',
+      tooltip: 'Maximum number of queries waiting for their turn, queries beyond fail as the datasource is busy',
+    },
+    MaxQueryRetries: {
+      label: 'Max Query Retries',
+      placeholder: '2',
+      tooltip: 'Times a read query runs again when it fails on a transient error, like a warehouse resuming. No retries when 0',
+    },
+    EnableResultCache: {
+      label: 'Cache Results',
+      tooltip: 'Keep the results of queries for a while and answer the same queries with them. Alert rules always query',
+    },
+    ResultCacheTTL: {
+      label: 'Result Cache TTL',
+      placeholder: '60',
+      tooltip: 'Seconds the results are kept, time ranges are rounded to it so refreshed dashboards share results',
+    },
+    ResultCacheMaxBytes: {
+      label: 'Result Cache Size',
+      placeholder: '67108864',
+      tooltip: 'Memory in bytes the cached results may take, the least recently used are dropped beyond it',
+    },
+    IncrementalCacheOverlap: {
+      label: 'Incremental Overlap',
+      placeholder: '0',
+      tooltip: 'Seconds before the last bucket read that incremental queries read again on refresh, for late data',
+    },
     Timezone: {
       label: 'Timezone',
       placeholder: 'Aisa/Shanghai',
       tooltip: 'Timezone to use for date formatting',
-    }
+    },
+    TypeOverridePattern: {
+      label: 'Type',
+      placeholder: 'UInt8',
+      tooltip: 'Regular expression matching the whole Databend type name, Nullable variants included',
+    },
+    TypeOverrideFieldType: {
+      label: 'Field type',
+    },
   },
   QueryEditor: {
     CodeEditor: {
@@ -91,6 +205,19 @@ export const Components = {
         TRACE: 'Trace',
       },
     },
+    Async: {
+      label: 'Async',
+      tooltip: 'Runs the query in the background and polls it until it finishes, for queries outlasting the request timeout',
+    },
+    Cache: {
+      label: 'Cache',
+      tooltip: 'Reads the result from the cache of the datasource when it caches results, turn off for data that must be fresh',
+    },
+    Incremental: {
+      label: 'Incremental',
+      tooltip:
+        'Reads the $__timeInterval buckets of the previous refresh from the result cache and only queries the new ones. Needs the result cache of the datasource',
+    },
     Types: {
       label: 'Query Type',
       tooltip: 'Query Type',
@@ -15,7 +15,30 @@ export interface CHConfig extends DataSourceJsonData {
   queryTimeout?: string;
   timezone?: string;
   customSettings?: CHCustomSetting[];
+  typeOverrides?: CHTypeOverride[];
   enableLogsMapFieldFlatten?: boolean;
+  logsMapFieldFlattenSampleRows?: string;
+  logsMapFieldFlattenMaxDepth?: string;
+  logsMapFieldFlattenStrict?: boolean;
+  enableLogsEnrichment?: boolean;
+  logsLevelColumns?: string;
+  logsLevelPattern?: string;
+  logsBodyColumn?: string;
+  logsLabelsMaxCardinality?: string;
+  logsLabelColumns?: string;
+  logsContextTieBreaker?: string;
+  logsTailMinInterval?: string;
+  logsTailMaxStreams?: string;
+  maxResultRows?: string;
+  maxResultBytes?: string;
+  lockResultLimits?: boolean;
+  maxConcurrentQueries?: string;
+  maxQueuedQueries?: string;
+  enableResultCache?: boolean;
+  resultCacheTTL?: string;
+  resultCacheMaxBytes?: string;
+  incrementalCacheOverlap?: string;
+  maxQueryRetries?: string;
   enableSecureSocksProxy?: boolean;
 }
 
@@ -24,6 +47,14 @@ export interface CHCustomSetting {
   value: string;
 }
 
+export type CHOverrideFieldType = 'string' | 'bool' | 'int64' | 'uint64' | 'float64' | 'time' | 'json';
+
+export interface CHTypeOverride {
+  // regular expression for the whole Databend type name, e.g. UInt8 or Decimal\(.*\)
+  pattern: string;
+  fieldType: CHOverrideFieldType;
+}
+
 export interface CHSecureConfig {
   password: string;
   tlsCACert?: string;
@@ -45,7 +76,103 @@ export enum QueryType {
   Builder = 'builder',
 }
 
+export enum ArrayMode {
+  JSON = 'json',
+  Rows = 'rows',
+  // one field per index of numeric arrays, col[1], col[2]..., null past the end of shorter arrays
+  Vector = 'vector',
+}
+
+export enum TupleMode {
+  JSON = 'json',
+  Fields = 'fields',
+}
+
+export enum BinaryMode {
+  Hex = 'hex',
+  Base64 = 'base64',
+}
+
+export enum BitmapMode {
+  Cardinality = 'cardinality',
+  Members = 'members',
+}
+
+export enum DateMode {
+  Time = 'time',
+  String = 'string',
+}
+
+export interface FormatOptions {
+  // how Array columns become fields: JSON, one row per element (ARRAY JOIN) or one field per index
+  arrayMode?: ArrayMode;
+  // how Tuple columns become fields: JSON or one typed field per element
+  tupleMode?: TupleMode;
+  // how Binary columns are encoded, hex by default
+  binaryMode?: BinaryMode;
+  // whether Bitmap columns show their cardinality (default) or their members
+  bitmapMode?: BitmapMode;
+  // whether Date columns are times at midnight UTC (default) or YYYY-MM-DD strings
+  dateMode?: DateMode;
+}
+
+export interface FlattenOptions {
+  // JSON/map columns to flatten, all of them when empty
+  columns?: string[];
+  // glob patterns for the key paths to flatten, e.g. http.*; exclude wins over include
+  include?: string[];
+  exclude?: string[];
+  // maximum number of fields flattening adds to a frame
+  maxFields?: number;
+}
+
+export interface TraceOptions {
+  // trace frame field (traceID, spanID, parentSpanID, serviceName, operationName, startTime, duration, tags,
+  // serviceTags, logs) to the column holding it
+  columns?: Record<string, string>;
+  // unit of the duration column, ms by default
+  durationUnit?: 'ns' | 'us' | 'ms' | 's';
+}
+
+export interface LogsVolumeOptions {
+  // column the log lines are bucketed by, the first time column when empty
+  timeColumn?: string;
+  // column the log lines are grouped by, the first of the datasource's level columns when empty
+  levelColumn?: string;
+}
+
+export interface TailOptions {
+  // column new log lines are found by, the first time column when empty
+  timeColumn?: string;
+  // seconds between two polls, never below the datasource's minimum interval
+  interval?: number;
+}
+
+export interface ResultLimits {
+  // rows returned at most, the datasource's limit when unset
+  maxRows?: number;
+  // bytes of values returned at most, the datasource's limit when unset
+  maxBytes?: number;
+}
+
 export interface CHQueryBase extends DataQuery {
+  formatOptions?: FormatOptions;
+  // flattens the map fields of table and logs results when set
+  flatten?: FlattenOptions;
+  // maps the columns of trace format results to the trace frame fields
+  trace?: TraceOptions;
+  // turns a logs query into the query of its volume histogram, computed by the backend
+  logsVolume?: LogsVolumeOptions;
+  // controls the live tail of logs queries in Explore
+  tail?: TailOptions;
+  // caps the result, only below the datasource's limits when it locks them
+  limits?: ResultLimits;
+  // runs the query in the background, the frontend polls it until it finishes
+  async?: boolean;
+  // runs the query even when the datasource cached its result
+  noCache?: boolean;
+  // reads the $__timeInterval buckets of previous refreshes from the cache, querying only the new ones
+  incremental?: boolean;
 }
 
 export interface CHSQLQuery extends CHQueryBase {
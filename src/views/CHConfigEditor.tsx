@@ -13,10 +13,13 @@ import {
   InlineFieldRow,
   InlineField,
   Input,
+  Select,
 } from '@grafana/ui';
 import { CertificationKey } from '../components/ui/CertificationKey';
 import { Components } from './../selectors';
-import { CHConfig, CHCustomSetting, CHSecureConfig } from './../types';
+import { CHConfig, CHCustomSetting, CHOverrideFieldType, CHSecureConfig, CHTypeOverride } from './../types';
+
+const overrideFieldTypes: CHOverrideFieldType[] = ['string', 'bool', 'int64', 'uint64', 'float64', 'time', 'json'];
 
 export interface Props extends DataSourcePluginOptionsEditorProps<CHConfig> {}
 
@@ -56,7 +59,19 @@ export const ConfigEditor: React.FC<Props> = (props) => {
       },
     });
   };
-  const onSwitchToggle = (key: keyof Pick<CHConfig, 'validate' | 'enableSecureSocksProxy' | 'enableLogsMapFieldFlatten'>, value: boolean) => {
+  const onSwitchToggle = (
+    key: keyof Pick<
+      CHConfig,
+      | 'validate'
+      | 'enableSecureSocksProxy'
+      | 'enableLogsMapFieldFlatten'
+      | 'logsMapFieldFlattenStrict'
+      | 'enableLogsEnrichment'
+      | 'lockResultLimits'
+      | 'enableResultCache'
+    >,
+    value: boolean
+  ) => {
     onOptionsChange({
       ...options,
       jsonData: {
@@ -113,6 +128,18 @@ export const ConfigEditor: React.FC<Props> = (props) => {
 
   const [customSettings, setCustomSettings] = useState(jsonData.customSettings || []);
 
+  const onTypeOverridesChange = (typeOverrides: CHTypeOverride[]) => {
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...options.jsonData,
+        typeOverrides: typeOverrides.filter((o) => !!o.pattern && !!o.fieldType),
+      },
+    });
+  };
+
+  const [typeOverrides, setTypeOverrides] = useState(jsonData.typeOverrides || []);
+
   return (
     <>
       <div className="gf-form-group">
@@ -310,6 +337,288 @@ export const ConfigEditor: React.FC<Props> = (props) => {
             />
           </div>
         </div>
+        <div className="gf-form">
+          <FormField
+            labelWidth={13}
+            inputWidth={20}
+            value={jsonData.logsMapFieldFlattenSampleRows || ''}
+            onChange={onUpdateDatasourceJsonDataOption(props, 'logsMapFieldFlattenSampleRows')}
+            label={Components.ConfigEditor.LogsMapFieldFlattenSampleRows.label}
+            aria-label={Components.ConfigEditor.LogsMapFieldFlattenSampleRows.label}
+            placeholder={Components.ConfigEditor.LogsMapFieldFlattenSampleRows.placeholder}
+            tooltip={Components.ConfigEditor.LogsMapFieldFlattenSampleRows.tooltip}
+            type="number"
+          />
+        </div>
+        <div className="gf-form">
+          <FormField
+            labelWidth={13}
+            inputWidth={20}
+            value={jsonData.logsMapFieldFlattenMaxDepth || ''}
+            onChange={onUpdateDatasourceJsonDataOption(props, 'logsMapFieldFlattenMaxDepth')}
+            label={Components.ConfigEditor.LogsMapFieldFlattenMaxDepth.label}
+            aria-label={Components.ConfigEditor.LogsMapFieldFlattenMaxDepth.label}
+            placeholder={Components.ConfigEditor.LogsMapFieldFlattenMaxDepth.placeholder}
+            tooltip={Components.ConfigEditor.LogsMapFieldFlattenMaxDepth.tooltip}
+            type="number"
+          />
+        </div>
+        <div className="gf-form">
+          <InlineFormLabel width={13} tooltip={Components.ConfigEditor.LogsMapFieldFlattenStrict.tooltip}>
+            {Components.ConfigEditor.LogsMapFieldFlattenStrict.label}
+          </InlineFormLabel>
+          <div style={switchContainerStyle}>
+            <Switch
+              className="gf-form"
+              value={jsonData.logsMapFieldFlattenStrict || false}
+              onChange={(e) => onSwitchToggle('logsMapFieldFlattenStrict', e.currentTarget.checked)}
+            />
+          </div>
+        </div>
+        <div className="gf-form">
+          <InlineFormLabel width={13} tooltip={Components.ConfigEditor.EnableLogsEnrichment.tooltip}>
+            {Components.ConfigEditor.EnableLogsEnrichment.label}
+          </InlineFormLabel>
+          <div style={switchContainerStyle}>
+            <Switch
+              className="gf-form"
+              value={jsonData.enableLogsEnrichment || false}
+              onChange={(e) => onSwitchToggle('enableLogsEnrichment', e.currentTarget.checked)}
+            />
+          </div>
+        </div>
+        <div className="gf-form">
+          <FormField
+            labelWidth={13}
+            inputWidth={20}
+            value={jsonData.logsLevelColumns || ''}
+            onChange={onUpdateDatasourceJsonDataOption(props, 'logsLevelColumns')}
+            label={Components.ConfigEditor.LogsLevelColumns.label}
+            aria-label={Components.ConfigEditor.LogsLevelColumns.label}
+            placeholder={Components.ConfigEditor.LogsLevelColumns.placeholder}
+            tooltip={Components.ConfigEditor.LogsLevelColumns.tooltip}
+            type="text"
+          />
+        </div>
+        <div className="gf-form">
+          <FormField
+            labelWidth={13}
+            inputWidth={20}
+            value={jsonData.logsLevelPattern || ''}
+            onChange={onUpdateDatasourceJsonDataOption(props, 'logsLevelPattern')}
+            label={Components.ConfigEditor.LogsLevelPattern.label}
+            aria-label={Components.ConfigEditor.LogsLevelPattern.label}
+            placeholder={Components.ConfigEditor.LogsLevelPattern.placeholder}
+            tooltip={Components.ConfigEditor.LogsLevelPattern.tooltip}
+            type="text"
+          />
+        </div>
+        <div className="gf-form">
+          <FormField
+            labelWidth={13}
+            inputWidth={20}
+            value={jsonData.logsBodyColumn || ''}
+            onChange={onUpdateDatasourceJsonDataOption(props, 'logsBodyColumn')}
+            label={Components.ConfigEditor.LogsBodyColumn.label}
+            aria-label={Components.ConfigEditor.LogsBodyColumn.label}
+            placeholder={Components.ConfigEditor.LogsBodyColumn.placeholder}
+            tooltip={Components.ConfigEditor.LogsBodyColumn.tooltip}
+            type="text"
+          />
+        </div>
+        <div className="gf-form">
+          <FormField
+            labelWidth={13}
+            inputWidth={20}
+            value={jsonData.logsLabelsMaxCardinality || ''}
+            onChange={onUpdateDatasourceJsonDataOption(props, 'logsLabelsMaxCardinality')}
+            label={Components.ConfigEditor.LogsLabelsMaxCardinality.label}
+            aria-label={Components.ConfigEditor.LogsLabelsMaxCardinality.label}
+            placeholder={Components.ConfigEditor.LogsLabelsMaxCardinality.placeholder}
+            tooltip={Components.ConfigEditor.LogsLabelsMaxCardinality.tooltip}
+            type="number"
+          />
+        </div>
+        <div className="gf-form">
+          <FormField
+            labelWidth={13}
+            inputWidth={20}
+            value={jsonData.logsLabelColumns || ''}
+            onChange={onUpdateDatasourceJsonDataOption(props, 'logsLabelColumns')}
+            label={Components.ConfigEditor.LogsLabelColumns.label}
+            aria-label={Components.ConfigEditor.LogsLabelColumns.label}
+            placeholder={Components.ConfigEditor.LogsLabelColumns.placeholder}
+            tooltip={Components.ConfigEditor.LogsLabelColumns.tooltip}
+            type="text"
+          />
+        </div>
+        <div className="gf-form">
+          <FormField
+            labelWidth={13}
+            inputWidth={20}
+            value={jsonData.logsContextTieBreaker || ''}
+            onChange={onUpdateDatasourceJsonDataOption(props, 'logsContextTieBreaker')}
+            label={Components.ConfigEditor.LogsContextTieBreaker.label}
+            aria-label={Components.ConfigEditor.LogsContextTieBreaker.label}
+            placeholder={Components.ConfigEditor.LogsContextTieBreaker.placeholder}
+            tooltip={Components.ConfigEditor.LogsContextTieBreaker.tooltip}
+            type="text"
+          />
+        </div>
+        <div className="gf-form">
+          <FormField
+            labelWidth={13}
+            inputWidth={20}
+            value={jsonData.logsTailMinInterval || ''}
+            onChange={onUpdateDatasourceJsonDataOption(props, 'logsTailMinInterval')}
+            label={Components.ConfigEditor.LogsTailMinInterval.label}
+            aria-label={Components.ConfigEditor.LogsTailMinInterval.label}
+            placeholder={Components.ConfigEditor.LogsTailMinInterval.placeholder}
+            tooltip={Components.ConfigEditor.LogsTailMinInterval.tooltip}
+            type="number"
+          />
+        </div>
+        <div className="gf-form">
+          <FormField
+            labelWidth={13}
+            inputWidth={20}
+            value={jsonData.logsTailMaxStreams || ''}
+            onChange={onUpdateDatasourceJsonDataOption(props, 'logsTailMaxStreams')}
+            label={Components.ConfigEditor.LogsTailMaxStreams.label}
+            aria-label={Components.ConfigEditor.LogsTailMaxStreams.label}
+            placeholder={Components.ConfigEditor.LogsTailMaxStreams.placeholder}
+            tooltip={Components.ConfigEditor.LogsTailMaxStreams.tooltip}
+            type="number"
+          />
+        </div>
+        <div className="gf-form">
+          <FormField
+            labelWidth={13}
+            inputWidth={20}
+            value={jsonData.maxResultRows || ''}
+            onChange={onUpdateDatasourceJsonDataOption(props, 'maxResultRows')}
+            label={Components.ConfigEditor.MaxResultRows.label}
+            aria-label={Components.ConfigEditor.MaxResultRows.label}
+            placeholder={Components.ConfigEditor.MaxResultRows.placeholder}
+            tooltip={Components.ConfigEditor.MaxResultRows.tooltip}
+            type="number"
+          />
+        </div>
+        <div className="gf-form">
+          <FormField
+            labelWidth={13}
+            inputWidth={20}
+            value={jsonData.maxResultBytes || ''}
+            onChange={onUpdateDatasourceJsonDataOption(props, 'maxResultBytes')}
+            label={Components.ConfigEditor.MaxResultBytes.label}
+            aria-label={Components.ConfigEditor.MaxResultBytes.label}
+            placeholder={Components.ConfigEditor.MaxResultBytes.placeholder}
+            tooltip={Components.ConfigEditor.MaxResultBytes.tooltip}
+            type="number"
+          />
+        </div>
+        <div className="gf-form">
+          <InlineFormLabel width={13} tooltip={Components.ConfigEditor.LockResultLimits.tooltip}>
+            {Components.ConfigEditor.LockResultLimits.label}
+          </InlineFormLabel>
+          <div style={switchContainerStyle}>
+            <Switch
+              className="gf-form"
+              value={jsonData.lockResultLimits || false}
+              onChange={(e) => onSwitchToggle('lockResultLimits', e.currentTarget.checked)}
+            />
+          </div>
+        </div>
+        <div className="gf-form">
+          <FormField
+            labelWidth={13}
+            inputWidth={20}
+            value={jsonData.maxConcurrentQueries || ''}
+            onChange={onUpdateDatasourceJsonDataOption(props, 'maxConcurrentQueries')}
+            label={Components.ConfigEditor.MaxConcurrentQueries.label}
+            aria-label={Components.ConfigEditor.MaxConcurrentQueries.label}
+            placeholder={Components.ConfigEditor.MaxConcurrentQueries.placeholder}
+            tooltip={Components.ConfigEditor.MaxConcurrentQueries.tooltip}
+            type="number"
+          />
+        </div>
+        <div className="gf-form">
+          <FormField
+            labelWidth={13}
+            inputWidth={20}
+            value={jsonData.maxQueuedQueries || ''}
+            onChange={onUpdateDatasourceJsonDataOption(props, 'maxQueuedQueries')}
+            label={Components.ConfigEditor.MaxQueuedQueries.label}
+            aria-label={Components.ConfigEditor.MaxQueuedQueries.label}
+            placeholder={Components.ConfigEditor.MaxQueuedQueries.placeholder}
+            tooltip={Components.ConfigEditor.MaxQueuedQueries.tooltip}
+            type="number"
+          />
+        </div>
+        <div className="gf-form">
+          <FormField
+            labelWidth={13}
+            inputWidth={20}
+            value={jsonData.maxQueryRetries || ''}
+            onChange={onUpdateDatasourceJsonDataOption(props, 'maxQueryRetries')}
+            label={Components.ConfigEditor.MaxQueryRetries.label}
+            aria-label={Components.ConfigEditor.MaxQueryRetries.label}
+            placeholder={Components.ConfigEditor.MaxQueryRetries.placeholder}
+            tooltip={Components.ConfigEditor.MaxQueryRetries.tooltip}
+            type="number"
+          />
+        </div>
+        <div className="gf-form">
+          <InlineFormLabel width={13} tooltip={Components.ConfigEditor.EnableResultCache.tooltip}>
+            {Components.ConfigEditor.EnableResultCache.label}
+          </InlineFormLabel>
+          <div style={switchContainerStyle}>
+            <Switch
+              className="gf-form"
+              value={jsonData.enableResultCache || false}
+              onChange={(e) => onSwitchToggle('enableResultCache', e.currentTarget.checked)}
+            />
+          </div>
+        </div>
+        <div className="gf-form">
+          <FormField
+            labelWidth={13}
+            inputWidth={20}
+            value={jsonData.resultCacheTTL || ''}
+            onChange={onUpdateDatasourceJsonDataOption(props, 'resultCacheTTL')}
+            label={Components.ConfigEditor.ResultCacheTTL.label}
+            aria-label={Components.ConfigEditor.ResultCacheTTL.label}
+            placeholder={Components.ConfigEditor.ResultCacheTTL.placeholder}
+            tooltip={Components.ConfigEditor.ResultCacheTTL.tooltip}
+            type="number"
+          />
+        </div>
+        <div className="gf-form">
+          <FormField
+            labelWidth={13}
+            inputWidth={20}
+            value={jsonData.resultCacheMaxBytes || ''}
+            onChange={onUpdateDatasourceJsonDataOption(props, 'resultCacheMaxBytes')}
+            label={Components.ConfigEditor.ResultCacheMaxBytes.label}
+            aria-label={Components.ConfigEditor.ResultCacheMaxBytes.label}
+            placeholder={Components.ConfigEditor.ResultCacheMaxBytes.placeholder}
+            tooltip={Components.ConfigEditor.ResultCacheMaxBytes.tooltip}
+            type="number"
+          />
+        </div>
+        <div className="gf-form">
+          <FormField
+            labelWidth={13}
+            inputWidth={20}
+            value={jsonData.incrementalCacheOverlap || ''}
+            onChange={onUpdateDatasourceJsonDataOption(props, 'incrementalCacheOverlap')}
+            label={Components.ConfigEditor.IncrementalCacheOverlap.label}
+            aria-label={Components.ConfigEditor.IncrementalCacheOverlap.label}
+            placeholder={Components.ConfigEditor.IncrementalCacheOverlap.placeholder}
+            tooltip={Components.ConfigEditor.IncrementalCacheOverlap.tooltip}
+            type="number"
+          />
+        </div>
         <div className="gf-form">
           <InlineFormLabel width={13} tooltip={Components.ConfigEditor.Validate.tooltip}>
             {Components.ConfigEditor.Validate.label}
@@ -372,6 +681,68 @@ export const ConfigEditor: React.FC<Props> = (props) => {
           Add custom setting
         </Button>
       </div>
+      <div className="gf-form-group">
+        <h3>Type Overrides</h3>
+        <br />
+        {typeOverrides.map(({ pattern, fieldType }, i) => {
+          return (
+            <InlineFieldRow key={i}>
+              <InlineField
+                label={Components.ConfigEditor.TypeOverridePattern.label}
+                tooltip={Components.ConfigEditor.TypeOverridePattern.tooltip}
+              >
+                <Input
+                  value={pattern}
+                  placeholder={Components.ConfigEditor.TypeOverridePattern.placeholder}
+                  onChange={(changeEvent: ChangeEvent<HTMLInputElement>) => {
+                    let newOverrides = typeOverrides.concat();
+                    newOverrides[i] = { pattern: changeEvent.target.value, fieldType };
+                    setTypeOverrides(newOverrides);
+                  }}
+                  onBlur={() => {
+                    onTypeOverridesChange(typeOverrides);
+                  }}
+                ></Input>
+              </InlineField>
+              <InlineField label={Components.ConfigEditor.TypeOverrideFieldType.label}>
+                <Select<CHOverrideFieldType>
+                  width={20}
+                  value={fieldType}
+                  options={overrideFieldTypes.map((t) => ({ label: t, value: t }))}
+                  onChange={(e) => {
+                    let newOverrides = typeOverrides.concat();
+                    newOverrides[i] = { pattern, fieldType: e.value! };
+                    setTypeOverrides(newOverrides);
+                    onTypeOverridesChange(newOverrides);
+                  }}
+                />
+              </InlineField>
+              <Button
+                variant="secondary"
+                icon="trash-alt"
+                type="button"
+                aria-label="Remove type override"
+                onClick={() => {
+                  const newOverrides = typeOverrides.filter((_, j) => j !== i);
+                  setTypeOverrides(newOverrides);
+                  onTypeOverridesChange(newOverrides);
+                }}
+              />
+            </InlineFieldRow>
+          );
+        })}
+        <br />
+        <Button
+          variant="secondary"
+          icon="plus"
+          type="button"
+          onClick={() => {
+            setTypeOverrides([...typeOverrides, { pattern: '', fieldType: 'string' }]);
+          }}
+        >
+          Add type override
+        </Button>
+      </div>
     </>
   );
 };
@@ -17,6 +17,9 @@ import { QueryBuilder } from 'components/queryBuilder/QueryBuilder';
 import { Preview } from 'components/queryBuilder/Preview';
 import { QueryTypeSwitcher } from 'components/QueryTypeSwitcher';
 import { FormatSelect } from '../components/FormatSelect';
+import { AsyncSwitch } from '../components/AsyncSwitch';
+import { CacheSwitch } from '../components/CacheSwitch';
+import { IncrementalSwitch } from '../components/IncrementalSwitch';
 import { Button } from '@grafana/ui';
 import { styles } from 'styles';
 import { getFormat } from 'components/editor';
@@ -124,6 +127,12 @@ export const CHQueryEditor = (props: CHQueryEditorProps) => {
         <Button onClick={() => runQuery()}>Run Query</Button>
       </div>
       <FormatSelect format={query.selectedFormat ?? Format.AUTO} onChange={onFormatChange} />
+      <AsyncSwitch value={query.async ?? false} onChange={(value) => onChange({ ...query, async: value })} />
+      <CacheSwitch value={!query.noCache} onChange={(value) => onChange({ ...query, noCache: !value })} />
+      <IncrementalSwitch
+        value={query.incremental ?? false}
+        onChange={(value) => onChange({ ...query, incremental: value })}
+      />
       <CHEditorByType {...props} />
     </>
   );